@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/config"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vsapicheck"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vscluster"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vshealthcheck"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vsmetriccheck"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vsstoragecheck"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+)
+
+/**
+builds the list of enabled checks for one target, ordered so that each check runs after the checks it
+depends on. Dependencies on checks that are not enabled are dropped. sampler is where the metrics check keeps
+earlier polls of the target
+*/
+func buildChecks(cfg *config.Config, target config.TargetConfig, sampler *vsmetriccheck.Sampler) ([]common.CheckEntry, error) {
+	integrationKey := cfg.RoutingKey(target)
+	components := map[string]common.MonitorComponent{
+		config.HealthcheckId: vshealthcheck.VSHealthCheckMonitor{
+			Admin:       target.Vidispine.AdminEndpoint(),
+			PDServiceId: integrationKey,
+			Include:     target.Checks.Healthcheck.Include,
+			Exclude:     target.Checks.Healthcheck.Exclude,
+			Thresholds:  target.Checks.Healthcheck.Thresholds,
+		},
+		config.MetricsId: vsmetriccheck.VSMetricCheck{
+			Admin:             target.Vidispine.AdminEndpoint(),
+			VidispineDbName:   target.Checks.Metrics.DatabaseName,
+			IntegrationKey:    integrationKey,
+			Thresholds:        target.Checks.Metrics.Thresholds,
+			ThreadThresholds:  target.Checks.Metrics.Threads,
+			MemoryThresholds:  target.Checks.Metrics.Memory,
+			JettyThresholds:   target.Checks.Metrics.Jetty,
+			IndexerThresholds: target.Checks.Metrics.Indexer,
+			TimerThresholds:   target.Checks.Metrics.Timers,
+			Rules:             target.Checks.Metrics.Rules,
+			Sampler:           sampler,
+		},
+	}
+
+	nodes := vscluster.Discovery{Nodes: target.Vidispine.Cluster.Nodes, Srv: target.Vidispine.Cluster.Srv}
+	if nodes.Configured() {
+		//the admin port checks go to every node, rather than whichever one the load balancer picks
+		for _, id := range []string{config.HealthcheckId, config.MetricsId} {
+			components[id] = vscluster.PerNode{
+				Check:          components[id].(common.NodeCheck),
+				Discovery:      nodes,
+				IntegrationKey: integrationKey,
+			}
+		}
+		components[config.ClusterId] = vscluster.SizeCheck{
+			Discovery:      nodes,
+			Admin:          target.Vidispine.AdminEndpoint(),
+			IntegrationKey: integrationKey,
+		}
+	}
+
+	creds := target.Vidispine.Credentials
+	if creds.User != "" && creds.Password.String() != "" {
+		components[config.ApiId] = vsapicheck.VSApiCheck{
+			Api:            target.Vidispine.ApiEndpoint(),
+			IntegrationKey: integrationKey,
+		}
+		components[config.StorageId] = vsstoragecheck.VSStorageCheck{
+			Api:              target.Vidispine.ApiEndpoint(),
+			PDIntegrationKey: integrationKey,
+			FullThreshold:    target.Checks.Storage.FullThreshold,
+		}
+	} else if target.Checks.Storage.Enabled || target.Checks.Api.Enabled {
+		log.Printf("WARNING No vidispine api user and/or password was specified for %s, can't do storage detail checks", describeTarget(target.Name))
+	}
+
+	settings := target.Checks.Settings()
+	healthChecks := make([]common.CheckEntry, 0, len(components))
+	for _, id := range config.CheckIds {
+		component, haveComponent := components[id]
+		if !haveComponent || !settings[id].Enabled {
+			continue
+		}
+		dependsOn := make([]string, 0, len(settings[id].DependsOn))
+		for _, parentId := range settings[id].DependsOn {
+			if _, parentAvailable := components[parentId]; parentAvailable && settings[parentId].Enabled {
+				dependsOn = append(dependsOn, parentId)
+			}
+		}
+		healthChecks = append(healthChecks, common.CheckEntry{
+			Id:        id,
+			Every:     settings[id].Every.Duration,
+			DependsOn: dependsOn,
+			Check:     component,
+		})
+	}
+	return common.OrderChecks(healthChecks)
+}
+
+/**
+returns how long to wait between rounds of checks, which is the shortest interval that any check needs
+*/
+func roundInterval(cfg *config.Config, targets []*target) time.Duration {
+	interval := cfg.CheckEvery.Duration
+	for _, t := range targets {
+		for _, entry := range t.Checks {
+			if entry.Every > 0 && entry.Every < interval {
+				interval = entry.Every
+			}
+		}
+	}
+	return interval
+}
+
+/**
+returns the new list of checks, re-using the existing instance of any check whose settings have not changed
+so that it keeps whatever state it has built up
+*/
+func swapChecks(existing []common.CheckEntry, updated []common.CheckEntry, targetName string) []common.CheckEntry {
+	existingById := make(map[string]common.MonitorComponent, len(existing))
+	for _, c := range existing {
+		existingById[c.Id] = c.Check
+	}
+
+	result := make([]common.CheckEntry, 0, len(updated))
+	for _, c := range updated {
+		previous, havePrevious := existingById[c.Id]
+		switch {
+		case !havePrevious:
+			log.Printf("INFO check '%s' added", qualifiedName(targetName, c.Check))
+			result = append(result, c)
+		case reflect.DeepEqual(previous, c.Check):
+			c.Check = previous
+			result = append(result, c)
+		default:
+			log.Printf("INFO check '%s' settings changed", qualifiedName(targetName, c.Check))
+			result = append(result, c)
+		}
+		delete(existingById, c.Id)
+	}
+	for _, removed := range existingById {
+		log.Printf("INFO check '%s' removed", qualifiedName(targetName, removed))
+	}
+	return result
+}
+
+/**
+returns the id of the first check in dependsOn that has failed, or "" if none have
+*/
+func failedDependency(dependsOn []string, failed map[string]bool) string {
+	for _, parentId := range dependsOn {
+		if failed[parentId] {
+			return parentId
+		}
+	}
+	return ""
+}
+
+/**
+returns the name of the check as it appears in logs and alerts, which includes the target it is run against
+if there is more than one
+*/
+func qualifiedName(targetName string, check common.MonitorComponent) string {
+	if targetName == "" {
+		return check.Name()
+	}
+	return targetName + "/" + check.Name()
+}
+
+/**
+scheduler keeps track of when each check against one target last ran, whether it could run, and the metrics it
+read, between rounds
+*/
+type scheduler struct {
+	target  string
+	lastRun map[string]time.Time
+	failed  map[string]bool
+	sampler *vsmetriccheck.Sampler //the metrics read from the target's hosts on earlier rounds
+}
+
+func newScheduler(targetName string) *scheduler {
+	return &scheduler{
+		target:  targetName,
+		lastRun: make(map[string]time.Time),
+		failed:  make(map[string]bool),
+		sampler: vsmetriccheck.NewSampler(),
+	}
+}
+
+/**
+returns true if the check is due to run at the given time
+*/
+func (s *scheduler) isDue(entry common.CheckEntry, now time.Time) bool {
+	lastRun, haveRun := s.lastRun[entry.Id]
+	//allow a little slack so that a check doesn't miss its slot because the round started slightly early
+	return !haveRun || entry.Every == 0 || now.Sub(lastRun) >= entry.Every-time.Second
+}
+
+/**
+runs every check that is due, in order, and returns the alerts they raised with consequential alerts folded
+into the alert that caused them, and namespaced with the target name. A check is skipped if a check it
+depends on could not run the last time it was tried; the skip is noted on the alerts raised by the failed
+check. Returns true if any check could not run
+*/
+func (s *scheduler) runChecks(healthChecks []common.CheckEntry, verboseMode bool) ([]common.RaisedAlert, bool) {
+	didFail := false
+	now := time.Now()
+	raisedByCheck := make(map[string][]*pagerduty.TriggerEvent, len(healthChecks))
+	skippedBy := make(map[string][]string)
+	alertParents := make(map[string]string)
+
+	for _, entry := range healthChecks {
+		check := entry.Check
+		checkName := qualifiedName(s.target, check)
+		if !s.isDue(entry, now) {
+			continue
+		}
+		s.lastRun[entry.Id] = now
+
+		if parentId := failedDependency(entry.DependsOn, s.failed); parentId != "" {
+			log.Printf("WARNING skipping '%s' as '%s' could not run", checkName, parentId)
+			s.failed[entry.Id] = true
+			skippedBy[parentId] = append(skippedBy[parentId], check.Name())
+			continue
+		}
+
+		alerts, runErr := check.Run(verboseMode)
+		s.failed[entry.Id] = runErr != nil
+		if runErr != nil {
+			didFail = true
+			log.Printf("ERROR running '%s' failed: %s", checkName, runErr)
+		}
+		if alerts != nil && len(alerts) > 0 {
+			log.Printf("WARNING %s returned %d alerts: ", checkName, len(alerts))
+			raisedByCheck[entry.Id] = alerts
+		}
+		if deps, haveDeps := check.(common.AlertDependencies); haveDeps {
+			for child, parent := range deps.AlertDependsOn() {
+				alertParents[child] = parent
+			}
+		}
+	}
+
+	raised := make([]common.RaisedAlert, 0)
+	for _, entry := range healthChecks {
+		for _, alert := range raisedByCheck[entry.Id] {
+			if skipped, haveSkipped := skippedBy[entry.Id]; haveSkipped {
+				alert.Payload.Summary = fmt.Sprintf("%s; skipped dependent checks: %s", alert.Payload.Summary, strings.Join(skipped, ", "))
+			}
+			checkName := qualifiedName(s.target, entry.Check)
+			log.Printf("WARNING [%s] %s", checkName, alert.String())
+			raised = append(raised, common.RaisedAlert{CheckName: checkName, Event: alert})
+		}
+	}
+
+	//dedup keys are only namespaced once folding is done, as the checks declare dependencies between the plain keys
+	folded := common.FoldAlerts(raised, alertParents)
+	for _, r := range folded {
+		r.Event.Namespace(s.target)
+	}
+	return folded, didFail
+}
@@ -0,0 +1,29 @@
+package common
+
+import "reflect"
+
+/**
+fills in each field of the struct that settings points to which was left unset (zero, or an empty list) with the
+same field from defaults, which must be the same type of struct. Fields tagged zero:"off" are left alone, as zero
+is how that setting is turned off rather than how it is left out
+*/
+func FillDefaults(settings interface{}, defaults interface{}) {
+	value := reflect.ValueOf(settings).Elem()
+	defaultValue := reflect.ValueOf(defaults)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		if value.Type().Field(i).Tag.Get("zero") == "off" || !isUnset(field) {
+			continue
+		}
+		field.Set(defaultValue.Field(i))
+	}
+}
+
+func isUnset(field reflect.Value) bool {
+	switch field.Kind() {
+	case reflect.Slice, reflect.Map:
+		return field.Len() == 0
+	default:
+		return field.IsZero()
+	}
+}
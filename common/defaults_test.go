@@ -0,0 +1,25 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFillDefaults(t *testing.T) {
+	type limits struct {
+		Warning float64
+		After   time.Duration
+		Names   []string
+		Polls   int `zero:"off"`
+	}
+	defaults := limits{Warning: 0.8, After: time.Minute, Names: []string{"dw"}, Polls: 6}
+
+	settings := limits{Warning: 0.5, Names: []string{}}
+	FillDefaults(&settings, defaults)
+	if settings.Warning != 0.5 || settings.After != time.Minute || len(settings.Names) != 1 {
+		t.Errorf("expected only the unset values to be filled in, got %+v", settings)
+	}
+	if settings.Polls != 0 {
+		t.Errorf("a field where zero means off should be left at zero, got %d", settings.Polls)
+	}
+}
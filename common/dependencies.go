@@ -0,0 +1,137 @@
+package common
+
+import (
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+	"strings"
+	"time"
+)
+
+/**
+CheckEntry is a check to run along with a short stable id, how often to run it and the ids of the checks it
+depends on. If a check it depends on could not run, this check is skipped
+*/
+type CheckEntry struct {
+	Id        string
+	Every     time.Duration //zero means every round
+	DependsOn []string
+	Check     MonitorComponent
+}
+
+/**
+AlertDependencies is optionally implemented by a MonitorComponent that raises alerts which are a
+consequence of alerts raised elsewhere, e.g. database pool exhaustion when the database itself is unhealthy
+*/
+type AlertDependencies interface {
+	AlertDependsOn() map[string]string //dedup key of a dependent alert -> dedup key of the alert that causes it
+}
+
+/**
+returns the checks ordered so that every check comes after the checks it depends on, otherwise keeping the
+order given. Returns an error if a dependency does not exist or there is a cycle
+*/
+func OrderChecks(entries []CheckEntry) ([]CheckEntry, error) {
+	byId := make(map[string]CheckEntry, len(entries))
+	for _, e := range entries {
+		byId[e.Id] = e
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(entries))
+	result := make([]CheckEntry, 0, len(entries))
+
+	var visit func(e CheckEntry, path []string) error
+	visit = func(e CheckEntry, path []string) error {
+		switch state[e.Id] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("checks depend on each other: %s", strings.Join(append(path, e.Id), " -> "))
+		}
+		state[e.Id] = visiting
+		for _, parentId := range e.DependsOn {
+			parent, haveParent := byId[parentId]
+			if !haveParent {
+				return fmt.Errorf("check '%s' depends on '%s' which is not enabled", e.Id, parentId)
+			}
+			if err := visit(parent, append(path, e.Id)); err != nil {
+				return err
+			}
+		}
+		state[e.Id] = visited
+		result = append(result, e)
+		return nil
+	}
+
+	for _, e := range entries {
+		if err := visit(e, nil); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+/**
+an alert along with the name of the check that raised it
+*/
+type RaisedAlert struct {
+	CheckName string
+	Event     *pagerduty.TriggerEvent
+}
+
+/**
+returns the dedup key of the alert that causes the given one. An alert raised for one node of a cluster
+depends on the same alert as it would on a single server, raised for the same node
+*/
+func parentOf(dedupKey string, parents map[string]string) (string, bool) {
+	if parentKey, haveParentKey := parents[dedupKey]; haveParentKey {
+		return parentKey, true
+	}
+	if sep := strings.LastIndex(dedupKey, pagerduty.NodeSeparator); sep > 0 {
+		if parentKey, haveParentKey := parents[dedupKey[:sep]]; haveParentKey {
+			return parentKey + dedupKey[sep:], true
+		}
+	}
+	return "", false
+}
+
+/**
+removes every alert whose parent alert (as given by dedup key in parents) was also raised, and adds its
+summary to the topmost raised ancestor's, so that responders see the root cause with its consequences
+listed underneath
+*/
+func FoldAlerts(raised []RaisedAlert, parents map[string]string) []RaisedAlert {
+	byDedupKey := make(map[string]*pagerduty.TriggerEvent, len(raised))
+	for _, r := range raised {
+		byDedupKey[r.Event.DeDupKey] = r.Event
+	}
+
+	rootOf := func(dedupKey string) *pagerduty.TriggerEvent {
+		var root *pagerduty.TriggerEvent
+		for i := 0; i < len(parents); i++ { //bounded, in case of a cycle in parents
+			parentKey, haveParentKey := parentOf(dedupKey, parents)
+			if !haveParentKey {
+				break
+			}
+			if parent, parentRaised := byDedupKey[parentKey]; parentRaised {
+				root = parent
+			}
+			dedupKey = parentKey
+		}
+		return root
+	}
+
+	result := make([]RaisedAlert, 0, len(raised))
+	for _, r := range raised {
+		if root := rootOf(r.Event.DeDupKey); root != nil && root != r.Event {
+			root.Payload.Summary = fmt.Sprintf("%s; also: %s", root.Payload.Summary, r.Event.Payload.Summary)
+			continue
+		}
+		result = append(result, r)
+	}
+	return result
+}
@@ -0,0 +1,112 @@
+package common
+
+import (
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeCheck struct {
+	name string
+}
+
+func (c fakeCheck) Run(verboseMode bool) ([]*pagerduty.TriggerEvent, error) {
+	return nil, nil
+}
+
+func (c fakeCheck) Name() string {
+	return c.name
+}
+
+func TestOrderChecks(t *testing.T) {
+	entries := []CheckEntry{
+		{Id: "storage", DependsOn: []string{"auth"}, Check: fakeCheck{"storage"}},
+		{Id: "metrics", DependsOn: []string{"healthcheck"}, Check: fakeCheck{"metrics"}},
+		{Id: "healthcheck", Check: fakeCheck{"healthcheck"}},
+		{Id: "auth", Check: fakeCheck{"auth"}},
+	}
+	ordered, err := OrderChecks(entries)
+	if err != nil {
+		t.Error("OrderChecks returned an unexpected error: ", err)
+		t.FailNow()
+	}
+	ids := make([]string, len(ordered))
+	for i, e := range ordered {
+		ids[i] = e.Id
+	}
+	if strings.Join(ids, ",") != "auth,storage,healthcheck,metrics" {
+		t.Errorf("got unexpected order %v", ids)
+	}
+}
+
+func TestOrderChecks_invalid(t *testing.T) {
+	_, missingErr := OrderChecks([]CheckEntry{
+		{Id: "metrics", DependsOn: []string{"healthcheck"}, Check: fakeCheck{"metrics"}},
+	})
+	if missingErr == nil {
+		t.Error("OrderChecks accepted a dependency on a missing check")
+	}
+
+	_, cycleErr := OrderChecks([]CheckEntry{
+		{Id: "a", DependsOn: []string{"b"}, Check: fakeCheck{"a"}},
+		{Id: "b", DependsOn: []string{"a"}, Check: fakeCheck{"b"}},
+	})
+	if cycleErr == nil {
+		t.Error("OrderChecks accepted a dependency cycle")
+	}
+}
+
+func TestFoldAlerts(t *testing.T) {
+	now := time.Now()
+	database := pagerduty.NewTriggerEvent("Vidispine Database", "key", pagerduty.SeverityError, "vidispine-database", "The Database check failed", &now)
+	pool := pagerduty.NewTriggerEvent("vidispine-database", "key", pagerduty.SeverityCritical, "vidispine-database-pool", "Active database connections account for over 90% of pool capacity", &now)
+	heap := pagerduty.NewTriggerEvent("vidispine-heap", "key", pagerduty.SeverityWarning, "vidispine-heap", "heap is at 80%", &now)
+
+	parents := map[string]string{"vidispine-database-pool": "vidispine-database"}
+	folded := FoldAlerts([]RaisedAlert{
+		{CheckName: "health", Event: database},
+		{CheckName: "metrics", Event: pool},
+		{CheckName: "metrics", Event: heap},
+	}, parents)
+
+	if len(folded) != 2 {
+		t.Errorf("expected 2 alerts after folding, got %d", len(folded))
+	}
+	if folded[0].Event != database || folded[1].Event != heap {
+		t.Errorf("got unexpected alerts after folding: %v", folded)
+	}
+	if database.Payload.Summary != "The Database check failed; also: Active database connections account for over 90% of pool capacity" {
+		t.Errorf("parent summary was not updated, got '%s'", database.Payload.Summary)
+	}
+
+	//without the parent, the dependent alert must still be sent
+	alone := FoldAlerts([]RaisedAlert{{CheckName: "metrics", Event: pool}}, parents)
+	if len(alone) != 1 {
+		t.Error("dependent alert was dropped when its parent was not raised")
+	}
+}
+
+func TestFoldAlerts_perNode(t *testing.T) {
+	now := time.Now()
+	database := pagerduty.NewTriggerEvent("Vidispine Database", "key", pagerduty.SeverityError, "vidispine-database", "The Database check failed", &now)
+	database.ForNode("node-1")
+	pool1 := pagerduty.NewTriggerEvent("vidispine-database", "key", pagerduty.SeverityCritical, "vidispine-database-pool", "pool is full", &now)
+	pool1.ForNode("node-1")
+	pool2 := pagerduty.NewTriggerEvent("vidispine-database", "key", pagerduty.SeverityCritical, "vidispine-database-pool", "pool is full", &now)
+	pool2.ForNode("node-2")
+
+	folded := FoldAlerts([]RaisedAlert{
+		{CheckName: "health", Event: database},
+		{CheckName: "metrics", Event: pool1},
+		{CheckName: "metrics", Event: pool2},
+	}, map[string]string{"vidispine-database-pool": "vidispine-database"})
+
+	//the pool alert on node-2 has no database alert on the same node to fold into
+	if len(folded) != 2 || folded[0].Event != database || folded[1].Event != pool2 {
+		t.Errorf("got unexpected alerts after folding: %v", folded)
+	}
+	if !strings.Contains(database.Payload.Summary, "also: On node node-1: pool is full") {
+		t.Errorf("node-1 pool alert was not folded into its database alert, got '%s'", database.Payload.Summary)
+	}
+}
@@ -0,0 +1,42 @@
+package common
+
+import (
+	"errors"
+	"time"
+)
+
+/**
+Duration is a time.Duration that is written in config files as a string, e.g. "5m".
+A value that can't be parsed is kept as an error for validation to report, so that parsing can carry on and
+find any other problems in the file
+*/
+type Duration struct {
+	time.Duration
+	parseErr error
+}
+
+/**
+returns an error if the value given in the config file was not a valid duration
+*/
+func (d Duration) Err() error {
+	return d.parseErr
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err != nil {
+		d.parseErr = errors.New("duration must be a string like \"5m\"")
+		return nil
+	}
+	parsed, err := time.ParseDuration(str)
+	if err != nil {
+		d.parseErr = err
+		return nil
+	}
+	*d = Duration{Duration: parsed}
+	return nil
+}
@@ -0,0 +1,105 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+Endpoint is the base url of one of the Vidispine services along with how to authenticate to it, so that
+Vidispine can be reached through an ingress on any port and path prefix
+*/
+type Endpoint struct {
+	BaseUrl  string            //e.g. https://vidispine.example.com/vs-admin
+	User     string            //basic auth is sent if this is set
+	Password string            //password for basic auth
+	Headers  map[string]string //added to every request, e.g. for header auth at an ingress
+	TLS      TLSSettings       //for https base urls
+
+	TokenAuth     bool          //log in with User and Password to get a session token, then send the token instead
+	TokenLifetime time.Duration //how long to ask for session tokens to last, 0 for the client's default
+	RunAs         string        //another user to make requests as, sent in the RunAs header
+}
+
+/**
+returns the shared HTTP client to make requests to the endpoint with
+*/
+func (e Endpoint) Client() (*http.Client, error) {
+	return e.TLS.Client()
+}
+
+/**
+returns the endpoint for a service on the given port of a host, which is where the services are if no base
+url is given
+*/
+func HostEndpoint(host string, port int, https bool) Endpoint {
+	proto := "https"
+	if !https {
+		proto = "http"
+	}
+	return Endpoint{BaseUrl: fmt.Sprintf("%s://%s", proto, net.JoinHostPort(host, strconv.Itoa(port)))}
+}
+
+/**
+returns the url of the given path under the base url, keeping any path prefix the base url has
+*/
+func (e Endpoint) Url(path string) (string, error) {
+	base, parseErr := url.Parse(e.BaseUrl)
+	if parseErr != nil {
+		return "", parseErr
+	}
+	if base.Scheme == "" || base.Host == "" {
+		return "", fmt.Errorf("%s is not an absolute url", e.BaseUrl)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/" + strings.TrimPrefix(path, "/")
+	base.RawPath = ""
+	return base.String(), nil
+}
+
+/**
+returns a request for the given path, with the endpoint's authentication added. Endpoints using token auth
+leave it to the caller to add the token
+*/
+func (e Endpoint) NewRequest(ctx context.Context, method string, path string) (*http.Request, error) {
+	urlStr, urlErr := e.Url(path)
+	if urlErr != nil {
+		return nil, urlErr
+	}
+	req, reqErr := http.NewRequestWithContext(ctx, method, urlStr, nil)
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	if e.User != "" && !e.TokenAuth {
+		req.SetBasicAuth(e.User, e.Password)
+	}
+	if e.RunAs != "" {
+		req.Header.Set("RunAs", e.RunAs)
+	}
+	for name, value := range e.Headers {
+		req.Header.Set(name, value)
+	}
+	return req, nil
+}
+
+/**
+returns the same endpoint on a different host, keeping the scheme, port and path, e.g. for one node of a cluster
+*/
+func (e Endpoint) ForHost(host string) Endpoint {
+	base, parseErr := url.Parse(e.BaseUrl)
+	if parseErr != nil {
+		return e
+	}
+	if port := base.Port(); port != "" {
+		base.Host = net.JoinHostPort(host, port)
+	} else {
+		base.Host = host
+	}
+	e.BaseUrl = base.String()
+	return e
+}
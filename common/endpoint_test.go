@@ -0,0 +1,88 @@
+package common
+
+import (
+	"context"
+	"testing"
+)
+
+func TestEndpoint_Url(t *testing.T) {
+	tests := []struct {
+		base     string
+		path     string
+		expected string
+	}{
+		{"http://vidispine.local:9001", "healthcheck", "http://vidispine.local:9001/healthcheck"},
+		{"https://vidispine.example.com/vs-admin/", "/metrics", "https://vidispine.example.com/vs-admin/metrics"},
+		{"https://vidispine.example.com:8443/vs", "API/storage", "https://vidispine.example.com:8443/vs/API/storage"},
+	}
+	for _, test := range tests {
+		result, err := Endpoint{BaseUrl: test.base}.Url(test.path)
+		if err != nil {
+			t.Errorf("Url(%s) on %s returned an unexpected error: %s", test.path, test.base, err)
+		} else if result != test.expected {
+			t.Errorf("Url(%s) on %s returned %s, expected %s", test.path, test.base, result, test.expected)
+		}
+	}
+
+	if _, err := (Endpoint{BaseUrl: "vidispine.local"}).Url("healthcheck"); err == nil {
+		t.Error("expected an error for a base url without a scheme")
+	}
+}
+
+func TestHostEndpoint(t *testing.T) {
+	if e := HostEndpoint("vidispine.local", 9001, false); e.BaseUrl != "http://vidispine.local:9001" {
+		t.Errorf("got unexpected base url %s", e.BaseUrl)
+	}
+	if e := HostEndpoint("vidispine.local", 8080, true); e.BaseUrl != "https://vidispine.local:8080" {
+		t.Errorf("got unexpected base url %s", e.BaseUrl)
+	}
+}
+
+func TestEndpoint_NewRequest(t *testing.T) {
+	e := Endpoint{
+		BaseUrl:  "https://vidispine.example.com/vs-admin",
+		User:     "monitor",
+		Password: "secret",
+		Headers:  map[string]string{"X-Auth-Token": "token"},
+	}
+	req, err := e.NewRequest(context.Background(), "GET", "metrics")
+	if err != nil {
+		t.Error("NewRequest returned an unexpected error: ", err)
+		t.FailNow()
+	}
+	if req.URL.String() != "https://vidispine.example.com/vs-admin/metrics" {
+		t.Errorf("got unexpected url %s", req.URL)
+	}
+	if user, password, haveAuth := req.BasicAuth(); !haveAuth || user != "monitor" || password != "secret" {
+		t.Error("basic auth was not set")
+	}
+	if req.Header.Get("X-Auth-Token") != "token" {
+		t.Error("header auth was not set")
+	}
+}
+
+func TestEndpoint_ForHost(t *testing.T) {
+	e := Endpoint{BaseUrl: "https://vidispine.example.com:9001/vs-admin", User: "monitor"}
+	node := e.ForHost("vs-node-1")
+	if node.BaseUrl != "https://vs-node-1:9001/vs-admin" || node.User != "monitor" {
+		t.Errorf("got unexpected endpoint %v", node)
+	}
+	if e.BaseUrl != "https://vidispine.example.com:9001/vs-admin" {
+		t.Error("ForHost changed the original endpoint")
+	}
+}
+
+func TestEndpoint_NewRequest_tokenAuth(t *testing.T) {
+	e := Endpoint{BaseUrl: "https://vidispine.example.com", User: "monitor", Password: "secret", TokenAuth: true, RunAs: "tenant"}
+	req, err := e.NewRequest(context.Background(), "GET", "API/storage")
+	if err != nil {
+		t.Error("NewRequest returned an unexpected error: ", err)
+		t.FailNow()
+	}
+	if _, _, haveAuth := req.BasicAuth(); haveAuth {
+		t.Error("the password should not be sent with token auth")
+	}
+	if req.Header.Get("RunAs") != "tenant" {
+		t.Errorf("expected the RunAs header, got %v", req.Header)
+	}
+}
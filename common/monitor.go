@@ -6,3 +6,12 @@ type MonitorComponent interface {
 	Run(verboseMode bool) ([]*pagerduty.TriggerEvent, error) //perform the monitor checks. Return a list of CreateIncidentRequest, for each problem identified.
 	Name() string                                            //return a descriptive name for this check
 }
+
+/**
+NodeCheck is implemented by checks that can be run against a single node of a Vidispine cluster, rather
+than whichever node the load balancer picks
+*/
+type NodeCheck interface {
+	MonitorComponent
+	ForNode(host string) MonitorComponent //returns a copy of the check that runs against the given node
+}
@@ -0,0 +1,135 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+/**
+TLSSettings says how to make https connections to Vidispine. The zero value uses the system trust store
+*/
+type TLSSettings struct {
+	CAFile     string //PEM bundle of the CAs to trust instead of the system ones
+	CertFile   string //PEM client certificate, for servers that require mutual TLS
+	KeyFile    string //PEM private key for CertFile
+	ServerName string //name to verify the server certificate against, if not the host in the url
+	MinVersion string //lowest TLS version to accept, one of 1.0, 1.1, 1.2 or 1.3
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+/**
+clients are shared between every check with the same settings, so that connections are re-used. The
+modification times of the files are part of the key so that renewed certificates are picked up
+*/
+type clientKey struct {
+	settings               TLSSettings
+	caMod, certMod, keyMod int64
+}
+
+var (
+	clientsMutex sync.Mutex
+	clients      = make(map[clientKey]*http.Client)
+)
+
+func modTime(filename string) int64 {
+	if filename == "" {
+		return 0
+	}
+	info, statErr := os.Stat(filename)
+	if statErr != nil {
+		return 0
+	}
+	return info.ModTime().UnixNano()
+}
+
+/**
+checks the settings without loading any of the files they refer to
+*/
+func (t TLSSettings) Validate() error {
+	if _, knownVersion := tlsVersions[t.MinVersion]; t.MinVersion != "" && !knownVersion {
+		return fmt.Errorf("min_version %s is not valid, expected one of 1.0, 1.1, 1.2 or 1.3", t.MinVersion)
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("a client certificate and key must be given together")
+	}
+	return nil
+}
+
+/**
+builds the tls.Config for the settings, loading any certificates they refer to
+*/
+func (t TLSSettings) Config() (*tls.Config, error) {
+	if validateErr := t.Validate(); validateErr != nil {
+		return nil, validateErr
+	}
+	config := &tls.Config{ServerName: t.ServerName, MinVersion: tlsVersions[t.MinVersion]}
+
+	if t.CAFile != "" {
+		pemBytes, readErr := ioutil.ReadFile(t.CAFile)
+		if readErr != nil {
+			return nil, fmt.Errorf("could not read CA bundle: %s", readErr)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", t.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if t.CertFile != "" {
+		cert, loadErr := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if loadErr != nil {
+			return nil, fmt.Errorf("could not load client certificate: %s", loadErr)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+/**
+returns the shared HTTP client for the settings
+*/
+func (t TLSSettings) Client() (*http.Client, error) {
+	key := clientKey{
+		settings: t,
+		caMod:    modTime(t.CAFile),
+		certMod:  modTime(t.CertFile),
+		keyMod:   modTime(t.KeyFile),
+	}
+
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	if client, haveClient := clients[key]; haveClient {
+		return client, nil
+	}
+
+	config, configErr := t.Config()
+	if configErr != nil {
+		return nil, configErr
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	client := &http.Client{Transport: transport}
+
+	//drop the client for older versions of the same files, so that connections using an old certificate are closed
+	for existing, existingClient := range clients {
+		if existing.settings == t {
+			existingClient.CloseIdleConnections()
+			delete(clients, existing)
+		}
+	}
+	clients[key] = client
+	return client, nil
+}
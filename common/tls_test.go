@@ -0,0 +1,167 @@
+package common
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func writeTempPEM(t *testing.T, blockType string, der []byte) string {
+	f, err := ioutil.TempFile("", "vsmonitor-tls")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	pem.Encode(f, &pem.Block{Type: blockType, Bytes: der})
+	f.Close()
+	return f.Name()
+}
+
+/**
+writes the CA certificate of a test server to a file, for use as a CA bundle
+*/
+func writeServerCA(t *testing.T, server *httptest.Server) string {
+	return writeTempPEM(t, "CERTIFICATE", server.Certificate().Raw)
+}
+
+/**
+generates a self-signed client certificate, returning the certificate, the cert file and the key file
+*/
+func writeClientCert(t *testing.T) (*x509.Certificate, string, string) {
+	key, keyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if keyErr != nil {
+		t.Error(keyErr)
+		t.FailNow()
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "vidispine-monitor"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, certErr := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if certErr != nil {
+		t.Error(certErr)
+		t.FailNow()
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, marshalErr := x509.MarshalECPrivateKey(key)
+	if marshalErr != nil {
+		t.Error(marshalErr)
+		t.FailNow()
+	}
+	return cert, writeTempPEM(t, "CERTIFICATE", der), writeTempPEM(t, "EC PRIVATE KEY", keyDer)
+}
+
+func getThrough(e Endpoint) error {
+	client, clientErr := e.Client()
+	if clientErr != nil {
+		return clientErr
+	}
+	req, reqErr := e.NewRequest(context.Background(), "GET", "healthcheck")
+	if reqErr != nil {
+		return reqErr
+	}
+	resp, httpErr := client.Do(req)
+	if httpErr != nil {
+		return httpErr
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestTLSSettings_caBundleAndServerName(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caFile := writeServerCA(t, server)
+	defer os.Remove(caFile)
+
+	if err := getThrough(Endpoint{BaseUrl: server.URL}); err == nil {
+		t.Error("expected the test server's certificate to be rejected by the system trust store")
+	}
+	if err := getThrough(Endpoint{BaseUrl: server.URL, TLS: TLSSettings{CAFile: caFile}}); err != nil {
+		t.Error("expected the request to succeed with the CA bundle, got ", err)
+	}
+	//the test certificate is for example.com as well as 127.0.0.1
+	if err := getThrough(Endpoint{BaseUrl: server.URL, TLS: TLSSettings{CAFile: caFile, ServerName: "example.com"}}); err != nil {
+		t.Error("expected the request to succeed with a matching server name, got ", err)
+	}
+	if err := getThrough(Endpoint{BaseUrl: server.URL, TLS: TLSSettings{CAFile: caFile, ServerName: "vidispine.local"}}); err == nil {
+		t.Error("expected the request to fail with a server name the certificate is not for")
+	}
+}
+
+func TestTLSSettings_clientCertificate(t *testing.T) {
+	clientCert, certFile, keyFile := writeClientCert(t)
+	defer os.Remove(certFile)
+	defer os.Remove(keyFile)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+	caFile := writeServerCA(t, server)
+	defer os.Remove(caFile)
+
+	if err := getThrough(Endpoint{BaseUrl: server.URL, TLS: TLSSettings{CAFile: caFile}}); err == nil {
+		t.Error("expected the request to fail without a client certificate")
+	}
+	withCert := TLSSettings{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}
+	if err := getThrough(Endpoint{BaseUrl: server.URL, TLS: withCert}); err != nil {
+		t.Error("expected the request to succeed with a client certificate, got ", err)
+	}
+}
+
+func TestTLSSettings_minVersion(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+	caFile := writeServerCA(t, server)
+	defer os.Remove(caFile)
+
+	if err := getThrough(Endpoint{BaseUrl: server.URL, TLS: TLSSettings{CAFile: caFile, MinVersion: "1.2"}}); err != nil {
+		t.Error("expected the request to succeed with TLS 1.2, got ", err)
+	}
+	if err := getThrough(Endpoint{BaseUrl: server.URL, TLS: TLSSettings{CAFile: caFile, MinVersion: "1.3"}}); err == nil {
+		t.Error("expected the request to fail when the server can't do the minimum version")
+	}
+}
+
+func TestTLSSettings_Validate(t *testing.T) {
+	if err := (TLSSettings{MinVersion: "1.4"}).Validate(); err == nil {
+		t.Error("expected an unknown min_version to be rejected")
+	}
+	if err := (TLSSettings{CertFile: "client.pem"}).Validate(); err == nil {
+		t.Error("expected a certificate without a key to be rejected")
+	}
+	if _, err := (TLSSettings{CAFile: "/nonexistent/ca.pem"}).Config(); err == nil {
+		t.Error("expected a missing CA bundle to be rejected")
+	}
+}
+
+func TestTLSSettings_Client_shared(t *testing.T) {
+	first, _ := TLSSettings{ServerName: "shared.local"}.Client()
+	second, _ := TLSSettings{ServerName: "shared.local"}.Client()
+	if first != second {
+		t.Error("expected the same settings to share a client")
+	}
+}
@@ -0,0 +1,699 @@
+package config
+
+import (
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/silence"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vshealthcheck"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vsmetriccheck"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vsstoragecheck"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ids of the checks that can be configured
+const (
+	HealthcheckId = "healthcheck"
+	MetricsId     = "metrics"
+	ApiId         = "api"
+	StorageId     = "storage"
+	ClusterId     = "cluster"
+)
+
+var CheckIds = []string{HealthcheckId, MetricsId, ApiId, StorageId, ClusterId}
+
+type PagerDutyConfig struct {
+	IntegrationKey Secret `yaml:"integration_key"` //pagerduty service ID to alert
+	ApiKey         Secret `yaml:"api_key"`         //API key to communicate with PD
+}
+
+type NotifiersConfig struct {
+	PagerDuty PagerDutyConfig `yaml:"pagerduty"`
+}
+
+type CredentialsConfig struct {
+	User          string   `yaml:"user"`
+	Password      Secret   `yaml:"password"`
+	Token         bool     `yaml:"token"`          //log in for a session token and send that instead of the password every time
+	TokenLifetime Duration `yaml:"token_lifetime"` //how long to ask for session tokens to last, defaults to 15m
+	RunAs         string   `yaml:"run_as"`         //another user to make API requests as, e.g. the tenant's own user
+}
+
+/**
+how to find each node of a clustered Vidispine, so that the admin port of every node can be checked
+*/
+type ClusterNodesConfig struct {
+	Nodes []string `yaml:"nodes"` //hostname of each node
+	Srv   string   `yaml:"srv"`   //DNS SRV record listing the nodes, e.g. the one for a headless service
+}
+
+/**
+optional authentication for the admin service, e.g. when it is behind an ingress. Either or both can be given
+*/
+type AdminAuthConfig struct {
+	User     string `yaml:"user"`     //for basic auth
+	Password Secret `yaml:"password"` //for basic auth
+	Header   string `yaml:"header"`   //name of a header to send value in, e.g. X-Auth-Token
+	Value    Secret `yaml:"value"`
+}
+
+/**
+how to make https connections to Vidispine, for both the API and the admin service
+*/
+type TLSConfig struct {
+	CAFile     string `yaml:"ca_file"`     //PEM bundle of the CAs to trust instead of the system ones
+	CertFile   string `yaml:"cert_file"`   //PEM client certificate, if the server requires mutual TLS
+	KeyFile    string `yaml:"key_file"`    //PEM private key for cert_file
+	ServerName string `yaml:"server_name"` //name to verify the server certificate against, if not the host in the url
+	MinVersion string `yaml:"min_version"` //lowest TLS version to accept: 1.0, 1.1, 1.2 or 1.3
+}
+
+func (t TLSConfig) Settings() common.TLSSettings {
+	return common.TLSSettings{
+		CAFile:     t.CAFile,
+		CertFile:   t.CertFile,
+		KeyFile:    t.KeyFile,
+		ServerName: t.ServerName,
+		MinVersion: t.MinVersion,
+	}
+}
+
+type VidispineConfig struct {
+	Host         string             `yaml:"host"`          //hostname to query, if api_url and admin_url are not given
+	MonitorHttps bool               `yaml:"monitor_https"` //true if the 9001 monitoring port is https protected
+	ApiHttps     bool               `yaml:"api_https"`     //true if the 8080 API port is https protected
+	ApiUrl       string             `yaml:"api_url"`       //base url that the /API paths are under, defaults to port 8080 of host
+	AdminUrl     string             `yaml:"admin_url"`     //base url of the admin service, defaults to port 9001 of host
+	AdminAuth    AdminAuthConfig    `yaml:"admin_auth"`
+	TLS          TLSConfig          `yaml:"tls"`
+	Credentials  CredentialsConfig  `yaml:"credentials"` //API user for checking storages
+	Cluster      ClusterNodesConfig `yaml:"cluster"`     //if set, the admin port checks run against every node
+}
+
+/**
+returns where the API is and how to log in to it
+*/
+func (v VidispineConfig) ApiEndpoint() common.Endpoint {
+	api := common.Endpoint{BaseUrl: v.ApiUrl}
+	if v.ApiUrl == "" {
+		api = common.HostEndpoint(v.Host, 8080, v.ApiHttps)
+	}
+	api.User = v.Credentials.User
+	api.Password = v.Credentials.Password.String()
+	api.TokenAuth = v.Credentials.Token
+	api.TokenLifetime = v.Credentials.TokenLifetime.Duration
+	api.RunAs = v.Credentials.RunAs
+	api.TLS = v.TLS.Settings()
+	return api
+}
+
+/**
+returns where the admin service is and how to authenticate to it
+*/
+func (v VidispineConfig) AdminEndpoint() common.Endpoint {
+	admin := common.Endpoint{BaseUrl: v.AdminUrl}
+	if v.AdminUrl == "" {
+		admin = common.HostEndpoint(v.Host, 9001, v.MonitorHttps)
+	}
+	admin.TLS = v.TLS.Settings()
+	admin.User = v.AdminAuth.User
+	admin.Password = v.AdminAuth.Password.String()
+	if v.AdminAuth.Header != "" {
+		admin.Headers = map[string]string{v.AdminAuth.Header: v.AdminAuth.Value.String()}
+	}
+	return admin
+}
+
+/**
+settings that every check has
+*/
+type CheckSettings struct {
+	Enabled   bool     `yaml:"enabled"`
+	Every     Duration `yaml:"every"`      //how often to run the check, defaults to check_every
+	DependsOn []string `yaml:"depends_on"` //ids of checks that must be able to run for this one to be worth running
+}
+
+type HealthcheckConfig struct {
+	CheckSettings `yaml:",inline"`
+	Include       []string                 `yaml:"include"` //healthcheck entries to alert on, e.g. database or transcoder-*. All if empty
+	Exclude       []string                 `yaml:"exclude"` //healthcheck entries to ignore
+	Thresholds    vshealthcheck.Thresholds `yaml:"thresholds"`
+}
+
+type MetricsConfig struct {
+	CheckSettings `yaml:",inline"`
+	DatabaseName  string                          `yaml:"database_name"`
+	Thresholds    vsmetriccheck.Thresholds        `yaml:"thresholds"`
+	Threads       vsmetriccheck.ThreadThresholds  `yaml:"threads"` //limits on the JVM's thread counts
+	Memory        vsmetriccheck.MemoryThresholds  `yaml:"memory"`  //GC time, old generation after GC and Metaspace
+	Jetty         vsmetriccheck.JettyThresholds   `yaml:"jetty"`   //request thread pools and requests in progress
+	Indexer       vsmetriccheck.IndexerThresholds `yaml:"indexer"` //failed, stalled and slow Elasticsearch indexing and queries
+	Timers        []vsmetriccheck.TimerThreshold  `yaml:"timers"`  //alert levels for the percentiles of timers, e.g. elasticsearch.query.time
+	Rules         []vsmetriccheck.Rule            `yaml:"rules"`   //metric alerts defined here rather than in code
+}
+
+type ApiCheckConfig struct {
+	CheckSettings `yaml:",inline"`
+}
+
+type StorageConfig struct {
+	CheckSettings `yaml:",inline"`
+	FullThreshold float64 `yaml:"full_threshold"` //fraction of capacity in use before a storage counts as full
+}
+
+type ClusterSizeConfig struct {
+	CheckSettings `yaml:",inline"`
+}
+
+type ChecksConfig struct {
+	Healthcheck HealthcheckConfig `yaml:"healthcheck"`
+	Metrics     MetricsConfig     `yaml:"metrics"`
+	Api         ApiCheckConfig    `yaml:"api"` //only runs if vidispine.credentials are given
+	Storage     StorageConfig     `yaml:"storage"`
+	Cluster     ClusterSizeConfig `yaml:"cluster"` //only runs if vidispine.cluster is set
+}
+
+/**
+returns the settings common to every check, by check id
+*/
+func (c *ChecksConfig) Settings() map[string]*CheckSettings {
+	return map[string]*CheckSettings{
+		HealthcheckId: &c.Healthcheck.CheckSettings,
+		MetricsId:     &c.Metrics.CheckSettings,
+		ApiId:         &c.Api.CheckSettings,
+		StorageId:     &c.Storage.CheckSettings,
+		ClusterId:     &c.Cluster.CheckSettings,
+	}
+}
+
+/**
+TargetConfig is one Vidispine installation to monitor, with its own checks and routing key
+*/
+type TargetConfig struct {
+	Name       string          `yaml:"name"`        //short name that alerts for this target are namespaced with, e.g. staging
+	RoutingKey Secret          `yaml:"routing_key"` //pagerduty integration key for this target, defaults to notifiers.pagerduty.integration_key
+	Vidispine  VidispineConfig `yaml:"vidispine"`
+	Checks     ChecksConfig    `yaml:"checks"`
+}
+
+/**
+starts each target from the default check settings, so that a target only has to give the ones it changes
+*/
+func (t *TargetConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain TargetConfig
+	target := plain{Checks: DefaultChecks()}
+	if err := unmarshal(&target); err != nil {
+		return err
+	}
+	*t = TargetConfig(target)
+	return nil
+}
+
+/**
+Config is everything that can be changed without restarting the app. See example.yaml for a documented example
+*/
+type Config struct {
+	CheckEvery Duration          `yaml:"check_every"` //interval to check
+	Verbose    bool              `yaml:"verbose"`     //whether to output verbose logging
+	DryRun     bool              `yaml:"dry_run"`     //print the alerts that would be sent instead of sending them
+	Notifiers  NotifiersConfig   `yaml:"notifiers"`
+	Vidispine  VidispineConfig   `yaml:"vidispine"`
+	Checks     ChecksConfig      `yaml:"checks"`
+	Targets    []TargetConfig    `yaml:"targets,omitempty"`  //several Vidispines to monitor, instead of vidispine and checks
+	Silences   []silence.Silence `yaml:"silences,omitempty"` //read-only silences, e.g. for regular maintenance windows
+}
+
+/**
+returns the check settings that apply if nothing else is given
+*/
+func DefaultChecks() ChecksConfig {
+	return ChecksConfig{
+		Healthcheck: HealthcheckConfig{
+			CheckSettings: CheckSettings{Enabled: true},
+			Thresholds:    vshealthcheck.DefaultThresholds,
+		},
+		Metrics: MetricsConfig{
+			CheckSettings: CheckSettings{Enabled: true, DependsOn: []string{HealthcheckId}},
+			DatabaseName:  "vidispinedb",
+			Thresholds:    vsmetriccheck.DefaultThresholds,
+			Threads:       vsmetriccheck.DefaultThreadThresholds,
+			Memory:        vsmetriccheck.DefaultMemoryThresholds,
+			Jetty:         vsmetriccheck.DefaultJettyThresholds,
+			Indexer:       vsmetriccheck.DefaultIndexerThresholds,
+		},
+		Api: ApiCheckConfig{
+			CheckSettings: CheckSettings{Enabled: true},
+		},
+		Storage: StorageConfig{
+			CheckSettings: CheckSettings{Enabled: true, DependsOn: []string{ApiId}},
+			FullThreshold: vsstoragecheck.DefaultFullThreshold,
+		},
+		Cluster: ClusterSizeConfig{
+			CheckSettings: CheckSettings{Enabled: true},
+		},
+	}
+}
+
+/**
+returns the configuration that applies if nothing else is given
+*/
+func Default() *Config {
+	return &Config{
+		Checks: DefaultChecks(),
+	}
+}
+
+/**
+returns every Vidispine to monitor. If no targets are configured then the top-level vidispine and checks
+sections are the only target, which has no name so that its alerts are not namespaced
+*/
+func (c *Config) MonitoredTargets() []TargetConfig {
+	if len(c.Targets) > 0 {
+		return c.Targets
+	}
+	return []TargetConfig{{Vidispine: c.Vidispine, Checks: c.Checks}}
+}
+
+/**
+returns the pagerduty integration key that alerts for the given target are sent to
+*/
+func (c *Config) RoutingKey(target TargetConfig) string {
+	if key := target.RoutingKey.String(); key != "" {
+		return key
+	}
+	return c.Notifiers.PagerDuty.IntegrationKey.String()
+}
+
+/**
+Errors is a list of every problem found with a configuration
+*/
+type Errors []string
+
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return "configuration is not valid: " + e[0]
+	}
+	return fmt.Sprintf("configuration is not valid, %d problems found:\n  - %s", len(e), strings.Join(e, "\n  - "))
+}
+
+/**
+LoadOptions changes how strictly a configuration is checked
+*/
+type LoadOptions struct {
+	IgnoreMissingSecrets bool //don't report secret references that can't be resolved, e.g. when validating in CI
+}
+
+/**
+reads the YAML config file, if one is given, and then applies any settings from the environment on top of it.
+If there are any problems, they are all returned together as Errors
+*/
+func Load(filename string) (*Config, error) {
+	return LoadWithOptions(filename, LoadOptions{})
+}
+
+func LoadWithOptions(filename string, opts LoadOptions) (*Config, error) {
+	cfg := Default()
+	problems := make(Errors, 0)
+
+	if filename != "" {
+		content, readErr := ioutil.ReadFile(filename)
+		if readErr != nil {
+			return nil, Errors{readErr.Error()}
+		}
+		unmarshal := func() error { return yaml.UnmarshalStrict(content, cfg) }
+		if isLegacyLayout(content) {
+			unmarshal = func() error { return unmarshalLegacy(filename, content, cfg) }
+		}
+		if parseErr := unmarshal(); parseErr != nil {
+			if typeErr, isTypeErr := parseErr.(*yaml.TypeError); isTypeErr {
+				for _, msg := range typeErr.Errors {
+					problems = append(problems, fmt.Sprintf("%s: %s", filename, msg))
+				}
+			} else {
+				return nil, Errors{fmt.Sprintf("could not parse %s: %s", filename, parseErr)}
+			}
+		}
+	}
+
+	problems = append(problems, cfg.applyEnvironment()...)
+	secretProblems := append(cfg.resolveSecrets(), cfg.loadCertificates()...)
+	if !opts.IgnoreMissingSecrets {
+		problems = append(problems, secretProblems...)
+	}
+	problems = append(problems, cfg.Validate()...)
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return cfg, nil
+}
+
+func envString(name string, target *string) {
+	if value := os.Getenv(name); value != "" {
+		*target = value
+	}
+}
+
+/**
+reads a secret from the environment variable, or from the file named by the variable with _FILE on the end,
+e.g. PD_API_KEY_FILE, so that it doesn't show up in the pod spec or process listings
+*/
+func envSecret(name string, target *Secret) []string {
+	value, filename := os.Getenv(name), os.Getenv(name+"_FILE")
+	if value != "" && filename != "" {
+		return []string{fmt.Sprintf("Only one of %s and %s_FILE can be set", name, name)}
+	}
+	if value != "" {
+		*target = Secret{Value: value}
+	} else if filename != "" {
+		*target = Secret{File: filename}
+	}
+	return nil
+}
+
+func envBool(name string, target *bool) []string {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	parsed, parseErr := strconv.ParseBool(value)
+	if parseErr != nil {
+		return []string{fmt.Sprintf("The value %s for %s is not valid, expected 'true' or 'false'", value, name)}
+	}
+	*target = parsed
+	return nil
+}
+
+/**
+applies the environment variable overrides, returning a description of each one that could not be parsed
+*/
+func (c *Config) applyEnvironment() []string {
+	problems := make([]string, 0)
+	if checkEveryStr := os.Getenv("CHECK_EVERY"); checkEveryStr != "" {
+		checkEvery, durParseErr := time.ParseDuration(checkEveryStr)
+		if durParseErr != nil {
+			problems = append(problems, fmt.Sprintf("CHECK_EVERY value %s is not a valid duration: %s", checkEveryStr, durParseErr))
+		} else {
+			c.CheckEvery = Duration{Duration: checkEvery}
+		}
+	}
+
+	problems = append(problems, envSecret("PD_INTEGRATION_KEY", &c.Notifiers.PagerDuty.IntegrationKey)...)
+	problems = append(problems, envSecret("PD_API_KEY", &c.Notifiers.PagerDuty.ApiKey)...)
+	envString("VIDISPINE_HOST", &c.Vidispine.Host)
+	envString("VIDISPINE_API_URL", &c.Vidispine.ApiUrl)
+	envString("VIDISPINE_ADMIN_URL", &c.Vidispine.AdminUrl)
+	envString("VIDISPINE_TLS_CA_FILE", &c.Vidispine.TLS.CAFile)
+	envString("VIDISPINE_TLS_CERT_FILE", &c.Vidispine.TLS.CertFile)
+	envString("VIDISPINE_TLS_KEY_FILE", &c.Vidispine.TLS.KeyFile)
+	envString("VIDISPINE_TLS_SERVER_NAME", &c.Vidispine.TLS.ServerName)
+	envString("VIDISPINE_TLS_MIN_VERSION", &c.Vidispine.TLS.MinVersion)
+	envString("VIDISPINE_API_USER", &c.Vidispine.Credentials.User)
+	problems = append(problems, envSecret("VIDISPINE_API_PASSWD", &c.Vidispine.Credentials.Password)...)
+	envString("VIDISPINE_API_RUN_AS", &c.Vidispine.Credentials.RunAs)
+
+	problems = append(problems, envBool("VERBOSE", &c.Verbose)...)
+	problems = append(problems, envBool("DRY_RUN", &c.DryRun)...)
+	problems = append(problems, envBool("VIDISPINE_MONITOR_HTTPS", &c.Vidispine.MonitorHttps)...)
+	problems = append(problems, envBool("VIDISPINE_API_HTTPS", &c.Vidispine.ApiHttps)...)
+	problems = append(problems, envBool("VIDISPINE_API_TOKEN", &c.Vidispine.Credentials.Token)...)
+	return problems
+}
+
+type namedSecret struct {
+	name   string //where the secret is in the config file
+	secret *Secret
+}
+
+/**
+returns every secret in the configuration
+*/
+func (c *Config) secrets() []namedSecret {
+	vidispineSecrets := func(prefix string, v *VidispineConfig) []namedSecret {
+		return []namedSecret{
+			{prefix + "vidispine.credentials.password", &v.Credentials.Password},
+			{prefix + "vidispine.admin_auth.password", &v.AdminAuth.Password},
+			{prefix + "vidispine.admin_auth.value", &v.AdminAuth.Value},
+		}
+	}
+	secrets := []namedSecret{
+		{"notifiers.pagerduty.integration_key", &c.Notifiers.PagerDuty.IntegrationKey},
+		{"notifiers.pagerduty.api_key", &c.Notifiers.PagerDuty.ApiKey},
+	}
+	secrets = append(secrets, vidispineSecrets("", &c.Vidispine)...)
+	for i := range c.Targets {
+		prefix := fmt.Sprintf("targets[%d].", i)
+		secrets = append(secrets, namedSecret{prefix + "routing_key", &c.Targets[i].RoutingKey})
+		secrets = append(secrets, vidispineSecrets(prefix, &c.Targets[i].Vidispine)...)
+	}
+	return secrets
+}
+
+/**
+looks up every secret given by reference, returning a description of each one that could not be found
+*/
+func (c *Config) resolveSecrets() []string {
+	problems := make([]string, 0)
+	for _, s := range c.secrets() {
+		if err := s.secret.resolve(); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", s.name, err))
+		}
+	}
+	return problems
+}
+
+/**
+returns the files that secrets are read from, so they can be watched for changes
+*/
+func (c *Config) SecretFiles() []string {
+	files := make([]string, 0)
+	for _, s := range c.secrets() {
+		if s.secret.File != "" {
+			files = append(files, s.secret.File)
+		}
+	}
+	return files
+}
+
+/**
+loads every CA bundle and client certificate, returning a description of each one that could not be used.
+Settings that are not valid in themselves are left to Validate
+*/
+func (c *Config) loadCertificates() []string {
+	problems := make([]string, 0)
+	check := func(prefix string, v VidispineConfig) {
+		settings := v.TLS.Settings()
+		if settings.Validate() != nil {
+			return
+		}
+		if _, loadErr := settings.Config(); loadErr != nil {
+			problems = append(problems, fmt.Sprintf("%svidispine.tls: %s", prefix, loadErr))
+		}
+	}
+	check("", c.Vidispine)
+	for i, target := range c.Targets {
+		check(fmt.Sprintf("targets[%d].", i), target.Vidispine)
+	}
+	return problems
+}
+
+/**
+returns the configuration as YAML, with the value of every secret replaced
+*/
+func (c *Config) MarshalRedacted() ([]byte, error) {
+	return yaml.Marshal(c)
+}
+
+/**
+checks that the configuration can be used, returning a description of every problem found
+*/
+func (c *Config) Validate() []string {
+	problems := make([]string, 0)
+	if err := c.CheckEvery.Err(); err != nil {
+		problems = append(problems, fmt.Sprintf("check_every: %s", err))
+	}
+	if c.CheckEvery.Duration <= 0 && c.CheckEvery.Err() == nil {
+		problems = append(problems, "You must specify CHECK_EVERY in the environment or check_every in the config file, e.g. CHECK_EVERY=5m")
+	}
+	problems = append(problems, validateSilences(c.Silences)...)
+
+	if len(c.Targets) == 0 {
+		if c.Vidispine.Host == "" && (c.Vidispine.ApiUrl == "" || c.Vidispine.AdminUrl == "") {
+			problems = append(problems, "You must specify VIDISPINE_HOST in the environment or vidispine.host in the config file. Note that this is the hostname not the url. Alternatively give both vidispine.api_url and vidispine.admin_url.")
+		}
+		return append(problems, validateTarget("", c.Vidispine, c.Checks)...)
+	}
+
+	if c.Vidispine.Host != "" || c.Vidispine.ApiUrl != "" || c.Vidispine.AdminUrl != "" {
+		problems = append(problems, "vidispine.host (or VIDISPINE_HOST) can't be used together with targets, give each Vidispine its own target")
+	}
+	seenNames := make(map[string]bool, len(c.Targets))
+	for i, target := range c.Targets {
+		prefix := fmt.Sprintf("targets[%d].", i)
+		if !targetNamePattern.MatchString(target.Name) {
+			problems = append(problems, fmt.Sprintf("%sname '%s' is not valid, it must be lower case letters, digits, - and _", prefix, target.Name))
+		} else if seenNames[target.Name] {
+			problems = append(problems, fmt.Sprintf("%sname: there is already a target called '%s'", prefix, target.Name))
+		}
+		seenNames[target.Name] = true
+		if target.Vidispine.Host == "" && (target.Vidispine.ApiUrl == "" || target.Vidispine.AdminUrl == "") {
+			problems = append(problems, fmt.Sprintf("%svidispine.host must be set, unless both api_url and admin_url are. Note that this is the hostname not the url.", prefix))
+		}
+		problems = append(problems, validateTarget(prefix, target.Vidispine, target.Checks)...)
+	}
+	return problems
+}
+
+var targetNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+/**
+checks the silences given in the config file. Each needs an id, so that it keeps its suppressed count when the
+configuration is reloaded
+*/
+func validateSilences(silences []silence.Silence) []string {
+	problems := make([]string, 0)
+	seenIds := make(map[string]bool, len(silences))
+	for i, s := range silences {
+		if s.Id == "" {
+			problems = append(problems, fmt.Sprintf("silences[%d].id must be set", i))
+		} else if seenIds[s.Id] {
+			problems = append(problems, fmt.Sprintf("silences[%d].id: there is already a silence called '%s'", i, s.Id))
+		}
+		seenIds[s.Id] = true
+		if err := s.Validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("silences[%d]: %s", i, err))
+		}
+	}
+	return problems
+}
+
+/**
+checks the settings for one Vidispine. prefix is where they are in the config file, e.g. "targets[0]."
+*/
+func validateTarget(prefix string, vidispine VidispineConfig, checks ChecksConfig) []string {
+	problems := make([]string, 0)
+	if vidispine.Credentials.User != "" && !vidispine.Credentials.Password.given() {
+		problems = append(problems, prefix+"vidispine.credentials.user is set but "+prefix+"vidispine.credentials.password is not")
+	}
+	if vidispine.Credentials.Token && vidispine.Credentials.User == "" {
+		problems = append(problems, prefix+"vidispine.credentials.token needs "+prefix+"vidispine.credentials.user to log in with")
+	}
+	if err := vidispine.Credentials.TokenLifetime.Err(); err != nil {
+		problems = append(problems, fmt.Sprintf("%svidispine.credentials.token_lifetime: %s", prefix, err))
+	}
+	if lifetime := vidispine.Credentials.TokenLifetime.Duration; lifetime < 0 || (lifetime > 0 && lifetime < time.Minute) {
+		problems = append(problems, prefix+"vidispine.credentials.token_lifetime must be at least 1m")
+	}
+	for _, u := range []struct {
+		name  string
+		value string
+	}{{"api_url", vidispine.ApiUrl}, {"admin_url", vidispine.AdminUrl}} {
+		if u.value == "" {
+			continue
+		}
+		if parsed, parseErr := url.Parse(u.value); parseErr != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			problems = append(problems, fmt.Sprintf("%svidispine.%s '%s' must be a full http or https url, e.g. https://vidispine.example.com/vs-admin", prefix, u.name, u.value))
+		}
+	}
+	if tlsErr := vidispine.TLS.Settings().Validate(); tlsErr != nil {
+		problems = append(problems, fmt.Sprintf("%svidispine.tls: %s", prefix, tlsErr))
+	}
+	auth := vidispine.AdminAuth
+	if auth.User != "" && !auth.Password.given() {
+		problems = append(problems, prefix+"vidispine.admin_auth.user is set but "+prefix+"vidispine.admin_auth.password is not")
+	}
+	if (auth.Header == "") != !auth.Value.given() {
+		problems = append(problems, prefix+"vidispine.admin_auth.header and "+prefix+"vidispine.admin_auth.value must be given together")
+	}
+	if len(vidispine.Cluster.Nodes) > 0 && vidispine.Cluster.Srv != "" {
+		problems = append(problems, prefix+"vidispine.cluster: give either nodes or srv, not both")
+	}
+	for i, node := range vidispine.Cluster.Nodes {
+		if node == "" {
+			problems = append(problems, fmt.Sprintf("%svidispine.cluster.nodes[%d] must not be empty", prefix, i))
+		}
+	}
+
+	for _, list := range []struct {
+		name     string
+		patterns []string
+	}{{"include", checks.Healthcheck.Include}, {"exclude", checks.Healthcheck.Exclude}} {
+		for i, pattern := range list.patterns {
+			if _, matchErr := path.Match(pattern, ""); matchErr != nil || pattern == "" {
+				problems = append(problems, fmt.Sprintf("%schecks.healthcheck.%s[%d] '%s' is not a valid name or pattern", prefix, list.name, i, pattern))
+			}
+		}
+	}
+
+	entries := make([]common.CheckEntry, 0, len(CheckIds))
+	settings := checks.Settings()
+	haveUnknownDeps := false
+	for _, id := range CheckIds {
+		s := settings[id]
+		if err := s.Every.Err(); err != nil {
+			problems = append(problems, fmt.Sprintf("%schecks.%s.every: %s", prefix, id, err))
+		}
+		if s.Every.Duration < 0 {
+			problems = append(problems, fmt.Sprintf("%schecks.%s.every must not be negative", prefix, id))
+		}
+		for _, parentId := range s.DependsOn {
+			if _, known := settings[parentId]; !known {
+				haveUnknownDeps = true
+				problems = append(problems, fmt.Sprintf("%schecks.%s.depends_on: there is no check called '%s', expected one of %s", prefix, id, parentId, strings.Join(CheckIds, ", ")))
+			}
+		}
+		entries = append(entries, common.CheckEntry{Id: id, DependsOn: s.DependsOn})
+	}
+	if !haveUnknownDeps {
+		if _, orderErr := common.OrderChecks(entries); orderErr != nil {
+			problems = append(problems, prefix+orderErr.Error())
+		}
+	}
+
+	if checks.Metrics.DatabaseName == "" {
+		problems = append(problems, prefix+"checks.metrics.database_name must not be empty")
+	}
+	for _, p := range checks.Metrics.Thresholds.Validate() {
+		problems = append(problems, prefix+"checks.metrics.thresholds."+p)
+	}
+	for _, p := range checks.Metrics.Threads.Validate() {
+		problems = append(problems, prefix+"checks.metrics.threads."+p)
+	}
+	for _, p := range checks.Metrics.Memory.Validate() {
+		problems = append(problems, prefix+"checks.metrics.memory."+p)
+	}
+	for _, p := range checks.Metrics.Jetty.Validate() {
+		problems = append(problems, prefix+"checks.metrics.jetty."+p)
+	}
+	for _, p := range checks.Metrics.Indexer.Validate() {
+		problems = append(problems, prefix+"checks.metrics.indexer."+p)
+	}
+	for i, timer := range checks.Metrics.Timers {
+		for _, p := range timer.Validate() {
+			problems = append(problems, fmt.Sprintf("%schecks.metrics.timers[%d].%s", prefix, i, p))
+		}
+	}
+	ruleNames := make(map[string]bool)
+	for i, rule := range checks.Metrics.Rules {
+		for _, p := range rule.Validate() {
+			problems = append(problems, fmt.Sprintf("%schecks.metrics.rules[%d].%s", prefix, i, p))
+		}
+		if ruleNames[rule.Name] {
+			problems = append(problems, fmt.Sprintf("%schecks.metrics.rules[%d]: there is already a rule called '%s'", prefix, i, rule.Name))
+		}
+		ruleNames[rule.Name] = true
+	}
+	for _, p := range checks.Healthcheck.Thresholds.Validate() {
+		problems = append(problems, prefix+"checks.healthcheck.thresholds."+p)
+	}
+	if checks.Storage.FullThreshold <= 0 || checks.Storage.FullThreshold > 1 {
+		problems = append(problems, fmt.Sprintf("%schecks.storage.full_threshold must be a fraction between 0 and 1, not %g", prefix, checks.Storage.FullThreshold))
+	}
+	return problems
+}
@@ -0,0 +1,972 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTempConfig(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "vsmonitor-config")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	f.WriteString(content)
+	f.Close()
+	return f.Name()
+}
+
+func TestLoad_example(t *testing.T) {
+	os.Setenv("PD_INTEGRATION_KEY", "integration")
+	os.Setenv("PD_API_KEY", "apikey")
+	os.Setenv("VIDISPINE_API_PASSWD", "passwd")
+	defer os.Unsetenv("PD_INTEGRATION_KEY")
+	defer os.Unsetenv("PD_API_KEY")
+	defer os.Unsetenv("VIDISPINE_API_PASSWD")
+
+	cfg, err := Load("example.yaml")
+	if err != nil {
+		t.Error("example.yaml did not load: ", err)
+		t.FailNow()
+	}
+	if cfg.Checks.Healthcheck.Every.Duration != time.Minute {
+		t.Errorf("expected healthcheck every 1m, got %s", cfg.Checks.Healthcheck.Every)
+	}
+	if cfg.Vidispine.Credentials.Password.String() != "passwd" {
+		t.Errorf("password reference was not resolved, got '%s'", cfg.Vidispine.Credentials.Password)
+	}
+}
+
+func TestLoad_fileWithEnvOverride(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+notifiers:
+  pagerduty:
+    integration_key: from-file
+vidispine:
+  host: vidispine.local
+  monitor_https: true
+checks:
+  metrics:
+    thresholds:
+      heap_warning: 0.7
+`)
+	defer os.Remove(filename)
+
+	os.Setenv("PD_INTEGRATION_KEY", "from-env")
+	defer os.Unsetenv("PD_INTEGRATION_KEY")
+
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Error("Load returned an unexpected error: ", err)
+		t.FailNow()
+	}
+	if cfg.CheckEvery.Duration != 5*time.Minute {
+		t.Errorf("expected check_every of 5m, got %s", cfg.CheckEvery)
+	}
+	if cfg.Vidispine.Host != "vidispine.local" || !cfg.Vidispine.MonitorHttps {
+		t.Errorf("vidispine settings were not loaded from the file: %v", cfg.Vidispine)
+	}
+	if cfg.Notifiers.PagerDuty.IntegrationKey.String() != "from-env" {
+		t.Errorf("environment did not override the file, got %s", cfg.Notifiers.PagerDuty.IntegrationKey)
+	}
+	if cfg.Checks.Metrics.Thresholds.HeapWarning != 0.7 {
+		t.Errorf("expected heap_warning 0.7, got %g", cfg.Checks.Metrics.Thresholds.HeapWarning)
+	}
+	if cfg.Checks.Metrics.Thresholds.HeapCritical != 0.9 {
+		t.Errorf("unset threshold did not keep its default, got %g", cfg.Checks.Metrics.Thresholds.HeapCritical)
+	}
+	if !cfg.Checks.Storage.Enabled || len(cfg.Checks.Metrics.DependsOn) != 1 {
+		t.Errorf("unset check settings did not keep their defaults: %v", cfg.Checks)
+	}
+}
+
+/**
+every problem should be reported at once, not just the first
+*/
+func TestLoad_reportsAllErrors(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5 minutes
+vidispine:
+  hostname: vidispine.local
+  credentials:
+    user: storagecheck
+    password: {env: VSMONITOR_TEST_NOT_SET}
+checks:
+  healthcheck:
+    thresholds:
+      duration_warning: 5 mins
+  metrics:
+    depends_on: [healthchecks]
+    thresholds:
+      heap_critical: 90
+    timers:
+      - timer: elasticsearch.query.time
+        warning: 2 seconds
+    indexer:
+      query_critical: 30
+  storage:
+    full_threshold: 0
+`)
+	defer os.Remove(filename)
+
+	_, err := Load(filename)
+	if err == nil {
+		t.Error("Load accepted an invalid config")
+		t.FailNow()
+	}
+	problems, isErrors := err.(Errors)
+	if !isErrors {
+		t.Errorf("expected Errors, got %T", err)
+		t.FailNow()
+	}
+
+	expected := []string{
+		"check_every",
+		"hostname",
+		"VSMONITOR_TEST_NOT_SET",
+		"VIDISPINE_HOST",
+		"no check called 'healthchecks'",
+		"checks.healthcheck.thresholds.duration_warning: time: unknown unit",
+		"checks.metrics.timers[0].warning: time: unknown unit",
+		"checks.metrics.indexer.query_critical: time: missing unit",
+		"heap_critical",
+		"full_threshold",
+	}
+	for _, e := range expected {
+		found := false
+		for _, p := range problems {
+			if strings.Contains(p, e) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected a problem mentioning '%s', got %v", e, problems)
+		}
+	}
+}
+
+func TestLoad_dependencyCycle(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+vidispine:
+  host: vidispine.local
+checks:
+  healthcheck:
+    depends_on: [metrics]
+`)
+	defer os.Remove(filename)
+
+	_, err := Load(filename)
+	if err == nil || !strings.Contains(err.Error(), "depend on each other") {
+		t.Errorf("expected a dependency cycle error, got %v", err)
+	}
+}
+
+/**
+storage alerts are only meaningful if the monitor can log in to the API, so by default storage waits for the api check
+*/
+func TestLoad_storageDependsOnApi(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+vidispine:
+  host: vidispine.local
+`)
+	defer os.Remove(filename)
+
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Error("config did not load: ", err)
+		t.FailNow()
+	}
+	if deps := cfg.Checks.Storage.DependsOn; len(deps) != 1 || deps[0] != ApiId {
+		t.Errorf("expected the storage check to depend on the api check, got %v", deps)
+	}
+	if !cfg.Checks.Api.Enabled {
+		t.Error("expected the api check to be enabled by default")
+	}
+}
+
+func TestWatcher_reload(t *testing.T) {
+	filename := writeTempConfig(t, "check_every: 5m\nvidispine: {host: vidispine.local}\n")
+	defer os.Remove(filename)
+
+	w := NewWatcher(filename)
+	if w.fileChanged() {
+		t.Error("file reported as changed before it was modified")
+	}
+
+	ioutil.WriteFile(filename, []byte("check_every: 1m\nvidispine: {host: ''}\n"), 0644)
+	if !w.fileChanged() {
+		t.Error("file change was not detected")
+	}
+	w.reload("test")
+	select {
+	case <-w.Reloaded:
+		t.Error("an invalid configuration was applied")
+	default:
+	}
+
+	ioutil.WriteFile(filename, []byte("check_every: 1m\nvidispine: {host: vidispine.local}\n"), 0644)
+	w.reload("test")
+	select {
+	case cfg := <-w.Reloaded:
+		if cfg.CheckEvery.Duration != time.Minute {
+			t.Errorf("reloaded config had check_every %s, expected 1m", cfg.CheckEvery)
+		}
+	default:
+		t.Error("a valid configuration was not applied")
+	}
+}
+
+func TestLoad_silences(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+vidispine: {host: vidispine.local}
+silences:
+  - id: storage-migration
+    dedup_key: vidispine-storage*-VX-4
+    starts_at: 2021-03-12T09:00:00Z
+    ends_at: 2021-03-12T17:00:00Z
+    comment: Evacuating VX-4 to the new NAS
+`)
+	defer os.Remove(filename)
+
+	w := NewWatcher(filename)
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Fatal("Load returned an unexpected error: ", err)
+	}
+	if len(cfg.Silences) != 1 || cfg.Silences[0].DedupKey != "vidispine-storage*-VX-4" || cfg.Silences[0].EndsAt.Hour() != 17 {
+		t.Errorf("silence was not loaded, got %v", cfg.Silences)
+	}
+
+	ioutil.WriteFile(filename, []byte(`
+check_every: 5m
+vidispine: {host: vidispine.local}
+silences:
+  - id: storage-migration
+    dedup_key: vidispine-storage*-VX-4
+    ends_at: 2021-03-12T17:00:00Z
+    comment: Evacuating VX-4 to the new NAS
+  - id: storage-migration
+    component: '[VX-4'
+    ends_at: 2021-03-12T17:00:00Z
+    comment: bad pattern
+  - check: Storage*
+    starts_at: 2021-03-12T09:00:00Z
+    ends_at: 2021-03-12T08:00:00Z
+    comment: ends before it starts
+`), 0644)
+	w.reload("test")
+	select {
+	case <-w.Reloaded:
+		t.Error("a configuration with invalid silences was applied")
+	default:
+	}
+	_, err = Load(filename)
+	if err == nil {
+		t.Fatal("expected the invalid silences to be rejected")
+	}
+	for _, expected := range []string{
+		"silences[1].id: there is already a silence called 'storage-migration'",
+		"silences[1]: invalid pattern '[VX-4'",
+		"silences[2].id must be set",
+		"silences[2]: a silence must end after it starts",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected a problem containing '%s', got %s", expected, err)
+		}
+	}
+
+	ioutil.WriteFile(filename, []byte(`
+check_every: 5m
+vidispine: {host: vidispine.local}
+silences:
+  - id: backups
+    check: Storage*
+    ends_at: 2021-03-13T06:00:00Z
+    comment: nightly backup
+`), 0644)
+	w.reload("test")
+	select {
+	case reloaded := <-w.Reloaded:
+		if len(reloaded.Silences) != 1 || reloaded.Silences[0].Id != "backups" {
+			t.Errorf("reloaded config did not have the new silence, got %v", reloaded.Silences)
+		}
+	default:
+		t.Error("a valid configuration was not applied")
+	}
+}
+
+func TestConfig_MarshalRedacted(t *testing.T) {
+	os.Setenv("VSMONITOR_TEST_PASSWD", "from-env-secret")
+	defer os.Unsetenv("VSMONITOR_TEST_PASSWD")
+	filename := writeTempConfig(t, `
+check_every: 5m
+notifiers:
+  pagerduty:
+    api_key: literal-secret
+vidispine:
+  host: vidispine.local
+  credentials:
+    user: storagecheck
+    password: {env: VSMONITOR_TEST_PASSWD}
+`)
+	defer os.Remove(filename)
+
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Error("Load returned an unexpected error: ", err)
+		t.FailNow()
+	}
+	content, marshalErr := cfg.MarshalRedacted()
+	if marshalErr != nil {
+		t.Error("MarshalRedacted returned an unexpected error: ", marshalErr)
+		t.FailNow()
+	}
+	output := string(content)
+	if strings.Contains(output, "literal-secret") || strings.Contains(output, "from-env-secret") {
+		t.Errorf("secret values were not redacted:\n%s", output)
+	}
+	if !strings.Contains(output, "api_key: <redacted>") {
+		t.Errorf("literal secret was not shown as redacted:\n%s", output)
+	}
+	if !strings.Contains(output, "env: VSMONITOR_TEST_PASSWD") {
+		t.Errorf("secret reference was not shown:\n%s", output)
+	}
+}
+
+func TestLoad_invalidBoolInEnvironment(t *testing.T) {
+	os.Setenv("VIDISPINE_API_HTTPS", "maybe")
+	defer os.Unsetenv("VIDISPINE_API_HTTPS")
+	os.Setenv("VIDISPINE_HOST", "vidispine.local")
+	defer os.Unsetenv("VIDISPINE_HOST")
+	os.Setenv("CHECK_EVERY", "5m")
+	defer os.Unsetenv("CHECK_EVERY")
+
+	_, err := Load("")
+	if err == nil || !strings.Contains(err.Error(), "The value maybe for VIDISPINE_API_HTTPS") {
+		t.Errorf("expected the invalid value to be reported, got %v", err)
+	}
+}
+
+func TestLoad_targets(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+notifiers:
+  pagerduty:
+    integration_key: default-key
+targets:
+  - name: production
+    vidispine:
+      host: vidispine-prod.local
+  - name: staging
+    routing_key: staging-key
+    vidispine:
+      host: vidispine-staging.local
+      monitor_https: true
+    checks:
+      storage:
+        enabled: false
+`)
+	defer os.Remove(filename)
+
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Error("config did not load: ", err)
+		t.FailNow()
+	}
+	targets := cfg.MonitoredTargets()
+	if len(targets) != 2 {
+		t.Errorf("expected 2 targets, got %d", len(targets))
+		t.FailNow()
+	}
+	if cfg.RoutingKey(targets[0]) != "default-key" {
+		t.Errorf("production should use the default routing key, got '%s'", cfg.RoutingKey(targets[0]))
+	}
+	if cfg.RoutingKey(targets[1]) != "staging-key" {
+		t.Errorf("staging should use its own routing key, got '%s'", cfg.RoutingKey(targets[1]))
+	}
+	if !targets[1].Vidispine.MonitorHttps || targets[0].Vidispine.MonitorHttps {
+		t.Error("monitor_https was not read per target")
+	}
+	if targets[1].Checks.Storage.Enabled || !targets[0].Checks.Storage.Enabled {
+		t.Error("storage check should only be disabled on staging")
+	}
+	if targets[1].Checks.Metrics.DatabaseName != "vidispinedb" || len(targets[1].Checks.Metrics.DependsOn) != 1 {
+		t.Error("target checks should start from the defaults")
+	}
+}
+
+func TestLoad_singleTarget(t *testing.T) {
+	filename := writeTempConfig(t, "check_every: 5m\nvidispine: {host: vidispine.local}\n")
+	defer os.Remove(filename)
+
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Error("config did not load: ", err)
+		t.FailNow()
+	}
+	targets := cfg.MonitoredTargets()
+	if len(targets) != 1 || targets[0].Name != "" || targets[0].Vidispine.Host != "vidispine.local" {
+		t.Errorf("expected the top-level settings as a single unnamed target, got %v", targets)
+	}
+}
+
+func TestLoad_invalidTargets(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+vidispine:
+  host: vidispine.local
+targets:
+  - name: staging
+    vidispine:
+      host: vidispine-staging.local
+  - name: staging
+  - name: Bad Name
+    vidispine:
+      host: vidispine-dr.local
+    checks:
+      storage:
+        full_threshold: 2
+`)
+	defer os.Remove(filename)
+
+	_, err := Load(filename)
+	if err == nil {
+		t.Error("expected the config to be rejected")
+		t.FailNow()
+	}
+	for _, expected := range []string{
+		"can't be used together with targets",
+		"targets[1].name: there is already a target called 'staging'",
+		"targets[1].vidispine.host must be set",
+		"targets[2].name 'Bad Name' is not valid",
+		"targets[2].checks.storage.full_threshold",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected a problem containing '%s', got %s", expected, err)
+		}
+	}
+}
+
+func TestLoad_cluster(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+vidispine:
+  host: vidispine.local
+  cluster:
+    nodes: [vs-1, ""]
+    srv: _admin._tcp.vidispine.local
+`)
+	defer os.Remove(filename)
+
+	_, err := Load(filename)
+	if err == nil {
+		t.Error("expected the config to be rejected")
+		t.FailNow()
+	}
+	for _, expected := range []string{"give either nodes or srv, not both", "vidispine.cluster.nodes[1] must not be empty"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected a problem containing '%s', got %s", expected, err)
+		}
+	}
+}
+
+func TestLoad_baseUrls(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+vidispine:
+  api_url: https://vidispine.example.com/vs
+  admin_url: https://vidispine.example.com/vs-admin/
+  admin_auth:
+    header: X-Auth-Token
+    value: token
+  credentials:
+    user: storagecheck
+    password: passwd
+`)
+	defer os.Remove(filename)
+
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Error("config did not load: ", err)
+		t.FailNow()
+	}
+	api := cfg.Vidispine.ApiEndpoint()
+	if api.BaseUrl != "https://vidispine.example.com/vs" || api.User != "storagecheck" || api.Password != "passwd" {
+		t.Errorf("got unexpected api endpoint %v", api)
+	}
+	admin := cfg.Vidispine.AdminEndpoint()
+	if admin.BaseUrl != "https://vidispine.example.com/vs-admin/" || admin.Headers["X-Auth-Token"] != "token" || admin.User != "" {
+		t.Errorf("got unexpected admin endpoint %v", admin)
+	}
+}
+
+func TestLoad_hostFallback(t *testing.T) {
+	filename := writeTempConfig(t, "check_every: 5m\nvidispine: {host: vidispine.local, monitor_https: true}\n")
+	defer os.Remove(filename)
+
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Error("config did not load: ", err)
+		t.FailNow()
+	}
+	if cfg.Vidispine.AdminEndpoint().BaseUrl != "https://vidispine.local:9001" {
+		t.Errorf("got unexpected admin url %s", cfg.Vidispine.AdminEndpoint().BaseUrl)
+	}
+	if cfg.Vidispine.ApiEndpoint().BaseUrl != "http://vidispine.local:8080" {
+		t.Errorf("got unexpected api url %s", cfg.Vidispine.ApiEndpoint().BaseUrl)
+	}
+}
+
+func TestLoad_invalidBaseUrls(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+vidispine:
+  api_url: vidispine.example.com
+  admin_url: https://vidispine.example.com/vs-admin
+  admin_auth:
+    user: monitor
+    header: X-Auth-Token
+`)
+	defer os.Remove(filename)
+
+	_, err := Load(filename)
+	if err == nil {
+		t.Error("expected the config to be rejected")
+		t.FailNow()
+	}
+	for _, expected := range []string{
+		"vidispine.api_url 'vidispine.example.com' must be a full http or https url",
+		"admin_auth.user is set but vidispine.admin_auth.password is not",
+		"admin_auth.header and vidispine.admin_auth.value must be given together",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected a problem containing '%s', got %s", expected, err)
+		}
+	}
+}
+
+func TestLoad_tls(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+vidispine:
+  host: vidispine.local
+  tls:
+    ca_file: /nonexistent/ca.pem
+    server_name: vidispine.internal
+`)
+	defer os.Remove(filename)
+
+	_, err := Load(filename)
+	if err == nil || !strings.Contains(err.Error(), "vidispine.tls: could not read CA bundle") {
+		t.Errorf("expected a missing CA bundle to be reported, got %v", err)
+	}
+
+	//certificates are often only mounted where the app runs, like secrets
+	cfg, ignoreErr := LoadWithOptions(filename, LoadOptions{IgnoreMissingSecrets: true})
+	if ignoreErr != nil {
+		t.Error("expected the missing CA bundle to be ignored, got ", ignoreErr)
+		t.FailNow()
+	}
+	if admin := cfg.Vidispine.AdminEndpoint(); admin.TLS.CAFile != "/nonexistent/ca.pem" || admin.TLS.ServerName != "vidispine.internal" {
+		t.Errorf("tls settings were not passed to the admin endpoint, got %v", admin.TLS)
+	}
+
+	badFile := writeTempConfig(t, "check_every: 5m\nvidispine: {host: vidispine.local, tls: {min_version: '1.4', cert_file: client.pem}}\n")
+	defer os.Remove(badFile)
+	_, badErr := LoadWithOptions(badFile, LoadOptions{IgnoreMissingSecrets: true})
+	if badErr == nil || !strings.Contains(badErr.Error(), "vidispine.tls: min_version 1.4 is not valid") {
+		t.Errorf("expected an invalid min_version to be reported, got %v", badErr)
+	}
+}
+
+func TestLoad_tokenAuth(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+vidispine:
+  host: vidispine.local
+  credentials:
+    user: storagecheck
+    password: secret
+    token: true
+    token_lifetime: 30m
+    run_as: tenant
+`)
+	defer os.Remove(filename)
+
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Error("Load returned an unexpected error: ", err)
+		t.FailNow()
+	}
+	api := cfg.Vidispine.ApiEndpoint()
+	if !api.TokenAuth || api.TokenLifetime != 30*time.Minute || api.RunAs != "tenant" {
+		t.Errorf("token settings were not passed to the API endpoint, got %v", api)
+	}
+
+	badFile := writeTempConfig(t, "check_every: 5m\nvidispine: {host: vidispine.local, credentials: {token: true, token_lifetime: 10s}}\n")
+	defer os.Remove(badFile)
+	_, badErr := Load(badFile)
+	if badErr == nil || !strings.Contains(badErr.Error(), "vidispine.credentials.token needs vidispine.credentials.user") ||
+		!strings.Contains(badErr.Error(), "vidispine.credentials.token_lifetime must be at least 1m") {
+		t.Errorf("expected the token settings to be reported, got %v", badErr)
+	}
+}
+
+func TestLoad_secretFilesAndHelpers(t *testing.T) {
+	passwordFile := writeTempConfig(t, "from-file\n")
+	defer os.Remove(passwordFile)
+	apiKeyFile := writeTempConfig(t, "api-key-from-file\n")
+	defer os.Remove(apiKeyFile)
+	os.Setenv("PD_API_KEY_FILE", apiKeyFile)
+	defer os.Unsetenv("PD_API_KEY_FILE")
+
+	filename := writeTempConfig(t, `
+check_every: 5m
+notifiers:
+  pagerduty:
+    integration_key: {exec: [echo, from-helper]}
+vidispine:
+  host: vidispine.local
+  credentials:
+    user: storagecheck
+    password: {file: `+passwordFile+`}
+`)
+	defer os.Remove(filename)
+
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Error("Load returned an unexpected error: ", err)
+		t.FailNow()
+	}
+	if cfg.Vidispine.Credentials.Password.String() != "from-file" {
+		t.Errorf("expected the password to be read from the file, got '%s'", cfg.Vidispine.Credentials.Password)
+	}
+	if cfg.Notifiers.PagerDuty.ApiKey.String() != "api-key-from-file" {
+		t.Errorf("expected PD_API_KEY_FILE to be read, got '%s'", cfg.Notifiers.PagerDuty.ApiKey)
+	}
+	if cfg.Notifiers.PagerDuty.IntegrationKey.String() != "from-helper" {
+		t.Errorf("expected the credential helper's output, got '%s'", cfg.Notifiers.PagerDuty.IntegrationKey)
+	}
+	if files := cfg.SecretFiles(); len(files) != 2 {
+		t.Errorf("expected both secret files to be listed, got %v", files)
+	}
+
+	content, _ := cfg.MarshalRedacted()
+	if strings.Contains(string(content), "from-file") || !strings.Contains(string(content), "file: "+passwordFile) {
+		t.Errorf("secret values were not redacted:\n%s", content)
+	}
+}
+
+func TestLoad_invalidSecretReferences(t *testing.T) {
+	os.Setenv("VIDISPINE_API_PASSWD", "literal")
+	defer os.Unsetenv("VIDISPINE_API_PASSWD")
+	os.Setenv("VIDISPINE_API_PASSWD_FILE", "/nonexistent/password")
+	defer os.Unsetenv("VIDISPINE_API_PASSWD_FILE")
+
+	filename := writeTempConfig(t, `
+check_every: 5m
+notifiers:
+  pagerduty:
+    integration_key: {file: /nonexistent/integration-key}
+    api_key: {exec: [sh, -c, "echo denied >&2; exit 3"]}
+vidispine:
+  host: vidispine.local
+`)
+	defer os.Remove(filename)
+
+	_, err := Load(filename)
+	for _, expected := range []string{
+		"Only one of VIDISPINE_API_PASSWD and VIDISPINE_API_PASSWD_FILE can be set",
+		"notifiers.pagerduty.integration_key: could not read secret file",
+		"notifiers.pagerduty.api_key: credential helper sh failed: exit status 3: denied",
+	} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected '%s' to be reported, got %v", expected, err)
+		}
+	}
+
+	badFile := writeTempConfig(t, "check_every: 5m\nvidispine: {host: vidispine.local, credentials: {user: u, password: {env: A, file: b}}}\n")
+	defer os.Remove(badFile)
+	if _, badErr := Load(badFile); badErr == nil || !strings.Contains(badErr.Error(), "exactly one of") {
+		t.Errorf("expected a reference with two sources to be rejected, got %v", badErr)
+	}
+}
+
+func TestWatcher_secretFiles(t *testing.T) {
+	passwordFile := writeTempConfig(t, "first")
+	defer os.Remove(passwordFile)
+	filename := writeTempConfig(t, "check_every: 5m\nvidispine: {host: vidispine.local, credentials: {user: u, password: {file: "+passwordFile+"}}}\n")
+	defer os.Remove(filename)
+
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Error("Load returned an unexpected error: ", err)
+		t.FailNow()
+	}
+	w := NewWatcher(filename)
+	w.WatchSecrets(cfg)
+	if w.secretsChanged() {
+		t.Error("secret file reported as changed before it was modified")
+	}
+
+	ioutil.WriteFile(passwordFile, []byte("second-password"), 0600)
+	if !w.secretsChanged() {
+		t.Error("secret file change was not detected")
+	}
+	if w.secretsChanged() {
+		t.Error("the same change should only be reported once")
+	}
+	w.reload("test")
+	select {
+	case reloaded := <-w.Reloaded:
+		if reloaded.Vidispine.Credentials.Password.String() != "second-password" {
+			t.Errorf("expected the rotated password, got '%s'", reloaded.Vidispine.Credentials.Password)
+		}
+	default:
+		t.Error("the configuration was not reloaded")
+	}
+}
+
+func TestLoad_healthcheckEntries(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+vidispine:
+  host: vidispine.local
+checks:
+  healthcheck:
+    include: [database, "transcoder-*"]
+    exclude: ["[broken"]
+`)
+	defer os.Remove(filename)
+
+	_, err := Load(filename)
+	if err == nil || !strings.Contains(err.Error(), "checks.healthcheck.exclude[0] '[broken' is not a valid name or pattern") {
+		t.Errorf("expected the invalid pattern to be reported, got %v", err)
+	}
+	if strings.Contains(err.Error(), "include") {
+		t.Errorf("the valid include list should not be reported, got %v", err)
+	}
+}
+
+func TestLoad_healthcheckThresholds(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+vidispine:
+  host: vidispine.local
+checks:
+  healthcheck:
+    thresholds:
+      duration_critical: 30s
+      entries:
+        elasticsearch: {duration_warning: 40s}
+`)
+	defer os.Remove(filename)
+
+	_, err := Load(filename)
+	if err == nil || !strings.Contains(err.Error(), "checks.healthcheck.thresholds.entries.elasticsearch.duration_warning must not be higher than duration_critical") {
+		t.Errorf("expected the entry thresholds to be reported, got %v", err)
+	}
+}
+
+func TestLoad_metricTimers(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+vidispine:
+  host: vidispine.local
+checks:
+  metrics:
+    timers:
+      - timer: elasticsearch.query.time
+        warning: 2s
+      - timer: storage.fs.stat
+        percentile: p90
+        warning: 500ms
+`)
+	defer os.Remove(filename)
+
+	_, err := Load(filename)
+	if err == nil || !strings.Contains(err.Error(), "checks.metrics.timers[1].percentile 'p90' is not one of p50, p75") {
+		t.Errorf("expected the unknown percentile to be reported, got %v", err)
+	}
+	if strings.Contains(err.Error(), "timers[0]") {
+		t.Errorf("the valid timer should not be reported, got %v", err)
+	}
+}
+
+func TestLoad_metricRules(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+vidispine:
+  host: vidispine.local
+checks:
+  metrics:
+    rules:
+      - name: heap
+        metrics: {heap: "gauge:jvm.memory.heap.usage"}
+        warning: "> 0.8"
+      - name: heap
+        metrics: {heap: "gauge:jvm.memory.heap.usage"}
+        value: heap * 100
+`)
+	defer os.Remove(filename)
+
+	_, err := Load(filename)
+	for _, expected := range []string{
+		"checks.metrics.rules[1].at least one of warning and critical must be given",
+		"checks.metrics.rules[1]: there is already a rule called 'heap'",
+	} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected '%s' to be reported, got %v", expected, err)
+		}
+	}
+	if strings.Contains(err.Error(), "rules[0]") {
+		t.Errorf("the valid rule should not be reported, got %v", err)
+	}
+}
+
+func TestLoad_metricThreads(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+vidispine:
+  host: vidispine.local
+checks:
+  metrics:
+    threads:
+      total_warning: 500
+      growth_polls: 20
+`)
+	defer os.Remove(filename)
+
+	_, err := Load(filename)
+	if err == nil || !strings.Contains(err.Error(), "checks.metrics.threads.growth_polls must be between 1 and 12") {
+		t.Errorf("expected growth_polls to be reported, got %v", err)
+	}
+
+	valid := writeTempConfig(t, `
+check_every: 5m
+vidispine:
+  host: vidispine.local
+checks:
+  metrics:
+    threads:
+      total_warning: 500
+      growth_polls: 0
+`)
+	defer os.Remove(valid)
+
+	cfg, loadErr := Load(valid)
+	if loadErr != nil {
+		t.Fatal(loadErr)
+	}
+	if threads := cfg.Checks.Metrics.Threads; threads.TotalWarning != 500 || threads.BlockedWarning != 20 {
+		t.Errorf("expected the total limit to be set and the rest defaulted, got %+v", threads)
+	}
+	if growthPolls := cfg.Checks.Metrics.Threads.WithDefaults().GrowthPolls; growthPolls != 0 {
+		t.Errorf("growth_polls: 0 should turn the growth alert off, got %d", growthPolls)
+	}
+}
+
+func TestLoad_metricMemory(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+vidispine:
+  host: vidispine.local
+checks:
+  metrics:
+    memory:
+      gc_time_warning: 0.4
+      leak_window: 30
+`)
+	defer os.Remove(filename)
+
+	_, err := Load(filename)
+	if err == nil || !strings.Contains(err.Error(), "checks.metrics.memory.gc_time_warning must not be higher than gc_time_critical") {
+		t.Errorf("expected gc_time_warning to be reported, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "checks.metrics.memory.leak_window must be between 2 and 12") {
+		t.Errorf("expected leak_window to be reported, got %v", err)
+	}
+}
+
+func TestLoad_metricJetty(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+vidispine:
+  host: vidispine.local
+checks:
+  metrics:
+    jetty:
+      pools: [dw]
+      utilization_critical: 1.5
+`)
+	defer os.Remove(filename)
+
+	_, err := Load(filename)
+	if err == nil || !strings.Contains(err.Error(), "checks.metrics.jetty.utilization_critical must be a fraction between 0 and 1, not 1.5") {
+		t.Errorf("expected utilization_critical to be reported, got %v", err)
+	}
+}
+
+func TestLoad_metricIndexer(t *testing.T) {
+	filename := writeTempConfig(t, `
+check_every: 5m
+vidispine:
+  host: vidispine.local
+checks:
+  metrics:
+    indexer:
+      query_warning: 30s
+      percentile: p90
+`)
+	defer os.Remove(filename)
+
+	_, err := Load(filename)
+	if err == nil || !strings.Contains(err.Error(), "checks.metrics.indexer.percentile 'p90' is not one of") {
+		t.Errorf("expected the percentile to be reported, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "checks.metrics.indexer.query_warning must not be higher than query_critical") {
+		t.Errorf("expected query_warning to be reported, got %v", err)
+	}
+}
+
+func TestLoad_legacyJSON(t *testing.T) {
+	filename := writeTempConfig(t, `{
+  "check_every": "5m",
+  "dry_run": true,
+  "pagerduty": {"integration_key": "integration", "api_key": "apikey"},
+  "vidispine": {"host": "vidispine.local", "api_https": true, "api_user": "admin", "api_passwd": "passwd"}
+}`)
+	defer os.Remove(filename)
+
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Error("legacy JSON config did not load: ", err)
+		t.FailNow()
+	}
+	if cfg.CheckEvery.Duration != 5*time.Minute || !cfg.DryRun {
+		t.Errorf("expected check_every 5m and dry_run, got %s and %t", cfg.CheckEvery, cfg.DryRun)
+	}
+	if cfg.Notifiers.PagerDuty.IntegrationKey.String() != "integration" || cfg.Notifiers.PagerDuty.ApiKey.String() != "apikey" {
+		t.Error("pagerduty keys were not moved under notifiers")
+	}
+	api := cfg.Vidispine.ApiEndpoint()
+	if api.BaseUrl != "https://vidispine.local:8080" || api.User != "admin" || api.Password != "passwd" {
+		t.Errorf("unexpected API endpoint from legacy config: %s as %s", api.BaseUrl, api.User)
+	}
+	if !cfg.Checks.Healthcheck.Enabled {
+		t.Error("legacy config should keep the default checks")
+	}
+
+	unknown := writeTempConfig(t, `{"pagerduty": {"integration_key": "integration"}, "vidispine": {"host": "vidispine.local", "api_user": "admin", "api_pass": "typo"}}`)
+	defer os.Remove(unknown)
+	if _, unknownErr := Load(unknown); unknownErr == nil {
+		t.Error("expected an unknown field in a legacy config to be rejected")
+	}
+}
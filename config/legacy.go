@@ -0,0 +1,68 @@
+package config
+
+import (
+	"gopkg.in/yaml.v2"
+	"log"
+)
+
+/**
+legacyConfig is the flat JSON layout CONFIG_FILE used before the YAML configuration, with the PagerDuty keys at the
+top level and the API credentials directly under vidispine. JSON is valid YAML, so files written for it are still
+read, but they can't use anything added since
+*/
+type legacyConfig struct {
+	CheckEvery Duration `yaml:"check_every"`
+	Verbose    bool     `yaml:"verbose"`
+	DryRun     bool     `yaml:"dry_run"`
+	PagerDuty  struct {
+		IntegrationKey Secret `yaml:"integration_key"`
+		ApiKey         Secret `yaml:"api_key"`
+	} `yaml:"pagerduty"`
+	Vidispine struct {
+		Host         string `yaml:"host"`
+		MonitorHttps bool   `yaml:"monitor_https"`
+		ApiHttps     bool   `yaml:"api_https"`
+		ApiUser      string `yaml:"api_user"`
+		ApiPasswd    Secret `yaml:"api_passwd"`
+	} `yaml:"vidispine"`
+}
+
+/**
+returns true if the content is in the legacy layout, i.e. it has a top-level pagerduty section or the API
+credentials directly under vidispine. Neither of those is allowed in the current layout
+*/
+func isLegacyLayout(content []byte) bool {
+	var markers struct {
+		PagerDuty interface{} `yaml:"pagerduty"`
+		Vidispine struct {
+			ApiUser   interface{} `yaml:"api_user"`
+			ApiPasswd interface{} `yaml:"api_passwd"`
+		} `yaml:"vidispine"`
+	}
+	if err := yaml.Unmarshal(content, &markers); err != nil {
+		return false
+	}
+	return markers.PagerDuty != nil || markers.Vidispine.ApiUser != nil || markers.Vidispine.ApiPasswd != nil
+}
+
+/**
+reads content in the legacy layout into cfg, which should already hold the defaults
+*/
+func unmarshalLegacy(filename string, content []byte, cfg *Config) error {
+	var legacy legacyConfig
+	if err := yaml.UnmarshalStrict(content, &legacy); err != nil {
+		return err
+	}
+	log.Printf("WARNING %s uses the old JSON layout, see config/example.yaml for the current one", filename)
+	cfg.CheckEvery = legacy.CheckEvery
+	cfg.Verbose = legacy.Verbose
+	cfg.DryRun = legacy.DryRun
+	cfg.Notifiers.PagerDuty.IntegrationKey = legacy.PagerDuty.IntegrationKey
+	cfg.Notifiers.PagerDuty.ApiKey = legacy.PagerDuty.ApiKey
+	cfg.Vidispine.Host = legacy.Vidispine.Host
+	cfg.Vidispine.MonitorHttps = legacy.Vidispine.MonitorHttps
+	cfg.Vidispine.ApiHttps = legacy.Vidispine.ApiHttps
+	cfg.Vidispine.Credentials.User = legacy.Vidispine.ApiUser
+	cfg.Vidispine.Credentials.Password = legacy.Vidispine.ApiPasswd
+	return nil
+}
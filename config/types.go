@@ -0,0 +1,161 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+/**
+Duration is a time.Duration that is written in config files as a string, e.g. "5m". It lives in common so that
+the checks' own settings can use it too
+*/
+type Duration = common.Duration
+
+/**
+Secret is a credential, which can either be given directly in the config file or as a reference to
+where to find it:
+
+	password: hunter2
+	password: {env: VIDISPINE_API_PASSWD}
+	password: {file: /var/run/secrets/vidispine/password}
+	password: {exec: [vault, kv, get, -field=password, secret/vidispine]}
+
+Files are watched, so a rotated secret is picked up without a restart. Credential helpers are run each time the
+configuration is loaded, which SIGHUP also triggers
+*/
+type Secret struct {
+	Value string   `yaml:"-"`
+	Env   string   `yaml:"env,omitempty"`  //name of an environment variable holding the secret
+	File  string   `yaml:"file,omitempty"` //file holding the secret, e.g. a mounted Kubernetes secret
+	Exec  []string `yaml:"exec,omitempty"` //credential helper command and its arguments, which prints the secret
+}
+
+type secretRef struct {
+	Env  string   `yaml:"env,omitempty"`
+	File string   `yaml:"file,omitempty"`
+	Exec []string `yaml:"exec,omitempty"`
+}
+
+// how long a credential helper may take
+const secretExecTimeout = 30 * time.Second
+
+func (s *Secret) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var literal string
+	if err := unmarshal(&literal); err == nil {
+		*s = Secret{Value: literal}
+		return nil
+	}
+
+	var ref secretRef
+	if err := unmarshal(&ref); err != nil {
+		return fmt.Errorf("a secret must be a string or a reference like {env: NAME}, {file: PATH} or {exec: [COMMAND]}: %s", err)
+	}
+	given := 0
+	for _, isGiven := range []bool{ref.Env != "", ref.File != "", len(ref.Exec) > 0} {
+		if isGiven {
+			given++
+		}
+	}
+	if given != 1 {
+		return errors.New("a secret reference must give exactly one of the env variable, file or exec command to read")
+	}
+	*s = Secret{Env: ref.Env, File: ref.File, Exec: ref.Exec}
+	return nil
+}
+
+/**
+returns true if the secret is read from somewhere rather than given directly
+*/
+func (s Secret) isRef() bool {
+	return s.Env != "" || s.File != "" || len(s.Exec) > 0
+}
+
+/**
+returns true if the secret has a value or says where to find one
+*/
+func (s Secret) given() bool {
+	return s.Value != "" || s.isRef()
+}
+
+/**
+looks up the value of a secret given by reference. Returns an error if the reference can't be resolved
+*/
+func (s *Secret) resolve() error {
+	switch {
+	case s.Env != "":
+		s.Value = os.Getenv(s.Env)
+		if s.Value == "" {
+			return fmt.Errorf("environment variable %s is not set", s.Env)
+		}
+	case s.File != "":
+		content, readErr := ioutil.ReadFile(s.File)
+		if readErr != nil {
+			return fmt.Errorf("could not read secret file: %s", readErr)
+		}
+		s.Value = strings.TrimRight(string(content), "\r\n")
+		if s.Value == "" {
+			return fmt.Errorf("secret file %s is empty", s.File)
+		}
+	case len(s.Exec) > 0:
+		value, execErr := runCredentialHelper(s.Exec)
+		if execErr != nil {
+			return execErr
+		}
+		s.Value = value
+	}
+	return nil
+}
+
+/**
+runs a credential helper and returns what it printed, without the trailing newline
+*/
+func runCredentialHelper(command []string) (string, error) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), secretExecTimeout)
+	defer cancelFunc()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if runErr := cmd.Run(); runErr != nil {
+		if ctx.Err() != nil {
+			runErr = fmt.Errorf("timed out after %s", secretExecTimeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("credential helper %s failed: %s: %s", command[0], runErr, msg)
+		}
+		return "", fmt.Errorf("credential helper %s failed: %s", command[0], runErr)
+	}
+	value := strings.TrimRight(stdout.String(), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("credential helper %s printed nothing", command[0])
+	}
+	return value, nil
+}
+
+const redacted = "<redacted>"
+
+/**
+secrets are never written out, only references to where they come from
+*/
+func (s Secret) MarshalYAML() (interface{}, error) {
+	if s.isRef() {
+		return secretRef{Env: s.Env, File: s.File, Exec: s.Exec}, nil
+	}
+	if s.Value != "" {
+		return redacted, nil
+	}
+	return "", nil
+}
+
+func (s Secret) String() string {
+	return s.Value
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+/**
+Watcher reloads the configuration when the process receives SIGHUP or when the config file, or a file that a
+secret is read from, changes.
+Only configurations that load and validate cleanly are sent on Reloaded; if the new configuration is
+invalid the problem is logged and the existing configuration stays in place
+*/
+type Watcher struct {
+	Filename     string
+	PollInterval time.Duration
+	Reloaded     chan *Config
+
+	lastModTime time.Time
+	lastSize    int64
+	secretFiles map[string]fileState
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(filename string) (fileState, bool) {
+	info, statErr := os.Stat(filename)
+	if statErr != nil {
+		return fileState{}, false
+	}
+	return fileState{modTime: info.ModTime(), size: info.Size()}, true
+}
+
+func NewWatcher(filename string) *Watcher {
+	w := &Watcher{
+		Filename:     filename,
+		PollInterval: 10 * time.Second,
+		Reloaded:     make(chan *Config, 1),
+	}
+	w.fileChanged() //record the starting state of the file
+	return w
+}
+
+/**
+returns true if the config file's modification time or size has changed since the last call.
+Kubernetes updates mounted ConfigMaps by swapping a symlink, so we stat through it
+*/
+func (w *Watcher) fileChanged() bool {
+	if w.Filename == "" {
+		return false
+	}
+	info, statErr := os.Stat(w.Filename)
+	if statErr != nil {
+		return false
+	}
+	changed := !info.ModTime().Equal(w.lastModTime) || info.Size() != w.lastSize
+	w.lastModTime = info.ModTime()
+	w.lastSize = info.Size()
+	return changed
+}
+
+/**
+starts watching the files that the configuration's secrets are read from, in place of any watched before.
+Call this with the configuration the app started with; reloaded configurations are watched automatically
+*/
+func (w *Watcher) WatchSecrets(cfg *Config) {
+	w.secretFiles = make(map[string]fileState)
+	for _, filename := range cfg.SecretFiles() {
+		state, _ := statFile(filename)
+		w.secretFiles[filename] = state
+	}
+}
+
+/**
+returns true if any secret file has changed since the last call, e.g. because a Kubernetes secret was rotated
+*/
+func (w *Watcher) secretsChanged() bool {
+	changed := false
+	for filename, last := range w.secretFiles {
+		state, exists := statFile(filename)
+		if !exists {
+			//kubernetes briefly removes the link while swapping in new content, wait for it to come back
+			continue
+		}
+		if !state.modTime.Equal(last.modTime) || state.size != last.size {
+			changed = true
+			w.secretFiles[filename] = state
+		}
+	}
+	return changed
+}
+
+func (w *Watcher) reload(reason string) {
+	log.Printf("INFO %s, reloading configuration", reason)
+	newConfig, loadErr := Load(w.Filename)
+	if loadErr != nil {
+		log.Printf("ERROR new configuration is not valid, keeping the existing configuration: %s", loadErr)
+		return
+	}
+	w.WatchSecrets(newConfig)
+
+	//if a previous reload has not been picked up yet then replace it, only the latest one matters
+	select {
+	case <-w.Reloaded:
+	default:
+	}
+	w.Reloaded <- newConfig
+}
+
+/**
+watches for changes until the context is cancelled
+*/
+func (w *Watcher) Run(ctx context.Context) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			w.fileChanged()
+			w.reload("received SIGHUP")
+		case <-ticker.C:
+			if w.fileChanged() {
+				w.reload(w.Filename + " changed")
+			} else if w.secretsChanged() {
+				w.reload("a secret file changed")
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/config"
+	"os"
+)
+
+/**
+loads the configuration for the validate-config and print-config commands, printing every problem found.
+Returns nil if the configuration is not valid
+*/
+func loadConfigForCommand(name string, args []string) *config.Config {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML config file to check, defaults to CONFIG_FILE")
+	ignoreSecrets := flags.Bool("ignore-missing-secrets", false, "don't fail on secrets or certificate files that can't be read, e.g. when checking in CI")
+	flags.Parse(args)
+
+	cfg, err := config.LoadWithOptions(*configFile, config.LoadOptions{IgnoreMissingSecrets: *ignoreSecrets})
+	if err != nil {
+		if problems, isProblems := err.(config.Errors); isProblems {
+			if len(problems) == 1 {
+				fmt.Fprintln(os.Stderr, "Found 1 problem with the configuration:")
+			} else {
+				fmt.Fprintf(os.Stderr, "Found %d problems with the configuration:\n", len(problems))
+			}
+			for _, p := range problems {
+				fmt.Fprintf(os.Stderr, "  - %s\n", p)
+			}
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return nil
+	}
+	return cfg
+}
+
+/**
+implements the 'validate-config' subcommand, which checks the effective configuration (file plus environment)
+and reports every problem. Returns the exit code for the process
+*/
+func runValidateConfigCommand(args []string) int {
+	cfg := loadConfigForCommand("validate-config", args)
+	if cfg == nil {
+		return 1
+	}
+	if _, buildErr := buildTargets(cfg, nil); buildErr != nil {
+		fmt.Fprintln(os.Stderr, "The configuration is not valid: ", buildErr)
+		return 1
+	}
+	fmt.Println("The configuration is valid")
+	return 0
+}
+
+/**
+implements the 'print-config' subcommand, which writes out the effective configuration as YAML with secrets
+redacted. Returns the exit code for the process
+*/
+func runPrintConfigCommand(args []string) int {
+	cfg := loadConfigForCommand("print-config", args)
+	if cfg == nil {
+		return 1
+	}
+	content, marshalErr := cfg.MarshalRedacted()
+	if marshalErr != nil {
+		fmt.Fprintln(os.Stderr, "Could not write out the configuration: ", marshalErr)
+		return 1
+	}
+	os.Stdout.Write(content)
+	return 0
+}
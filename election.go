@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/leader"
+	"log"
+	"os"
+	"time"
+)
+
+/**
+builds a leader.Elector from the environment, or returns nil if leader election is not enabled
+*/
+func setupLeaderElection() *leader.Elector {
+	electionType := os.Getenv("LEADER_ELECTION")           //'file' or 'kubernetes', if set then only the leader sends alerts
+	lockFile := os.Getenv("LEADER_LOCK_FILE")              //path to the lock file on shared storage, for 'file'
+	leaseName := os.Getenv("LEADER_LEASE_NAME")            //name of the Lease object, for 'kubernetes'
+	leaseNamespace := os.Getenv("LEADER_LEASE_NAMESPACE")  //namespace of the Lease object, defaults to the pod's namespace
+	leaseDurationStr := os.Getenv("LEADER_LEASE_DURATION") //how long a lease lasts without renewal, parsed as a duration
+	identity := os.Getenv("POD_NAME")                      //identity of this replica, defaults to the hostname
+
+	if electionType == "" {
+		return nil
+	}
+
+	if identity == "" {
+		var hostErr error
+		identity, hostErr = os.Hostname()
+		if hostErr != nil {
+			log.Fatal("Could not determine an identity for leader election, set POD_NAME: ", hostErr)
+		}
+	}
+
+	leaseDuration := 30 * time.Second
+	if leaseDurationStr != "" {
+		var durParseErr error
+		leaseDuration, durParseErr = time.ParseDuration(leaseDurationStr)
+		if durParseErr != nil {
+			log.Fatalf("LEADER_LEASE_DURATION value %s is not a valid duration: %s", leaseDurationStr, durParseErr)
+		}
+		if leaseDuration < 3*time.Second {
+			log.Fatalf("LEADER_LEASE_DURATION must be at least 3s")
+		}
+	}
+
+	var lock leader.Lock
+	switch electionType {
+	case "file":
+		if lockFile == "" {
+			log.Fatal("You must specify LEADER_LOCK_FILE when LEADER_ELECTION=file")
+		}
+		lock = leader.NewFileLock(lockFile)
+	case "kubernetes":
+		if leaseName == "" {
+			leaseName = "vidispine-monitor"
+		}
+		client, clientErr := leader.NewInClusterLeaseClient(leaseNamespace)
+		if clientErr != nil {
+			log.Fatal("Could not set up kubernetes leader election: ", clientErr)
+		}
+		lock = &leader.KubeLeaseLock{Client: client, Name: leaseName}
+	default:
+		log.Fatalf("The value %s for LEADER_ELECTION is not valid, expected 'file' or 'kubernetes'", electionType)
+	}
+
+	log.Printf("INFO leader election enabled on %s as %s, lease duration %s", lock.Describe(), identity, leaseDuration)
+	return &leader.Elector{
+		Lock:          lock,
+		Identity:      identity,
+		LeaseDuration: leaseDuration,
+		RetryPeriod:   leaseDuration / 3,
+	}
+}
+
+/**
+makes a first attempt to take the lock, so that we know whether we are the leader before the first alert,
+then runs the elector in the background. The returned function stops it and releases the lock, and should be
+called before the process exits
+*/
+func startLeaderElection(elector *leader.Elector) func() {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	elector.Tick(ctx)
+	done := make(chan struct{})
+	go func() {
+		elector.Run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancelFunc()
+		<-done
+	}
+}
@@ -1,3 +1,5 @@
 module gitlab.com/codmill/customer-projects/guardian/vidispine-monitor
 
 go 1.14
+
+require gopkg.in/yaml.v2 v2.4.0
@@ -0,0 +1,112 @@
+package leader
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+/**
+Lock is a lease-based lock that only one identity can hold at a time. A holder must renew the lease
+before LeaseDuration has passed, otherwise any other identity may take it over
+*/
+type Lock interface {
+	//take the lock if it is free or has expired, or renew it if we already hold it. Returns true if identity holds the lock afterwards
+	TryAcquireOrRenew(ctx context.Context, identity string, leaseDuration time.Duration, now time.Time) (bool, error)
+	//give up the lock if identity holds it, so that another replica can take over straight away
+	Release(ctx context.Context, identity string) error
+	//return a descriptive name for the lock, for logging
+	Describe() string
+}
+
+/**
+Elector repeatedly tries to take or renew a Lock, and tracks whether this replica is the leader.
+A leader that dies stops renewing, so another replica takes over within LeaseDuration + RetryPeriod.
+A leader that cannot renew its lease steps down once the lease it last renewed has run out, so two
+replicas never both believe they are the leader for longer than a single RetryPeriod
+*/
+type Elector struct {
+	Lock          Lock
+	Identity      string
+	LeaseDuration time.Duration
+	RetryPeriod   time.Duration
+	Now           func() time.Time //clock to use, defaults to time.Now
+
+	isLeader     int32
+	leaseExpires time.Time
+}
+
+func (e *Elector) now() time.Time {
+	if e.Now != nil {
+		return e.Now()
+	}
+	return time.Now()
+}
+
+/**
+returns true if this replica currently holds the lock
+*/
+func (e *Elector) IsLeader() bool {
+	return atomic.LoadInt32(&e.isLeader) == 1
+}
+
+func (e *Elector) setLeader(leader bool) {
+	var newValue int32 = 0
+	if leader {
+		newValue = 1
+	}
+	oldValue := atomic.SwapInt32(&e.isLeader, newValue)
+	if oldValue == 0 && leader {
+		log.Printf("INFO %s is now the leader on %s", e.Identity, e.Lock.Describe())
+	} else if oldValue == 1 && !leader {
+		log.Printf("WARNING %s is no longer the leader on %s", e.Identity, e.Lock.Describe())
+	}
+}
+
+/**
+makes a single attempt to take or renew the lock and updates IsLeader accordingly
+*/
+func (e *Elector) Tick(ctx context.Context) {
+	now := e.now()
+	acquired, err := e.Lock.TryAcquireOrRenew(ctx, e.Identity, e.LeaseDuration, now)
+	if err != nil {
+		log.Printf("ERROR could not renew leadership on %s: %s", e.Lock.Describe(), err)
+		//we can't tell whether anyone else has the lock, so keep leading only while our last lease is valid
+		if e.IsLeader() && !now.Before(e.leaseExpires) {
+			e.setLeader(false)
+		}
+		return
+	}
+	if acquired {
+		e.leaseExpires = now.Add(e.LeaseDuration)
+	}
+	e.setLeader(acquired)
+}
+
+/**
+runs the election until the context is cancelled, then releases the lock if we hold it
+*/
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.RetryPeriod)
+	defer ticker.Stop()
+
+	e.Tick(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			if e.IsLeader() {
+				releaseCtx, cancelFunc := context.WithTimeout(context.Background(), e.RetryPeriod)
+				releaseErr := e.Lock.Release(releaseCtx, e.Identity)
+				cancelFunc()
+				if releaseErr != nil {
+					log.Printf("ERROR could not release leadership on %s: %s", e.Lock.Describe(), releaseErr)
+				}
+				e.setLeader(false)
+			}
+			return
+		case <-ticker.C:
+			e.Tick(ctx)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	current time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.current
+}
+
+/**
+when the leader dies, another replica must take over within LeaseDuration + RetryPeriod
+*/
+func TestElector_failover(t *testing.T) {
+	clock := &fakeClock{current: time.Now()}
+	lock := &KubeLeaseLock{Client: newFakeLeaseClient(), Name: "vidispine-monitor"}
+	ctx := context.Background()
+
+	a := &Elector{Lock: lock, Identity: "replica-a", LeaseDuration: 15 * time.Second, RetryPeriod: 5 * time.Second, Now: clock.Now}
+	b := &Elector{Lock: lock, Identity: "replica-b", LeaseDuration: 15 * time.Second, RetryPeriod: 5 * time.Second, Now: clock.Now}
+
+	a.Tick(ctx)
+	b.Tick(ctx)
+	if !a.IsLeader() || b.IsLeader() {
+		t.Errorf("expected only replica-a to lead, got a=%v b=%v", a.IsLeader(), b.IsLeader())
+	}
+
+	//replica-a dies; replica-b keeps retrying
+	tookOver := time.Duration(-1)
+	for elapsed := 5 * time.Second; elapsed <= 30*time.Second; elapsed += 5 * time.Second {
+		clock.current = clock.current.Add(5 * time.Second)
+		b.Tick(ctx)
+		if b.IsLeader() {
+			tookOver = elapsed
+			break
+		}
+	}
+	if tookOver < 0 || tookOver > a.LeaseDuration+a.RetryPeriod {
+		t.Errorf("replica-b did not take over within the lease duration, took %s", tookOver)
+	}
+}
+
+type failingLock struct{}
+
+func (f failingLock) TryAcquireOrRenew(ctx context.Context, identity string, leaseDuration time.Duration, now time.Time) (bool, error) {
+	return false, errors.New("storage unavailable")
+}
+
+func (f failingLock) Release(ctx context.Context, identity string) error {
+	return nil
+}
+
+func (f failingLock) Describe() string {
+	return "failing lock"
+}
+
+/**
+a leader that can't renew must step down once its lease has run out
+*/
+func TestElector_stepsDownWhenRenewFails(t *testing.T) {
+	clock := &fakeClock{current: time.Now()}
+	ctx := context.Background()
+	lock := &KubeLeaseLock{Client: newFakeLeaseClient(), Name: "vidispine-monitor"}
+	e := &Elector{Lock: lock, Identity: "replica-a", LeaseDuration: 15 * time.Second, RetryPeriod: 5 * time.Second, Now: clock.Now}
+	e.Tick(ctx)
+	if !e.IsLeader() {
+		t.Error("replica-a should lead")
+		t.FailNow()
+	}
+
+	e.Lock = failingLock{}
+	clock.current = clock.current.Add(10 * time.Second)
+	e.Tick(ctx)
+	if !e.IsLeader() {
+		t.Error("replica-a stepped down while its lease was still valid")
+	}
+	clock.current = clock.current.Add(5 * time.Second)
+	e.Tick(ctx)
+	if e.IsLeader() {
+		t.Error("replica-a did not step down after its lease ran out")
+	}
+}
+
+func TestFileLock(t *testing.T) {
+	dir, tempErr := ioutil.TempDir("", "leadertest")
+	if tempErr != nil {
+		t.Error(tempErr)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	lock := NewFileLock(filepath.Join(dir, "vidispine-monitor.lock"))
+	now := time.Now()
+	ctx := context.Background()
+
+	acquired, err := lock.TryAcquireOrRenew(ctx, "replica-a", 30*time.Second, now)
+	if err != nil || !acquired {
+		t.Errorf("replica-a should have acquired the lock file, got %v %v", acquired, err)
+	}
+	acquired, err = lock.TryAcquireOrRenew(ctx, "replica-b", 30*time.Second, now.Add(10*time.Second))
+	if err != nil || acquired {
+		t.Errorf("replica-b should not acquire a held lock file, got %v %v", acquired, err)
+	}
+	acquired, err = lock.TryAcquireOrRenew(ctx, "replica-b", 30*time.Second, now.Add(31*time.Second))
+	if err != nil || !acquired {
+		t.Errorf("replica-b should have taken the expired lock file, got %v %v", acquired, err)
+	}
+
+	//a mutex left behind by a crashed replica must not block us forever
+	ioutil.WriteFile(lock.mutexPath(), []byte{}, 0644)
+	_, err = lock.TryAcquireOrRenew(ctx, "replica-b", 30*time.Second, time.Now())
+	if err == nil {
+		t.Error("expected an error while another replica holds the mutex")
+	}
+	acquired, err = lock.TryAcquireOrRenew(ctx, "replica-b", 30*time.Second, time.Now().Add(time.Minute))
+	if err != nil || !acquired {
+		t.Errorf("stale mutex was not cleared, got %v %v", acquired, err)
+	}
+
+	if releaseErr := lock.Release(ctx, "replica-b"); releaseErr != nil {
+		t.Error("Release returned an unexpected error: ", releaseErr)
+	}
+	acquired, _ = lock.TryAcquireOrRenew(ctx, "replica-a", 30*time.Second, time.Now())
+	if !acquired {
+		t.Error("replica-a should acquire a released lock file")
+	}
+}
+
+/**
+when two replicas find the same stale mutex at once, only one of them may take it over
+*/
+func TestFileLock_concurrentTakeover(t *testing.T) {
+	dir, tempErr := ioutil.TempDir("", "leadertest")
+	if tempErr != nil {
+		t.Error(tempErr)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "vidispine-monitor.lock")
+	lockers := []*FileLock{NewFileLock(path), NewFileLock(path)}
+	var holders, overlaps, acquired int32
+
+	for i := 0; i < 200; i++ {
+		ioutil.WriteFile(lockers[0].mutexPath(), []byte{}, 0644)
+		staleTime := time.Now().Add(-time.Minute)
+		os.Chtimes(lockers[0].mutexPath(), staleTime, staleTime)
+
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for _, locker := range lockers {
+			wg.Add(1)
+			go func(locker *FileLock) {
+				defer wg.Done()
+				<-start
+				if locker.lockMutex(time.Now()) != nil {
+					return
+				}
+				atomic.AddInt32(&acquired, 1)
+				if atomic.AddInt32(&holders, 1) > 1 {
+					atomic.AddInt32(&overlaps, 1)
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&holders, -1)
+				locker.unlockMutex()
+			}(locker)
+		}
+		close(start)
+		wg.Wait()
+	}
+
+	if overlaps > 0 {
+		t.Errorf("both replicas held the mutex at once %d times", overlaps)
+	}
+	if acquired < 200 {
+		t.Errorf("the stale mutex should have been taken over every time, but only was %d times out of 200", acquired)
+	}
+	leftovers, _ := filepath.Glob(path + ".mutex.stale*")
+	if len(leftovers) > 0 {
+		t.Errorf("stale mutexes were left behind: %v", leftovers)
+	}
+}
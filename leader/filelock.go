@@ -0,0 +1,188 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/**
+the content of a lock file
+*/
+type fileLockRecord struct {
+	Holder     string    `json:"holder"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	RenewedAt  time.Time `json:"renewedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+/**
+FileLock keeps the lease in a JSON file on storage shared between the replicas, e.g. an NFS or EFS volume.
+Updates to the file are serialised with an exclusively-created ".mutex" file next to it, which is
+taken over if it is older than MutexTimeout in case its creator died part-way through
+*/
+type FileLock struct {
+	Path         string
+	MutexTimeout time.Duration
+}
+
+func NewFileLock(path string) *FileLock {
+	return &FileLock{
+		Path:         path,
+		MutexTimeout: 10 * time.Second,
+	}
+}
+
+func (l *FileLock) Describe() string {
+	return "lock file " + l.Path
+}
+
+func (l *FileLock) mutexPath() string {
+	return l.Path + ".mutex"
+}
+
+func (l *FileLock) createMutex() error {
+	f, err := os.OpenFile(l.mutexPath(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (l *FileLock) lockMutex(now time.Time) error {
+	err := l.createMutex()
+	if err == nil || !os.IsExist(err) {
+		return err
+	}
+
+	if l.takeOverMutex(now) && l.createMutex() == nil {
+		return nil
+	}
+	return errors.New("lock file is being updated by another replica")
+}
+
+/**
+moves the mutex out of the way if it is older than MutexTimeout, returning true if this replica was the one that
+moved it. Only one replica's rename can move the stale file; if another replica got there first and has already
+made a new mutex, that is what gets moved, so it is put back and this replica backs off
+*/
+func (l *FileLock) takeOverMutex(now time.Time) bool {
+	info, statErr := os.Stat(l.mutexPath())
+	if statErr != nil || now.Sub(info.ModTime()) <= l.MutexTimeout {
+		return false
+	}
+
+	staleFile, tempErr := ioutil.TempFile(filepath.Dir(l.Path), filepath.Base(l.mutexPath())+".stale")
+	if tempErr != nil {
+		return false
+	}
+	staleFile.Close()
+	defer os.Remove(staleFile.Name())
+	if renameErr := os.Rename(l.mutexPath(), staleFile.Name()); renameErr != nil {
+		return false
+	}
+
+	//inode numbers get reused once the stale file is gone, so the modification time has to match as well
+	moved, movedErr := os.Stat(staleFile.Name())
+	if movedErr == nil && os.SameFile(info, moved) && moved.ModTime().Equal(info.ModTime()) {
+		return true
+	}
+	//link rather than rename, so that a mutex made since is never replaced
+	os.Link(staleFile.Name(), l.mutexPath())
+	return false
+}
+
+func (l *FileLock) unlockMutex() {
+	os.Remove(l.mutexPath())
+}
+
+func (l *FileLock) read() (*fileLockRecord, error) {
+	content, err := ioutil.ReadFile(l.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var record fileLockRecord
+	unmarshalErr := json.Unmarshal(content, &record)
+	if unmarshalErr != nil {
+		return nil, fmt.Errorf("lock file %s is corrupted: %s", l.Path, unmarshalErr)
+	}
+	return &record, nil
+}
+
+/**
+writes the record to a temporary file and renames it into place, so that readers never see a partial file
+*/
+func (l *FileLock) write(record *fileLockRecord) error {
+	content, marshalErr := json.Marshal(record)
+	if marshalErr != nil {
+		return marshalErr
+	}
+	tempFile, tempErr := ioutil.TempFile(filepath.Dir(l.Path), filepath.Base(l.Path)+".tmp")
+	if tempErr != nil {
+		return tempErr
+	}
+	_, writeErr := tempFile.Write(content)
+	closeErr := tempFile.Close()
+	if writeErr != nil || closeErr != nil {
+		os.Remove(tempFile.Name())
+		if writeErr != nil {
+			return writeErr
+		}
+		return closeErr
+	}
+	return os.Rename(tempFile.Name(), l.Path)
+}
+
+func (l *FileLock) TryAcquireOrRenew(ctx context.Context, identity string, leaseDuration time.Duration, now time.Time) (bool, error) {
+	if mutexErr := l.lockMutex(now); mutexErr != nil {
+		return false, mutexErr
+	}
+	defer l.unlockMutex()
+
+	record, readErr := l.read()
+	if readErr != nil {
+		return false, readErr
+	}
+
+	if record != nil && record.Holder != identity && now.Before(record.ExpiresAt) {
+		return false, nil //someone else holds a valid lease
+	}
+
+	if record == nil || record.Holder != identity {
+		record = &fileLockRecord{
+			Holder:     identity,
+			AcquiredAt: now,
+		}
+	}
+	record.RenewedAt = now
+	record.ExpiresAt = now.Add(leaseDuration)
+
+	if writeErr := l.write(record); writeErr != nil {
+		return false, writeErr
+	}
+	return true, nil
+}
+
+func (l *FileLock) Release(ctx context.Context, identity string) error {
+	if mutexErr := l.lockMutex(time.Now()); mutexErr != nil {
+		return mutexErr
+	}
+	defer l.unlockMutex()
+
+	record, readErr := l.read()
+	if readErr != nil {
+		return readErr
+	}
+	if record == nil || record.Holder != identity {
+		return nil
+	}
+	return os.Remove(l.Path)
+}
@@ -0,0 +1,144 @@
+package leader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+/**
+InClusterLeaseClient talks to the Kubernetes API server using the pod's service account.
+The service account needs get, create and update permissions on leases in the namespace
+*/
+type InClusterLeaseClient struct {
+	ApiServer  string
+	Namespace  string
+	TokenFile  string
+	HttpClient *http.Client
+}
+
+/**
+builds an InClusterLeaseClient from the environment and service account that Kubernetes gives every pod.
+If namespace is empty, the pod's own namespace is used
+*/
+func NewInClusterLeaseClient(namespace string) (*InClusterLeaseClient, error) {
+	host := os.Getenv("KUBERNETES_SERVICE_HOST")
+	port := os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set, are we running in a cluster?")
+	}
+
+	if namespace == "" {
+		nsContent, nsErr := ioutil.ReadFile(serviceAccountDir + "/namespace")
+		if nsErr != nil {
+			return nil, fmt.Errorf("could not determine namespace: %s", nsErr)
+		}
+		namespace = strings.TrimSpace(string(nsContent))
+	}
+
+	caContent, caErr := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if caErr != nil {
+		return nil, fmt.Errorf("could not read cluster CA: %s", caErr)
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caContent) {
+		return nil, errors.New("cluster CA file did not contain any certificates")
+	}
+
+	return &InClusterLeaseClient{
+		ApiServer: "https://" + net.JoinHostPort(host, port),
+		Namespace: namespace,
+		TokenFile: serviceAccountDir + "/token",
+		HttpClient: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: caPool},
+			},
+		},
+	}, nil
+}
+
+func (c *InClusterLeaseClient) leaseUrl(name string) string {
+	base := fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", c.ApiServer, c.Namespace)
+	if name == "" {
+		return base
+	}
+	return base + "/" + name
+}
+
+func (c *InClusterLeaseClient) do(ctx context.Context, method string, url string, body *Lease) (*Lease, error) {
+	var bodyReader *bytes.Reader
+	if body != nil {
+		content, marshalErr := json.Marshal(body)
+		if marshalErr != nil {
+			return nil, marshalErr
+		}
+		bodyReader = bytes.NewReader(content)
+	} else {
+		bodyReader = bytes.NewReader(nil)
+	}
+
+	httpReq, reqErr := http.NewRequestWithContext(ctx, method, url, bodyReader)
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	//the token is re-read each time as kubernetes rotates it
+	token, tokenErr := ioutil.ReadFile(c.TokenFile)
+	if tokenErr != nil {
+		return nil, tokenErr
+	}
+	httpReq.Header.Add("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	httpReq.Header.Add("Accept", "application/json")
+	httpReq.Header.Add("Content-Type", "application/json")
+
+	response, httpErr := c.HttpClient.Do(httpReq)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	defer response.Body.Close()
+
+	content, readErr := ioutil.ReadAll(response.Body)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		return nil, ErrLeaseNotFound
+	case response.StatusCode == http.StatusConflict:
+		return nil, ErrLeaseConflict
+	case response.StatusCode < 200 || response.StatusCode > 299:
+		return nil, fmt.Errorf("kubernetes returned %d: %s", response.StatusCode, string(content))
+	}
+
+	var lease Lease
+	unmarshalErr := json.Unmarshal(content, &lease)
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+	return &lease, nil
+}
+
+func (c *InClusterLeaseClient) Get(ctx context.Context, name string) (*Lease, error) {
+	return c.do(ctx, "GET", c.leaseUrl(name), nil)
+}
+
+func (c *InClusterLeaseClient) Create(ctx context.Context, lease *Lease) (*Lease, error) {
+	return c.do(ctx, "POST", c.leaseUrl(""), lease)
+}
+
+func (c *InClusterLeaseClient) Update(ctx context.Context, lease *Lease) (*Lease, error) {
+	return c.do(ctx, "PUT", c.leaseUrl(lease.Metadata.Name), lease)
+}
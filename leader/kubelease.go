@@ -0,0 +1,281 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrLeaseNotFound = errors.New("lease not found")
+var ErrLeaseConflict = errors.New("lease was modified by someone else")
+
+/**
+the time format used by Kubernetes for MicroTime fields
+*/
+const microTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+/**
+MicroTime is a time that (un)marshals in the Kubernetes MicroTime format
+*/
+type MicroTime struct {
+	time.Time
+}
+
+func (t MicroTime) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return []byte(`"` + t.UTC().Format(microTimeFormat) + `"`), nil
+}
+
+func (t *MicroTime) UnmarshalJSON(data []byte) error {
+	str := strings.Trim(string(data), `"`)
+	if str == "null" || str == "" {
+		t.Time = time.Time{}
+		return nil
+	}
+	parsed, err := time.Parse(microTimeFormat, str)
+	if err != nil {
+		parsed, err = time.Parse(time.RFC3339Nano, str)
+	}
+	t.Time = parsed
+	return err
+}
+
+type LeaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type LeaseSpec struct {
+	HolderIdentity       *string    `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds *int32     `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *MicroTime `json:"acquireTime,omitempty"`
+	RenewTime            *MicroTime `json:"renewTime,omitempty"`
+	LeaseTransitions     *int32     `json:"leaseTransitions,omitempty"`
+}
+
+/**
+the parts of a coordination.k8s.io/v1 Lease that we use. A Lease read from the API server keeps the whole object
+it was read from, so that fields we don't model (labels, annotations, ownerReferences and so on) are sent back
+unchanged when it is updated
+*/
+type Lease struct {
+	ApiVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   LeaseMetadata `json:"metadata"`
+	Spec       LeaseSpec     `json:"spec"`
+
+	object map[string]json.RawMessage
+}
+
+/**
+the Lease fields without its (un)marshalling methods
+*/
+type leaseFields struct {
+	ApiVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   LeaseMetadata `json:"metadata"`
+	Spec       LeaseSpec     `json:"spec"`
+}
+
+/**
+the keys of the metadata and spec objects that Lease models. Any others are left as they were read
+*/
+var leaseMetadataKeys = []string{"name", "namespace", "resourceVersion"}
+var leaseSpecKeys = []string{"holderIdentity", "leaseDurationSeconds", "acquireTime", "renewTime", "leaseTransitions"}
+
+func (l *Lease) UnmarshalJSON(data []byte) error {
+	var fields leaseFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+	*l = Lease{
+		ApiVersion: fields.ApiVersion,
+		Kind:       fields.Kind,
+		Metadata:   fields.Metadata,
+		Spec:       fields.Spec,
+		object:     object,
+	}
+	return nil
+}
+
+func (l Lease) MarshalJSON() ([]byte, error) {
+	fields := leaseFields{
+		ApiVersion: l.ApiVersion,
+		Kind:       l.Kind,
+		Metadata:   l.Metadata,
+		Spec:       l.Spec,
+	}
+	if l.object == nil {
+		return json.Marshal(fields)
+	}
+
+	metadata, metadataErr := overlayObject(l.object["metadata"], fields.Metadata, leaseMetadataKeys)
+	if metadataErr != nil {
+		return nil, metadataErr
+	}
+	spec, specErr := overlayObject(l.object["spec"], fields.Spec, leaseSpecKeys)
+	if specErr != nil {
+		return nil, specErr
+	}
+	object := make(map[string]json.RawMessage, len(l.object))
+	for key, value := range l.object {
+		object[key] = value
+	}
+	object["metadata"] = metadata
+	object["spec"] = spec
+	for key, value := range map[string]string{"apiVersion": fields.ApiVersion, "kind": fields.Kind} {
+		object[key], _ = json.Marshal(value)
+	}
+	return json.Marshal(object)
+}
+
+/**
+returns the JSON object original with the given keys replaced by their values in fields. A key that fields
+leaves out (e.g. a nil pointer) is removed rather than kept from original
+*/
+func overlayObject(original json.RawMessage, fields interface{}, keys []string) (json.RawMessage, error) {
+	object := make(map[string]json.RawMessage)
+	if len(original) > 0 {
+		if err := json.Unmarshal(original, &object); err != nil {
+			return nil, err
+		}
+	}
+	content, marshalErr := json.Marshal(fields)
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+	var updated map[string]json.RawMessage
+	if err := json.Unmarshal(content, &updated); err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if value, present := updated[key]; present {
+			object[key] = value
+		} else {
+			delete(object, key)
+		}
+	}
+	return json.Marshal(object)
+}
+
+/**
+LeaseClient is the subset of the Kubernetes Lease API that KubeLeaseLock needs. It is modelled on
+client-go's LeaseInterface, so that a fake can be dropped in for tests. Update replaces the whole object,
+so it should be given a Lease that was read with Get
+*/
+type LeaseClient interface {
+	Get(ctx context.Context, name string) (*Lease, error)      //returns ErrLeaseNotFound if there is no such lease
+	Create(ctx context.Context, lease *Lease) (*Lease, error) //returns ErrLeaseConflict if the lease already exists
+	Update(ctx context.Context, lease *Lease) (*Lease, error) //returns ErrLeaseConflict if the resourceVersion is stale
+}
+
+/**
+KubeLeaseLock keeps the lease in a Kubernetes Lease object. Updates use the Lease's resourceVersion,
+so if two replicas race for the lock only one of them wins
+*/
+type KubeLeaseLock struct {
+	Client LeaseClient
+	Name   string
+}
+
+func (l *KubeLeaseLock) Describe() string {
+	return "kubernetes lease " + l.Name
+}
+
+func int32Ptr(v int32) *int32 {
+	return &v
+}
+
+func stringPtr(v string) *string {
+	return &v
+}
+
+/**
+returns true if the lease is held by someone other than identity and has not yet expired
+*/
+func heldByOther(lease *Lease, identity string, now time.Time) bool {
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" || *lease.Spec.HolderIdentity == identity {
+		return false
+	}
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return now.Before(expiry)
+}
+
+func (l *KubeLeaseLock) TryAcquireOrRenew(ctx context.Context, identity string, leaseDuration time.Duration, now time.Time) (bool, error) {
+	durationSeconds := int32(leaseDuration / time.Second)
+	lease, getErr := l.Client.Get(ctx, l.Name)
+	if getErr == ErrLeaseNotFound {
+		_, createErr := l.Client.Create(ctx, &Lease{
+			ApiVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   LeaseMetadata{Name: l.Name},
+			Spec: LeaseSpec{
+				HolderIdentity:       stringPtr(identity),
+				LeaseDurationSeconds: int32Ptr(durationSeconds),
+				AcquireTime:          &MicroTime{now},
+				RenewTime:            &MicroTime{now},
+				LeaseTransitions:     int32Ptr(0),
+			},
+		})
+		if createErr == ErrLeaseConflict {
+			return false, nil //another replica created it first
+		}
+		return createErr == nil, createErr
+	} else if getErr != nil {
+		return false, getErr
+	}
+
+	if heldByOther(lease, identity, now) {
+		return false, nil
+	}
+
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != identity {
+		transitions := int32(0)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions = *lease.Spec.LeaseTransitions + 1
+		}
+		lease.Spec.HolderIdentity = stringPtr(identity)
+		lease.Spec.AcquireTime = &MicroTime{now}
+		lease.Spec.LeaseTransitions = int32Ptr(transitions)
+	}
+	lease.Spec.RenewTime = &MicroTime{now}
+	lease.Spec.LeaseDurationSeconds = int32Ptr(durationSeconds)
+
+	_, updateErr := l.Client.Update(ctx, lease)
+	if updateErr == ErrLeaseConflict {
+		return false, nil //someone else got there first, we'll see who on the next attempt
+	}
+	return updateErr == nil, updateErr
+}
+
+func (l *KubeLeaseLock) Release(ctx context.Context, identity string) error {
+	lease, getErr := l.Client.Get(ctx, l.Name)
+	if getErr == ErrLeaseNotFound {
+		return nil
+	} else if getErr != nil {
+		return getErr
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != identity {
+		return nil
+	}
+
+	lease.Spec.HolderIdentity = stringPtr("")
+	lease.Spec.RenewTime = nil
+	_, updateErr := l.Client.Update(ctx, lease)
+	if updateErr == ErrLeaseConflict {
+		return nil
+	}
+	return updateErr
+}
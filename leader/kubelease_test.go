@@ -0,0 +1,199 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+fakeLeaseClient stores leases in memory as JSON and enforces resourceVersion the way the API server does.
+Keeping the JSON means that anything a Lease loses between Get and Update is lost from the stored object too
+*/
+type fakeLeaseClient struct {
+	mutex   sync.Mutex
+	leases  map[string][]byte
+	version int
+}
+
+func newFakeLeaseClient() *fakeLeaseClient {
+	return &fakeLeaseClient{leases: make(map[string][]byte)}
+}
+
+/**
+stores lease as the next version and returns what a client would read back
+*/
+func (f *fakeLeaseClient) store(lease *Lease) (*Lease, error) {
+	f.version += 1
+	stored := *lease
+	stored.Metadata.ResourceVersion = strconv.Itoa(f.version)
+	content, marshalErr := json.Marshal(stored)
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+	f.leases[lease.Metadata.Name] = content
+	var result Lease
+	return &result, json.Unmarshal(content, &result)
+}
+
+func (f *fakeLeaseClient) Get(ctx context.Context, name string) (*Lease, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	content, exists := f.leases[name]
+	if !exists {
+		return nil, ErrLeaseNotFound
+	}
+	var lease Lease
+	return &lease, json.Unmarshal(content, &lease)
+}
+
+func (f *fakeLeaseClient) Create(ctx context.Context, lease *Lease) (*Lease, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, exists := f.leases[lease.Metadata.Name]; exists {
+		return nil, ErrLeaseConflict
+	}
+	return f.store(lease)
+}
+
+func (f *fakeLeaseClient) Update(ctx context.Context, lease *Lease) (*Lease, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	content, exists := f.leases[lease.Metadata.Name]
+	if !exists {
+		return nil, ErrLeaseNotFound
+	}
+	var existing Lease
+	if err := json.Unmarshal(content, &existing); err != nil {
+		return nil, err
+	}
+	if existing.Metadata.ResourceVersion != lease.Metadata.ResourceVersion {
+		return nil, ErrLeaseConflict
+	}
+	return f.store(lease)
+}
+
+func TestKubeLeaseLock_TryAcquireOrRenew(t *testing.T) {
+	client := newFakeLeaseClient()
+	lock := &KubeLeaseLock{Client: client, Name: "vidispine-monitor"}
+	now, _ := time.Parse(time.RFC3339, "2021-03-11T10:00:00Z")
+	ctx := context.Background()
+
+	acquired, err := lock.TryAcquireOrRenew(ctx, "replica-a", 30*time.Second, now)
+	if err != nil || !acquired {
+		t.Errorf("replica-a should have created and acquired the lease, got %v %v", acquired, err)
+	}
+
+	acquired, err = lock.TryAcquireOrRenew(ctx, "replica-b", 30*time.Second, now.Add(10*time.Second))
+	if err != nil || acquired {
+		t.Errorf("replica-b should not acquire a lease that is held, got %v %v", acquired, err)
+	}
+
+	acquired, err = lock.TryAcquireOrRenew(ctx, "replica-a", 30*time.Second, now.Add(20*time.Second))
+	if err != nil || !acquired {
+		t.Errorf("replica-a should have renewed its lease, got %v %v", acquired, err)
+	}
+
+	//replica-a stops renewing, so its lease runs out 30s after the last renewal
+	acquired, _ = lock.TryAcquireOrRenew(ctx, "replica-b", 30*time.Second, now.Add(49*time.Second))
+	if acquired {
+		t.Error("replica-b acquired the lease before it expired")
+	}
+	acquired, err = lock.TryAcquireOrRenew(ctx, "replica-b", 30*time.Second, now.Add(51*time.Second))
+	if err != nil || !acquired {
+		t.Errorf("replica-b should have taken over the expired lease, got %v %v", acquired, err)
+	}
+
+	lease, _ := client.Get(ctx, "vidispine-monitor")
+	if *lease.Spec.HolderIdentity != "replica-b" {
+		t.Errorf("expected holder replica-b, got %s", *lease.Spec.HolderIdentity)
+	}
+	if *lease.Spec.LeaseTransitions != 1 {
+		t.Errorf("expected 1 lease transition, got %d", *lease.Spec.LeaseTransitions)
+	}
+
+	releaseErr := lock.Release(ctx, "replica-b")
+	if releaseErr != nil {
+		t.Error("Release returned an unexpected error: ", releaseErr)
+	}
+	acquired, err = lock.TryAcquireOrRenew(ctx, "replica-a", 30*time.Second, now.Add(52*time.Second))
+	if err != nil || !acquired {
+		t.Errorf("replica-a should acquire a released lease straight away, got %v %v", acquired, err)
+	}
+}
+
+func TestKubeLeaseLock_conflict(t *testing.T) {
+	client := newFakeLeaseClient()
+	lock := &KubeLeaseLock{Client: client, Name: "vidispine-monitor"}
+	now := time.Now()
+	ctx := context.Background()
+	lock.TryAcquireOrRenew(ctx, "replica-a", 30*time.Second, now.Add(-time.Minute))
+
+	//both replicas read the expired lease, but only the first update may succeed
+	staleCopy, _ := client.Get(ctx, "vidispine-monitor")
+	acquired, _ := lock.TryAcquireOrRenew(ctx, "replica-b", 30*time.Second, now)
+	if !acquired {
+		t.Error("replica-b should have taken the expired lease")
+	}
+	holder := "replica-c"
+	staleCopy.Spec.HolderIdentity = &holder
+	_, updateErr := client.Update(ctx, staleCopy)
+	if updateErr != ErrLeaseConflict {
+		t.Errorf("expected a conflict updating from a stale copy, got %v", updateErr)
+	}
+}
+
+func TestKubeLeaseLock_keepsOtherFields(t *testing.T) {
+	client := newFakeLeaseClient()
+	client.leases["vidispine-monitor"] = []byte(`{
+		"apiVersion": "coordination.k8s.io/v1",
+		"kind": "Lease",
+		"metadata": {
+			"name": "vidispine-monitor",
+			"namespace": "monitoring",
+			"resourceVersion": "7",
+			"labels": {"app": "vidispine-monitor"},
+			"annotations": {"owner": "media-ops"},
+			"ownerReferences": [{"apiVersion": "apps/v1", "kind": "Deployment", "name": "vidispine-monitor", "uid": "1234"}]
+		},
+		"spec": {"holderIdentity": "replica-a", "leaseDurationSeconds": 30, "renewTime": "2021-03-11T09:00:00.000000Z"}
+	}`)
+	lock := &KubeLeaseLock{Client: client, Name: "vidispine-monitor"}
+	now, _ := time.Parse(time.RFC3339, "2021-03-11T10:00:00Z")
+	ctx := context.Background()
+
+	acquired, err := lock.TryAcquireOrRenew(ctx, "replica-b", 30*time.Second, now)
+	if err != nil || !acquired {
+		t.Fatalf("replica-b should have taken over the expired lease, got %v %v", acquired, err)
+	}
+	releaseErr := lock.Release(ctx, "replica-b")
+	if releaseErr != nil {
+		t.Error("Release returned an unexpected error: ", releaseErr)
+	}
+
+	var stored struct {
+		Metadata map[string]interface{} `json:"metadata"`
+		Spec     map[string]interface{} `json:"spec"`
+	}
+	if err := json.Unmarshal(client.leases["vidispine-monitor"], &stored); err != nil {
+		t.Fatal("could not read back the stored lease: ", err)
+	}
+	for _, key := range []string{"namespace", "labels", "annotations", "ownerReferences"} {
+		if stored.Metadata[key] == nil {
+			t.Errorf("metadata.%s was lost when the lease was updated", key)
+		}
+	}
+	if !reflect.DeepEqual(stored.Metadata["labels"], map[string]interface{}{"app": "vidispine-monitor"}) {
+		t.Errorf("unexpected labels after update: %v", stored.Metadata["labels"])
+	}
+	if stored.Spec["holderIdentity"] != "" {
+		t.Errorf("expected the released lease to have no holder, got %v", stored.Spec["holderIdentity"])
+	}
+	if _, present := stored.Spec["renewTime"]; present {
+		t.Error("expected the released lease to have no renewTime")
+	}
+}
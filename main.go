@@ -1,141 +1,183 @@
 package main
 
 import (
-	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"context"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/config"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/leader"
 	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
-	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vshealthcheck"
-	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vsmetriccheck"
-	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vsstoragecheck"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/silence"
 	"log"
+	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
-func main() {
-	checkEveryStr := os.Getenv("CHECK_EVERY")                        //interval to check, parsed as a duration
-	pdService := os.Getenv("PD_INTEGRATION_KEY")                     //pagerduty service ID to alert
-	pdApiKey := os.Getenv("PD_API_KEY")                              //API key to communicate with PD
-	vidispineHost := os.Getenv("VIDISPINE_HOST")                     //hostname to query
-	vidispineMonitorHttpsStr := os.Getenv("VIDISPINE_MONITOR_HTTPS") //set to TRUE if the 9001 monitoring port is https protected
-	vidispineApiHttpsStr := os.Getenv("VIDISPINE_API_HTTPS")         //set to TRUE if the 8080 API port is https protected
-	vidispineApiUser := os.Getenv("VIDISPINE_API_USER")              //API user for checking storages
-	vidispineApiPasswd := os.Getenv("VIDISPINE_API_PASSWD")          //API password for checking storages
-	verboseStr := os.Getenv("VERBOSE")                               //whether to output verbose logging
-	sendTestMessageStr := os.Getenv("TEST_MESSAGE")                  //if set, then send a test message to PD
-
-	if vidispineHost == "" {
-		log.Fatal("You must specify VIDISPINE_HOST in the environment. Note that this is the hostname not the url.")
+/**
+prints the exact JSON body that would have been sent to pagerduty for the given alert, along with
+its dedup key and whether it would actually have been delivered
+*/
+func printDryRunAlert(checkName string, alert *pagerduty.TriggerEvent, pdService string) {
+	routing := "pagerduty"
+	if pdService == "" {
+		routing = "dropped, PD_INTEGRATION_KEY not set"
 	}
 
-	if checkEveryStr == "" {
-		log.Fatal("You must specify CHECK_EVERY in the environment, e.g. CHECK_EVERY=5minutes")
-	}
-	checkEvery, durParseErr := time.ParseDuration(checkEveryStr)
-	if durParseErr != nil {
-		log.Fatalf("CHECK_EVERY value %s is not a valid duration: %s", checkEveryStr, durParseErr)
+	body, marshalErr := pagerduty.EventBody(alert)
+	if marshalErr != nil {
+		log.Printf("ERROR [%s] could not marshal alert %s: %s", checkName, alert, marshalErr)
+		return
 	}
+	log.Printf("DRYRUN [%s] dedup_key=%s routing=%s body=%s", checkName, alert.DeDupKey, routing, string(body))
+}
 
-	if pdService == "" {
-		log.Print("WARNING PD_INTEGRATION_KEY and/or PD_API_KEY is not set, no alerts can be raised to pagerduty")
+/**
+sends the alert to pagerduty, unless it is silenced, we are not the leader or we are in dry-run mode.
+Returns an error only if pagerduty could not be reached, which has already been logged
+*/
+func deliverAlert(checkName string, alert *pagerduty.TriggerEvent, cfg *config.Config, silences *silence.Store, elector *leader.Elector) error {
+	if matched := silences.Match(checkName, alert, time.Now()); matched != nil {
+		log.Printf("INFO [%s] alert %s silenced by %s until %s (%s)", checkName, alert.DeDupKey,
+			matched.Id, matched.EndsAt.Format(time.RFC3339), matched.Comment)
+	} else if elector != nil && !elector.IsLeader() {
+		log.Printf("INFO [%s] not the leader, leaving alert %s to the leader", checkName, alert.DeDupKey)
+	} else if cfg.DryRun {
+		printDryRunAlert(checkName, alert, alert.IntegrationKey)
+	} else if alert.IntegrationKey == "" {
+		log.Printf("WARNING [%s] can't send to pagerduty as PD_INTEGRATION_KEY not set", checkName)
+	} else {
+		sendErr := pagerduty.SendEvent(alert, cfg.Notifiers.PagerDuty.ApiKey.String(), 60*time.Second)
+		if sendErr != nil {
+			log.Printf("ERROR Could not sent alert %s: %s", alert, sendErr)
+		}
+		return sendErr
 	}
+	return nil
+}
 
-	verboseMode := false
-	if verboseStr != "" {
-		var boolParseErr error
-		verboseMode, boolParseErr = strconv.ParseBool(verboseStr)
-		if boolParseErr != nil {
-			log.Fatalf("The value %s for VERBOSE is not valid, expected 'true' or 'false'", verboseStr)
+/**
+logs warnings about settings in the configuration that will stop alerts being delivered
+*/
+func warnAboutConfig(cfg *config.Config) {
+	for _, t := range cfg.MonitoredTargets() {
+		if cfg.RoutingKey(t) == "" {
+			log.Printf("WARNING PD_INTEGRATION_KEY and/or PD_API_KEY is not set, no alerts for %s can be raised to pagerduty", describeTarget(t.Name))
 		}
 	}
+	if cfg.DryRun {
+		log.Print("INFO DRY_RUN is set, alerts will be printed and not sent to pagerduty")
+	}
+}
 
-	vidispineMonitorHttps := false
-	if vidispineMonitorHttpsStr != "" {
-		var boolParseErr error
-		vidispineMonitorHttps, boolParseErr = strconv.ParseBool(vidispineMonitorHttpsStr)
-		if boolParseErr != nil {
-			log.Fatalf("The value %s for VIDISPINE_MONITOR_HTTPS was not valid, expected 'true' or 'false'", vidispineMonitorHttpsStr)
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "silence":
+			os.Exit(runSilenceCommand(os.Args[2:]))
+		case "validate-config":
+			os.Exit(runValidateConfigCommand(os.Args[2:]))
+		case "print-config":
+			os.Exit(runPrintConfigCommand(os.Args[2:]))
 		}
 	}
 
-	vidispineApiHttps := false
-	if vidispineApiHttpsStr != "" {
-		var boolParseErr error
-		vidispineApiHttps, boolParseErr = strconv.ParseBool(vidispineApiHttpsStr)
-		if boolParseErr != nil {
-			log.Fatalf("The value %s for VIDISPINE_API_HTTPS was not valid, expected 'true' or 'false'", boolParseErr)
+	configFile := os.Getenv("CONFIG_FILE")          //YAML config file, values in the environment override it
+	sendTestMessageStr := os.Getenv("TEST_MESSAGE") //if set, then send a test message to PD
+	silencesFile := os.Getenv("SILENCES_FILE")      //JSON file that silences are kept in
+	silenceApiAddr := os.Getenv("SILENCE_API_ADDR") //if set, serve the silence API on this address, e.g. localhost:9002
+
+	cfg, cfgErr := config.Load(configFile)
+	if cfgErr != nil {
+		log.Fatal(cfgErr)
+	}
+	warnAboutConfig(cfg)
+
+	silences := silence.NewStore()
+	if silencesFile != "" {
+		var openErr error
+		silences, openErr = silence.OpenFile(silencesFile)
+		if openErr != nil {
+			log.Fatalf("Could not load silences from SILENCES_FILE %s: %s", silencesFile, openErr)
 		}
+		log.Printf("INFO loaded %d silences from %s", len(silences.List()), silencesFile)
+	}
+	silences.SetConfigured(cfg.Silences)
+	if silenceApiAddr != "" {
+		go func() {
+			log.Printf("INFO serving silence API on %s", silenceApiAddr)
+			serveErr := http.ListenAndServe(silenceApiAddr, silence.NewHandler(silences))
+			log.Fatalf("Silence API on %s failed: %s", silenceApiAddr, serveErr)
+		}()
 	}
 
-	healthChecks := []common.MonitorComponent{
-		vshealthcheck.VSHealthCheckMonitor{
-			VidispineHost:  vidispineHost,
-			VidispineHttps: vidispineMonitorHttps,
-			PDServiceId:    pdService,
-		},
-		vsmetriccheck.VSMetricCheck{
-			VidispineHost:   vidispineHost,
-			VidispineHttps:  vidispineMonitorHttps,
-			VidispineDbName: "vidispinedb",
-			IntegrationKey:  pdService,
-		},
+	elector := setupLeaderElection()
+	stopElection := func() {}
+	if elector != nil {
+		stopElection = startLeaderElection(elector)
 	}
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		log.Printf("INFO received %s, shutting down", sig)
+		stopElection() //hand over leadership straight away rather than waiting for the lease to run out
+		os.Exit(0)
+	}()
 
-	if vidispineApiUser != "" && vidispineApiPasswd != "" {
-		healthChecks = append(healthChecks, vsstoragecheck.VSStorageCheck{
-			VidispineHost:    vidispineHost,
-			VidispineUser:    vidispineApiUser,
-			VidispinePasswd:  vidispineApiPasswd,
-			PDIntegrationKey: pdService,
-			VidispineHttps:   vidispineApiHttps,
-		})
-	} else {
-		log.Print("WARNING No vidispine api user and/or password was specified, can't do storage detail checks")
+	watcher := config.NewWatcher(configFile)
+	watcher.WatchSecrets(cfg)
+	go watcher.Run(context.Background())
+
+	targets, buildErr := buildTargets(cfg, nil)
+	if buildErr != nil {
+		log.Fatal(buildErr)
 	}
 
 	if sendTestMessageStr != "" {
+		//the test message goes the same way as any other alert, so only the leader sends it
 		nowtime := time.Now()
 		testMessage := pagerduty.NewTriggerEvent("vidispine-monitor",
-			pdService,
+			cfg.Notifiers.PagerDuty.IntegrationKey.String(),
 			pagerduty.SeverityInfo,
 			"test-message",
 			"Test message from vidispine-monitor",
 			&nowtime)
-		sendErr := pagerduty.SendEvent(testMessage, pdApiKey, 60*time.Second)
-		if sendErr == nil {
-			log.Print("INFO test message sent succesfully")
-		} else {
+		if sendErr := deliverAlert("test-message", testMessage, cfg, silences, elector); sendErr != nil {
 			log.Fatal("ERROR could not send test message: ", sendErr)
 		}
 	}
 
 	for {
-		didFail := false
-		for _, check := range healthChecks {
-			alerts, runErr := check.Run(verboseMode)
-			if runErr != nil {
-				didFail = true
-				log.Printf("ERROR running '%s' failed: %s", check.Name(), runErr)
-			}
-			if alerts != nil && len(alerts) > 0 {
-				log.Printf("WARNING %s returned %d alerts: ", check.Name(), len(alerts))
-				for _, alert := range alerts {
-					log.Printf("WARNING [%s] %s", check.Name(), alert.String())
-					if pdService == "" {
-						log.Print("WARNING can't send to pagerduty as PD_INTEGRATION_KEY not set")
-					} else {
-						sendErr := pagerduty.SendEvent(alert, pdApiKey, 60*time.Second)
-						if sendErr != nil {
-							log.Printf("ERROR Could not sent alert %s: %s", alert, sendErr)
-						}
-					}
-				}
-			}
+		if refreshed, refreshErr := silences.Refresh(); refreshErr != nil && !os.IsNotExist(refreshErr) {
+			log.Printf("ERROR could not re-read silences from %s, keeping the ones already loaded: %s", silencesFile, refreshErr)
+		} else if refreshed {
+			log.Printf("INFO re-read %d silences from %s", len(silences.List()), silencesFile)
+		}
+		silences.Expire(time.Now())
+		raised, failed := runTargets(targets, cfg.Verbose)
+		for _, r := range raised {
+			deliverAlert(r.CheckName, r.Event, cfg, silences, elector)
 		}
-		if didFail {
-			log.Print("ERROR Some internal errors occurred while processing the warnings, terminating")
-			os.Exit(1)
+		if len(failed) > 0 {
+			//each check that could not run has raised an alert saying why, so carry on monitoring the rest
+			log.Printf("WARNING some checks could not run for %s, they will be tried again next round", strings.Join(failed, ", "))
+		}
+
+		//a new configuration is only applied between rounds of checks, so every alert raised under the old one is delivered first
+		select {
+		case newCfg := <-watcher.Reloaded:
+			if newTargets, buildErr := buildTargets(newCfg, targets); buildErr != nil {
+				log.Printf("ERROR new configuration is not valid, keeping the existing configuration: %s", buildErr)
+			} else {
+				log.Print("INFO applying new configuration")
+				cfg = newCfg
+				warnAboutConfig(cfg)
+				silences.SetConfigured(cfg.Silences)
+				targets = swapTargets(targets, newTargets)
+			}
+		case <-time.After(roundInterval(cfg, targets)):
 		}
-		time.Sleep(checkEvery)
 	}
 }
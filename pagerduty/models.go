@@ -62,6 +62,31 @@ func (e *TriggerEvent) String() string {
 	return e.Payload.Summary
 }
 
+/**
+namespaces the event for one of several monitored Vidispine installations, so that the same alert raised
+against two of them has different dedup keys. An empty namespace leaves the event as it is
+*/
+func (e *TriggerEvent) Namespace(namespace string) {
+	if namespace == "" {
+		return
+	}
+	e.DeDupKey = namespace + "/" + e.DeDupKey
+	e.Payload.Source = e.Payload.Source + "/" + namespace
+	e.Payload.Summary = "[" + namespace + "] " + e.Payload.Summary
+}
+
+// separates a dedup key from the cluster node it was raised for, e.g. vidispine-heap@vs-node-1
+const NodeSeparator = "@"
+
+/**
+marks the event as being about one node of a Vidispine cluster, so that the same alert raised on two nodes
+has different dedup keys and responders can see which node is unhealthy
+*/
+func (e *TriggerEvent) ForNode(node string) {
+	e.DeDupKey = e.DeDupKey + NodeSeparator + node
+	e.Payload.Summary = "On node " + node + ": " + e.Payload.Summary
+}
+
 //https://developer.pagerduty.com/api-reference/reference/REST/openapiv3.json/paths/~1incidents/post
 
 type ObjectRefRequest struct {
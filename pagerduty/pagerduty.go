@@ -13,11 +13,18 @@ import (
 	"time"
 )
 
+/**
+marshals a TriggerEvent into the exact JSON body that SendEvent would post to PagerDuty
+*/
+func EventBody(req *TriggerEvent) ([]byte, error) {
+	return json.Marshal(req)
+}
+
 /**
 marshals a CreateIncidentRequest into a JSON request body and returns a ByteReader to it
 */
 func generateEventBody(req *TriggerEvent) (io.Reader, error) {
-	bodyContent, marshalErr := json.Marshal(req)
+	bodyContent, marshalErr := EventBody(req)
 	if marshalErr != nil {
 		return nil, marshalErr
 	}
@@ -0,0 +1,61 @@
+package pagerduty
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+/**
+EventBody must produce the same JSON that is posted to the events API
+*/
+func TestEventBody(t *testing.T) {
+	faketime, _ := time.Parse(time.RFC3339, "2010-01-02T03:04:05Z")
+	evt := NewTriggerEvent("vidispine-heap", "somekey", SeverityWarning, "vidispine-heap", "heap is full", &faketime)
+
+	body, err := EventBody(evt)
+	if err != nil {
+		t.Error("EventBody returned an unexpected error: ", err)
+		t.FailNow()
+	}
+
+	var parsed map[string]interface{}
+	unmarshalErr := json.Unmarshal(body, &parsed)
+	if unmarshalErr != nil {
+		t.Error("EventBody did not return valid json: ", unmarshalErr)
+		t.FailNow()
+	}
+	if parsed["routing_key"] != "somekey" {
+		t.Errorf("got incorrect routing_key '%v'", parsed["routing_key"])
+	}
+	if parsed["dedup_key"] != "vidispine-heap" {
+		t.Errorf("got incorrect dedup_key '%v'", parsed["dedup_key"])
+	}
+	if parsed["event_action"] != "trigger" {
+		t.Errorf("got incorrect event_action '%v'", parsed["event_action"])
+	}
+}
+
+/**
+Namespace must prefix the dedup key, source and summary, and leave the event alone for an empty namespace
+*/
+func TestTriggerEventNamespace(t *testing.T) {
+	faketime, _ := time.Parse(time.RFC3339, "2010-01-02T03:04:05Z")
+	evt := NewTriggerEvent("vidispine-heap", "somekey", SeverityWarning, "vidispine-heap", "heap is full", &faketime)
+
+	evt.Namespace("")
+	if evt.DeDupKey != "vidispine-heap" || evt.Payload.Source != "vidispine" || evt.Payload.Summary != "heap is full" {
+		t.Errorf("empty namespace changed the event: %v", evt)
+	}
+
+	evt.Namespace("staging")
+	if evt.DeDupKey != "staging/vidispine-heap" {
+		t.Errorf("got incorrect dedup key '%s'", evt.DeDupKey)
+	}
+	if evt.Payload.Source != "vidispine/staging" {
+		t.Errorf("got incorrect source '%s'", evt.Payload.Source)
+	}
+	if evt.Payload.Summary != "[staging] heap is full" {
+		t.Errorf("got incorrect summary '%s'", evt.Payload.Summary)
+	}
+}
@@ -0,0 +1,85 @@
+package silence
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+/**
+Client talks to the silence API of a running monitor, for the command-line interface
+*/
+type Client struct {
+	BaseUrl string //e.g. http://localhost:9002
+	Timeout time.Duration
+}
+
+func (c Client) do(method string, path string, body interface{}, expectStatus int, result interface{}) error {
+	httpClient := http.Client{}
+	ctx, cancelFunc := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancelFunc()
+
+	var bodyReader *bytes.Reader
+	if body != nil {
+		bodyContent, marshalErr := json.Marshal(body)
+		if marshalErr != nil {
+			return marshalErr
+		}
+		bodyReader = bytes.NewReader(bodyContent)
+	} else {
+		bodyReader = bytes.NewReader(nil)
+	}
+
+	httpReq, reqErr := http.NewRequestWithContext(ctx, method, c.BaseUrl+path, bodyReader)
+	if reqErr != nil {
+		return reqErr
+	}
+	httpReq.Header.Add("Content-Type", "application/json")
+
+	response, httpErr := httpClient.Do(httpReq)
+	if httpErr != nil {
+		return httpErr
+	}
+	defer response.Body.Close()
+
+	content, readErr := ioutil.ReadAll(response.Body)
+	if readErr != nil {
+		return readErr
+	}
+
+	if response.StatusCode != expectStatus {
+		var errResponse errorResponse
+		if json.Unmarshal(content, &errResponse) == nil && errResponse.Error != "" {
+			return fmt.Errorf("server returned %d: %s", response.StatusCode, errResponse.Error)
+		}
+		return fmt.Errorf("server returned %d: %s", response.StatusCode, string(content))
+	}
+
+	if result != nil {
+		return json.Unmarshal(content, result)
+	}
+	return nil
+}
+
+func (c Client) List() ([]Silence, error) {
+	var result []Silence
+	err := c.do("GET", "/silences", nil, http.StatusOK, &result)
+	return result, err
+}
+
+func (c Client) Add(s *Silence) (*Silence, error) {
+	var result Silence
+	err := c.do("POST", "/silences", s, http.StatusCreated, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c Client) Remove(id string) error {
+	return c.do("DELETE", "/silences/"+id, nil, http.StatusNoContent, nil)
+}
@@ -0,0 +1,82 @@
+package silence
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+func writeJson(w http.ResponseWriter, status int, content interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encodeErr := json.NewEncoder(w).Encode(content)
+	if encodeErr != nil {
+		log.Print("ERROR silence.writeJson could not write response: ", encodeErr)
+	}
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+/**
+returns an http.Handler serving the silence API:
+
+	GET /silences          lists silences
+	POST /silences         creates a silence from the JSON body and returns it
+	DELETE /silences/{id}  removes a silence
+*/
+func NewHandler(store *Store) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/silences", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			writeJson(w, http.StatusOK, store.List())
+		case "POST":
+			var s Silence
+			decodeErr := json.NewDecoder(r.Body).Decode(&s)
+			if decodeErr != nil {
+				writeJson(w, http.StatusBadRequest, errorResponse{decodeErr.Error()})
+				return
+			}
+			s.SuppressedCount = 0
+			added, addErr := store.Add(&s)
+			if addErr == ErrDuplicateId {
+				writeJson(w, http.StatusConflict, errorResponse{addErr.Error()})
+				return
+			}
+			if _, notSaved := addErr.(SaveError); notSaved {
+				writeJson(w, http.StatusInternalServerError, errorResponse{addErr.Error()})
+				return
+			}
+			if addErr != nil {
+				writeJson(w, http.StatusBadRequest, errorResponse{addErr.Error()})
+				return
+			}
+			log.Printf("INFO added silence %s until %s: %s", added.Id, added.EndsAt, added.Comment)
+			writeJson(w, http.StatusCreated, added)
+		default:
+			writeJson(w, http.StatusMethodNotAllowed, errorResponse{"method not allowed"})
+		}
+	})
+	mux.HandleFunc("/silences/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			writeJson(w, http.StatusMethodNotAllowed, errorResponse{"method not allowed"})
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/silences/")
+		removeErr := store.Remove(id)
+		if removeErr == ErrReadOnly {
+			writeJson(w, http.StatusForbidden, errorResponse{removeErr.Error()})
+			return
+		}
+		if removeErr != nil {
+			writeJson(w, http.StatusNotFound, errorResponse{"no silence with id " + id})
+			return
+		}
+		log.Printf("INFO removed silence %s", id)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
@@ -0,0 +1,77 @@
+package silence
+
+import (
+	"errors"
+	"path"
+	"time"
+)
+
+/**
+a Silence stops matching alerts from being delivered between StartsAt and EndsAt.
+Each matcher is a glob as understood by path.Match; empty matchers match anything, but at least
+one must be set
+*/
+type Silence struct {
+	Id              string    `json:"id" yaml:"id"`
+	DedupKey        string    `json:"dedupKey" yaml:"dedup_key"`  //glob matched against the alert dedup key
+	Component       string    `json:"component" yaml:"component"` //glob matched against the alert component
+	Check           string    `json:"check" yaml:"check"`         //glob matched against the name of the check that raised the alert
+	StartsAt        time.Time `json:"startsAt" yaml:"starts_at"`
+	EndsAt          time.Time `json:"endsAt" yaml:"ends_at"`
+	Comment         string    `json:"comment" yaml:"comment"`
+	SuppressedCount int64     `json:"suppressedCount" yaml:"-"`    //number of alerts this silence has stopped
+	ReadOnly        bool      `json:"readOnly,omitempty" yaml:"-"` //true if the silence comes from the config file, so can't be removed through the API
+}
+
+/**
+checks that the silence is usable, returning an error describing the problem if not
+*/
+func (s *Silence) Validate() error {
+	if s.DedupKey == "" && s.Component == "" && s.Check == "" {
+		return errors.New("a silence needs at least one of dedupKey, component or check")
+	}
+	for _, pattern := range []string{s.DedupKey, s.Component, s.Check} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.New("invalid pattern '" + pattern + "': " + err.Error())
+		}
+	}
+	if s.EndsAt.IsZero() {
+		return errors.New("a silence needs an end time")
+	}
+	if !s.EndsAt.After(s.StartsAt) {
+		return errors.New("a silence must end after it starts")
+	}
+	if s.Comment == "" {
+		return errors.New("a silence needs a comment explaining why it exists")
+	}
+	return nil
+}
+
+/**
+returns true if the silence is in effect at the given time
+*/
+func (s *Silence) ActiveAt(t time.Time) bool {
+	return !t.Before(s.StartsAt) && t.Before(s.EndsAt)
+}
+
+/**
+returns true if the silence has ended at the given time
+*/
+func (s *Silence) ExpiredAt(t time.Time) bool {
+	return !t.Before(s.EndsAt)
+}
+
+func globMatches(pattern string, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, _ := path.Match(pattern, value) //patterns are checked in Validate so we can ignore the error
+	return matched
+}
+
+/**
+returns true if every matcher on the silence matches the given values. Does not consider start/end times
+*/
+func (s *Silence) Matches(checkName string, dedupKey string, component string) bool {
+	return globMatches(s.DedupKey, dedupKey) && globMatches(s.Component, component) && globMatches(s.Check, checkName)
+}
@@ -0,0 +1,84 @@
+package silence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSilence_Validate(t *testing.T) {
+	now := time.Now()
+	s := Silence{
+		DedupKey: "vidispine-storage*-VX-4",
+		StartsAt: now,
+		EndsAt:   now.Add(time.Hour),
+		Comment:  "evacuating VX-4",
+	}
+	if err := s.Validate(); err != nil {
+		t.Error("Validate rejected a valid silence: ", err)
+	}
+
+	noMatchers := s
+	noMatchers.DedupKey = ""
+	if noMatchers.Validate() == nil {
+		t.Error("Validate accepted a silence with no matchers")
+	}
+
+	badPattern := s
+	badPattern.Component = "Storage [VX"
+	if badPattern.Validate() == nil {
+		t.Error("Validate accepted a silence with an invalid glob")
+	}
+
+	backwards := s
+	backwards.EndsAt = now.Add(-time.Hour)
+	if backwards.Validate() == nil {
+		t.Error("Validate accepted a silence that ends before it starts")
+	}
+
+	noComment := s
+	noComment.Comment = ""
+	if noComment.Validate() == nil {
+		t.Error("Validate accepted a silence with no comment")
+	}
+}
+
+func TestSilence_Matches(t *testing.T) {
+	s := Silence{
+		DedupKey:  "vidispine-storage*-VX-4",
+		Component: "Storage *",
+	}
+	if !s.Matches("Vidispine storages check", "vidispine-storagestate-VX-4", "Storage VX-4") {
+		t.Error("silence did not match storage state alert for VX-4")
+	}
+	if s.Matches("Vidispine storages check", "vidispine-storagestate-VX-5", "Storage VX-5") {
+		t.Error("silence matched storage state alert for VX-5")
+	}
+	if s.Matches("Vidispine storages check", "vidispine-storagestate-VX-4", "vidispine-monitor") {
+		t.Error("silence matched an alert with the wrong component")
+	}
+
+	byCheck := Silence{Check: "Vidispine storages*"}
+	if !byCheck.Matches("Vidispine storages check", "anything", "anything") {
+		t.Error("silence did not match on check name")
+	}
+}
+
+func TestSilence_ActiveAt(t *testing.T) {
+	start, _ := time.Parse(time.RFC3339, "2021-03-11T10:00:00Z")
+	s := Silence{
+		StartsAt: start,
+		EndsAt:   start.Add(time.Hour),
+	}
+	if s.ActiveAt(start.Add(-time.Minute)) {
+		t.Error("silence was active before it started")
+	}
+	if !s.ActiveAt(start) || !s.ActiveAt(start.Add(59*time.Minute)) {
+		t.Error("silence was not active during its window")
+	}
+	if s.ActiveAt(start.Add(time.Hour)) {
+		t.Error("silence was still active at its end time")
+	}
+	if !s.ExpiredAt(start.Add(time.Hour)) {
+		t.Error("silence was not expired at its end time")
+	}
+}
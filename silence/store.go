@@ -0,0 +1,353 @@
+package silence
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+/**
+Store holds the current set of silences. It is safe for concurrent use, since it is shared between
+the check loop and the HTTP interface.
+A store opened with OpenFile saves every change back to its file, and Refresh picks up changes that other
+replicas made to it
+*/
+type Store struct {
+	mutex      sync.Mutex
+	silences   map[string]*Silence
+	configured map[string]*Silence //read-only silences from the config file, which are never saved
+	file       string
+	fileMod    time.Time //modification time and size of the file when it was last read or written
+	fileSize   int64
+}
+
+// returned by Add when there is already a silence with the given ID
+var ErrDuplicateId = errors.New("there is already a silence with this id")
+
+// returned by Remove when the silence comes from the config file
+var ErrReadOnly = errors.New("this silence is defined in the config file, remove it from there instead")
+
+// returned by Remove when there is no silence with the given ID
+var ErrNotFound = errors.New("there is no silence with this id")
+
+// returned when the silences could not be written back to the store's file
+type SaveError struct {
+	File string
+	Err  error
+}
+
+func (e SaveError) Error() string {
+	return fmt.Sprintf("could not save silences to %s: %s", e.File, e.Err)
+}
+
+func NewStore() *Store {
+	return &Store{
+		silences:   make(map[string]*Silence),
+		configured: make(map[string]*Silence),
+	}
+}
+
+/**
+returns a store that keeps its silences in the given JSON file, loading any that are already in it. The file
+does not have to exist yet, it is written when the first silence is added
+*/
+func OpenFile(filename string) (*Store, error) {
+	st := NewStore()
+	st.file = filename
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if _, refreshErr := st.refresh(); refreshErr != nil && !os.IsNotExist(refreshErr) {
+		return nil, refreshErr
+	}
+	return st, nil
+}
+
+func newSilenceId() (string, error) {
+	raw := make([]byte, 8)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+func readSilences(filename string) ([]*Silence, error) {
+	content, readErr := ioutil.ReadFile(filename)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	var silences []*Silence
+	if unmarshalErr := json.Unmarshal(content, &silences); unmarshalErr != nil {
+		return nil, fmt.Errorf("%s: %s", filename, unmarshalErr)
+	}
+	return silences, nil
+}
+
+/**
+re-reads the store's file if it has changed since it was last read or written, e.g. because a silence was added
+through another replica. Returns true if the silences were replaced
+*/
+func (st *Store) Refresh() (bool, error) {
+	if st.file == "" {
+		return false, nil
+	}
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	return st.refresh()
+}
+
+// the caller must hold the mutex
+func (st *Store) refresh() (bool, error) {
+	info, statErr := os.Stat(st.file)
+	if statErr != nil {
+		return false, statErr
+	}
+	if info.ModTime().Equal(st.fileMod) && info.Size() == st.fileSize {
+		return false, nil
+	}
+	silences, readErr := readSilences(st.file)
+	if readErr != nil {
+		return false, readErr
+	}
+
+	replaced := make(map[string]*Silence, len(silences))
+	needIds := false
+	for i, s := range silences {
+		if validErr := s.Validate(); validErr != nil {
+			return false, fmt.Errorf("silence %d in %s: %s", i, st.file, validErr)
+		}
+		if s.Id == "" {
+			//written by hand, so give it an ID that the API can remove it by
+			var idErr error
+			if s.Id, idErr = newSilenceId(); idErr != nil {
+				return false, idErr
+			}
+			needIds = true
+		}
+		if _, duplicate := replaced[s.Id]; duplicate {
+			return false, fmt.Errorf("silence %d in %s: %s", i, st.file, ErrDuplicateId)
+		}
+		s.ReadOnly = false
+		//the file is only written when silences change, so the counts kept here are more up to date
+		if existing, haveExisting := st.silences[s.Id]; haveExisting && existing.SuppressedCount > s.SuppressedCount {
+			s.SuppressedCount = existing.SuppressedCount
+		}
+		replaced[s.Id] = s
+	}
+	st.silences = replaced
+	st.fileMod, st.fileSize = info.ModTime(), info.Size()
+	if needIds {
+		if saveErr := st.save(); saveErr != nil {
+			log.Print("ERROR ", saveErr)
+		}
+	}
+	return true, nil
+}
+
+/**
+writes the silences to the store's file, if it has one, via a temporary file so that a replica reading it never
+sees half of it. The caller must hold the mutex
+*/
+func (st *Store) save() error {
+	if st.file == "" {
+		return nil
+	}
+	content, marshalErr := json.MarshalIndent(st.sorted(st.silences), "", "  ")
+	if marshalErr != nil {
+		return SaveError{File: st.file, Err: marshalErr}
+	}
+	temp, tempErr := ioutil.TempFile(filepath.Dir(st.file), "."+filepath.Base(st.file)+"-")
+	if tempErr != nil {
+		return SaveError{File: st.file, Err: tempErr}
+	}
+	_, writeErr := temp.Write(content)
+	closeErr := temp.Close()
+	if writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr == nil {
+		writeErr = os.Rename(temp.Name(), st.file)
+	}
+	if writeErr != nil {
+		os.Remove(temp.Name())
+		return SaveError{File: st.file, Err: writeErr}
+	}
+	if info, statErr := os.Stat(st.file); statErr == nil {
+		st.fileMod, st.fileSize = info.ModTime(), info.Size()
+	}
+	return nil
+}
+
+/**
+validates the given silence and adds it to the store, assigning it an ID if it does not have one.
+StartsAt defaults to the current time. Returns ErrDuplicateId if there is already a silence with its ID
+*/
+func (st *Store) Add(s *Silence) (*Silence, error) {
+	if s.StartsAt.IsZero() {
+		s.StartsAt = time.Now()
+	}
+	if validErr := s.Validate(); validErr != nil {
+		return nil, validErr
+	}
+	if s.Id == "" {
+		var idErr error
+		s.Id, idErr = newSilenceId()
+		if idErr != nil {
+			return nil, idErr
+		}
+	}
+
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if st.file != "" {
+		//pick up anything another replica added first, so that it is not overwritten
+		if _, refreshErr := st.refresh(); refreshErr != nil && !os.IsNotExist(refreshErr) {
+			return nil, refreshErr
+		}
+	}
+	if _, exists := st.silences[s.Id]; exists {
+		return nil, ErrDuplicateId
+	}
+	if _, exists := st.configured[s.Id]; exists {
+		return nil, ErrDuplicateId
+	}
+	s.ReadOnly = false
+	st.silences[s.Id] = s
+	if saveErr := st.save(); saveErr != nil {
+		delete(st.silences, s.Id)
+		return nil, saveErr
+	}
+	return s, nil
+}
+
+/**
+removes the silence with the given ID. Returns ErrNotFound if there was none, or ErrReadOnly if it comes from
+the config file
+*/
+func (st *Store) Remove(id string) error {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if st.file != "" {
+		if _, refreshErr := st.refresh(); refreshErr != nil && !os.IsNotExist(refreshErr) {
+			log.Printf("ERROR could not re-read silences from %s: %s", st.file, refreshErr)
+		}
+	}
+	if _, configured := st.configured[id]; configured {
+		return ErrReadOnly
+	}
+	if _, exists := st.silences[id]; !exists {
+		return ErrNotFound
+	}
+	delete(st.silences, id)
+	if saveErr := st.save(); saveErr != nil {
+		log.Print("ERROR ", saveErr)
+	}
+	return nil
+}
+
+/**
+replaces the read-only silences with the given ones from the config file, which must already be valid and have
+IDs. They are kept apart from the others, so are never saved to the store's file, and keep their suppressed
+counts across calls
+*/
+func (st *Store) SetConfigured(silences []Silence) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	replaced := make(map[string]*Silence, len(silences))
+	for _, s := range silences {
+		configured := s
+		configured.ReadOnly = true
+		if existing, haveExisting := st.configured[s.Id]; haveExisting {
+			configured.SuppressedCount = existing.SuppressedCount
+		}
+		replaced[s.Id] = &configured
+	}
+	st.configured = replaced
+}
+
+/**
+returns a copy of every silence in the store, including the read-only ones, ordered by start time
+*/
+func (st *Store) List() []Silence {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	all := st.sorted(st.silences, st.configured)
+	result := make([]Silence, 0, len(all))
+	for _, s := range all {
+		result = append(result, *s)
+	}
+	return result
+}
+
+/**
+returns the silences in the given sets ordered by start time, then by ID so that the order is always the same.
+The caller must hold the mutex
+*/
+func (st *Store) sorted(sets ...map[string]*Silence) []*Silence {
+	result := make([]*Silence, 0)
+	for _, set := range sets {
+		for _, s := range set {
+			result = append(result, s)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].StartsAt.Equal(result[j].StartsAt) {
+			return result[i].StartsAt.Before(result[j].StartsAt)
+		}
+		return result[i].Id < result[j].Id
+	})
+	return result
+}
+
+/**
+removes every silence that has ended at the given time
+*/
+func (st *Store) Expire(t time.Time) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	expired := false
+	for id, s := range st.silences {
+		if s.ExpiredAt(t) {
+			log.Printf("INFO silence %s (%s) ended at %s, removing", id, s.Comment, s.EndsAt.Format(time.RFC3339))
+			delete(st.silences, id)
+			expired = true
+		}
+	}
+	if expired {
+		if saveErr := st.save(); saveErr != nil {
+			log.Print("ERROR ", saveErr)
+		}
+	}
+}
+
+/**
+returns a copy of the first silence, in the order of List, that is active at the given time and matches the
+alert, or nil if the alert should be delivered. The matching silence's SuppressedCount is incremented
+*/
+func (st *Store) Match(checkName string, alert *pagerduty.TriggerEvent, t time.Time) *Silence {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	for _, s := range st.sorted(st.silences, st.configured) {
+		if s.ActiveAt(t) && s.Matches(checkName, alert.DeDupKey, alert.Payload.Component) {
+			s.SuppressedCount += 1
+			matched := *s
+			return &matched
+		}
+	}
+	return nil
+}
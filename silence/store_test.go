@@ -0,0 +1,248 @@
+package silence
+
+import (
+	"bytes"
+	"encoding/json"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore_MatchAndExpire(t *testing.T) {
+	now := time.Now()
+	st := NewStore()
+	added, err := st.Add(&Silence{
+		DedupKey: "vidispine-heap",
+		StartsAt: now,
+		EndsAt:   now.Add(time.Hour),
+		Comment:  "upgrade",
+	})
+	if err != nil {
+		t.Error("Add returned an unexpected error: ", err)
+		t.FailNow()
+	}
+	if added.Id == "" {
+		t.Error("Add did not assign an ID")
+	}
+
+	heapAlert := pagerduty.NewTriggerEvent("vidispine-heap", "key", pagerduty.SeverityWarning, "vidispine-heap", "heap", &now)
+	dbAlert := pagerduty.NewTriggerEvent("vidispine-database", "key", pagerduty.SeverityWarning, "vidispine-database-pool", "db", &now)
+
+	matched := st.Match("Connection pool and error response rate", heapAlert, now)
+	if matched == nil {
+		t.Error("heap alert was not silenced")
+	} else if matched.SuppressedCount != 1 {
+		t.Errorf("expected SuppressedCount 1 got %d", matched.SuppressedCount)
+	}
+	if st.Match("Connection pool and error response rate", dbAlert, now) != nil {
+		t.Error("database alert was silenced unexpectedly")
+	}
+
+	st.Expire(now.Add(30 * time.Minute))
+	if len(st.List()) != 1 {
+		t.Error("silence was removed before it ended")
+	}
+	st.Expire(now.Add(2 * time.Hour))
+	if len(st.List()) != 0 {
+		t.Error("expired silence was not removed")
+	}
+	if st.Match("Connection pool and error response rate", heapAlert, now.Add(2*time.Hour)) != nil {
+		t.Error("heap alert was silenced after expiry")
+	}
+}
+
+func TestNewHandler(t *testing.T) {
+	st := NewStore()
+	server := httptest.NewServer(NewHandler(st))
+	defer server.Close()
+	client := Client{BaseUrl: server.URL, Timeout: 5 * time.Second}
+
+	added, addErr := client.Add(&Silence{
+		Component: "Storage VX-4",
+		EndsAt:    time.Now().Add(time.Hour),
+		Comment:   "evacuating",
+	})
+	if addErr != nil {
+		t.Error("Add returned an unexpected error: ", addErr)
+		t.FailNow()
+	}
+
+	listed, listErr := client.List()
+	if listErr != nil {
+		t.Error("List returned an unexpected error: ", listErr)
+	} else if len(listed) != 1 || listed[0].Id != added.Id {
+		t.Errorf("List returned %v, expected the added silence", listed)
+	}
+
+	body, _ := json.Marshal(Silence{Comment: "no matchers", EndsAt: time.Now().Add(time.Hour)})
+	response, postErr := http.Post(server.URL+"/silences", "application/json", bytes.NewReader(body))
+	if postErr != nil {
+		t.Error("POST returned an unexpected error: ", postErr)
+		t.FailNow()
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid silence, got %d", response.StatusCode)
+	}
+
+	duplicate, _ := json.Marshal(Silence{Id: added.Id, Component: "other", Comment: "same id", EndsAt: time.Now().Add(time.Hour)})
+	conflict, conflictErr := http.Post(server.URL+"/silences", "application/json", bytes.NewReader(duplicate))
+	if conflictErr != nil {
+		t.Fatal(conflictErr)
+	}
+	conflict.Body.Close()
+	if conflict.StatusCode != http.StatusConflict {
+		t.Errorf("expected 409 for a duplicate id, got %d", conflict.StatusCode)
+	}
+
+	if removeErr := client.Remove(added.Id); removeErr != nil {
+		t.Error("Remove returned an unexpected error: ", removeErr)
+	}
+	if client.Remove(added.Id) == nil {
+		t.Error("Remove of a missing silence should fail")
+	}
+	if len(st.List()) != 0 {
+		t.Error("silence was not removed from the store")
+	}
+}
+
+func TestStore_MatchOrder(t *testing.T) {
+	now := time.Now()
+	st := NewStore()
+	for _, id := range []string{"c", "a", "b"} {
+		if _, err := st.Add(&Silence{Id: id, DedupKey: "vidispine-*", StartsAt: now, EndsAt: now.Add(time.Hour), Comment: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	alert := pagerduty.NewTriggerEvent("vidispine-heap", "key", pagerduty.SeverityWarning, "vidispine-heap", "heap", &now)
+	for i := 0; i < 5; i++ {
+		if matched := st.Match("metrics", alert, now); matched == nil || matched.Id != "a" {
+			t.Fatalf("expected silence a to match every time, got %v", matched)
+		}
+	}
+
+	if _, err := st.Add(&Silence{Id: "a", DedupKey: "other", EndsAt: now.Add(time.Hour), Comment: "again"}); err != ErrDuplicateId {
+		t.Errorf("expected a duplicate id to be rejected, got %v", err)
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	dir, dirErr := ioutil.TempDir("", "silences")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "silences.json")
+	now := time.Now()
+
+	first, openErr := OpenFile(filename)
+	if openErr != nil {
+		t.Fatal("a missing file should give an empty store, got ", openErr)
+	}
+	added, addErr := first.Add(&Silence{DedupKey: "vidispine-heap", EndsAt: now.Add(time.Hour), Comment: "upgrade"})
+	if addErr != nil {
+		t.Fatal(addErr)
+	}
+
+	//a restarted or second replica sees the silence
+	second, reopenErr := OpenFile(filename)
+	if reopenErr != nil {
+		t.Fatal(reopenErr)
+	}
+	if listed := second.List(); len(listed) != 1 || listed[0].Id != added.Id {
+		t.Fatalf("expected the saved silence to be loaded, got %v", listed)
+	}
+
+	other, _ := second.Add(&Silence{Component: "Storage VX-4", EndsAt: now.Add(time.Hour), Comment: "evacuating"})
+	//make sure the change is visible even on filesystems with coarse modification times
+	os.Chtimes(filename, now.Add(time.Minute), now.Add(time.Minute))
+	if refreshed, refreshErr := first.Refresh(); !refreshed || refreshErr != nil {
+		t.Fatalf("expected the other replica's silence to be picked up, got %v %v", refreshed, refreshErr)
+	}
+	if len(first.List()) != 2 {
+		t.Errorf("expected both silences after refreshing, got %v", first.List())
+	}
+
+	first.Remove(other.Id)
+	third, _ := OpenFile(filename)
+	if listed := third.List(); len(listed) != 1 || listed[0].Id != added.Id {
+		t.Errorf("expected the removal to be saved, got %v", listed)
+	}
+}
+
+func TestOpenFile_handWritten(t *testing.T) {
+	file, fileErr := ioutil.TempFile("", "silences")
+	if fileErr != nil {
+		t.Fatal(fileErr)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`[{"dedupKey": "vidispine-heap", "endsAt": "2099-01-01T00:00:00Z", "comment": "by hand"}]`)
+	file.Close()
+
+	st, openErr := OpenFile(file.Name())
+	if openErr != nil {
+		t.Fatal(openErr)
+	}
+	listed := st.List()
+	if len(listed) != 1 || listed[0].Id == "" {
+		t.Fatalf("expected the silence to be given an id, got %v", listed)
+	}
+	if removeErr := st.Remove(listed[0].Id); removeErr != nil {
+		t.Error("could not remove the silence by its new id")
+	}
+}
+
+func TestStore_SetConfigured(t *testing.T) {
+	dir, dirErr := ioutil.TempDir("", "silences")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "silences.json")
+	now := time.Now()
+
+	st, openErr := OpenFile(filename)
+	if openErr != nil {
+		t.Fatal(openErr)
+	}
+	st.SetConfigured([]Silence{{Id: "backups", Check: "Storage*", StartsAt: now.Add(-time.Hour), EndsAt: now.Add(time.Hour), Comment: "nightly backup"}})
+	if _, addErr := st.Add(&Silence{DedupKey: "vidispine-heap", EndsAt: now.Add(time.Hour), Comment: "upgrade"}); addErr != nil {
+		t.Fatal(addErr)
+	}
+	if _, addErr := st.Add(&Silence{Id: "backups", DedupKey: "vidispine-heap", EndsAt: now.Add(time.Hour), Comment: "upgrade"}); addErr != ErrDuplicateId {
+		t.Errorf("expected ErrDuplicateId adding a silence with a configured id, got %v", addErr)
+	}
+
+	alert := pagerduty.NewTriggerEvent("vidispine-storage", "key", pagerduty.SeverityWarning, "vidispine-storage-VX-1", "storage", &now)
+	matched := st.Match("Storage check", alert, now)
+	if matched == nil || matched.Id != "backups" || !matched.ReadOnly {
+		t.Fatalf("expected the configured silence to match, got %v", matched)
+	}
+	if removeErr := st.Remove("backups"); removeErr != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly removing a configured silence, got %v", removeErr)
+	}
+
+	saved, readErr := readSilences(filename)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if len(saved) != 1 || saved[0].DedupKey != "vidispine-heap" {
+		t.Errorf("expected only the added silence to be saved, got %v", saved)
+	}
+
+	//a reloaded config replaces the configured silences, keeping the counts of those that are still there
+	st.SetConfigured([]Silence{{Id: "backups", Check: "Storage*", StartsAt: now.Add(-time.Hour), EndsAt: now.Add(2 * time.Hour), Comment: "longer backup"}})
+	listed := st.List()
+	if len(listed) != 2 || listed[0].Id != "backups" || listed[0].SuppressedCount != 1 || !listed[0].EndsAt.Equal(now.Add(2*time.Hour)) {
+		t.Errorf("expected the replaced configured silence to keep its count, got %v", listed)
+	}
+	st.SetConfigured(nil)
+	if st.Match("Storage check", alert, now) != nil {
+		t.Error("a silence removed from the config still matched")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/silence"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+const defaultSilenceApiAddr = "localhost:9002"
+
+func silenceUsage() {
+	fmt.Fprintln(os.Stderr, `Usage: vidispine-monitor silence <command> [options]
+
+Commands:
+  list                 show current silences
+  add [options]        create a new silence, see 'vidispine-monitor silence add -h'
+  remove <id>          remove a silence
+
+The monitor must be running with SILENCE_API_ADDR set. The same variable (or -addr)
+tells these commands where to find it.`)
+}
+
+/**
+implements the 'silence' subcommand, which talks to the silence API of a running monitor.
+Returns the exit code for the process
+*/
+func runSilenceCommand(args []string) int {
+	if len(args) < 1 {
+		silenceUsage()
+		return 2
+	}
+
+	addr := os.Getenv("SILENCE_API_ADDR")
+	if addr == "" {
+		addr = defaultSilenceApiAddr
+	}
+
+	flags := flag.NewFlagSet("silence "+args[0], flag.ExitOnError)
+	addrFlag := flags.String("addr", addr, "host:port of the running monitor's silence API")
+
+	switch args[0] {
+	case "list":
+		flags.Parse(args[1:])
+		client := silence.Client{BaseUrl: "http://" + *addrFlag, Timeout: 10 * time.Second}
+		silences, err := client.List()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not list silences: ", err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tDEDUP KEY\tCOMPONENT\tCHECK\tSTARTS\tENDS\tSUPPRESSED\tSOURCE\tCOMMENT")
+		for _, s := range silences {
+			source := "api"
+			if s.ReadOnly {
+				source = "config"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", s.Id, s.DedupKey, s.Component, s.Check,
+				s.StartsAt.Format(time.RFC3339), s.EndsAt.Format(time.RFC3339), s.SuppressedCount, source, s.Comment)
+		}
+		w.Flush()
+		return 0
+	case "add":
+		dedupKey := flags.String("dedup-key", "", "glob to match against alert dedup keys, e.g. vidispine-storage*-VX-4")
+		component := flags.String("component", "", "glob to match against alert components")
+		check := flags.String("check", "", "glob to match against the name of the check raising the alert")
+		startStr := flags.String("start", "", "RFC3339 time the silence starts, defaults to now")
+		endStr := flags.String("end", "", "RFC3339 time the silence ends")
+		duration := flags.Duration("duration", 0, "how long the silence lasts, as an alternative to -end")
+		comment := flags.String("comment", "", "why the silence exists, required")
+		flags.Parse(args[1:])
+
+		s := &silence.Silence{
+			DedupKey:  *dedupKey,
+			Component: *component,
+			Check:     *check,
+			Comment:   *comment,
+			StartsAt:  time.Now(),
+		}
+		if *startStr != "" {
+			var parseErr error
+			s.StartsAt, parseErr = time.Parse(time.RFC3339, *startStr)
+			if parseErr != nil {
+				fmt.Fprintf(os.Stderr, "-start value %s is not a valid RFC3339 time: %s\n", *startStr, parseErr)
+				return 2
+			}
+		}
+		if *endStr != "" {
+			var parseErr error
+			s.EndsAt, parseErr = time.Parse(time.RFC3339, *endStr)
+			if parseErr != nil {
+				fmt.Fprintf(os.Stderr, "-end value %s is not a valid RFC3339 time: %s\n", *endStr, parseErr)
+				return 2
+			}
+		} else if *duration > 0 {
+			s.EndsAt = s.StartsAt.Add(*duration)
+		}
+		if validErr := s.Validate(); validErr != nil {
+			fmt.Fprintln(os.Stderr, "Invalid silence: ", validErr)
+			return 2
+		}
+
+		client := silence.Client{BaseUrl: "http://" + *addrFlag, Timeout: 10 * time.Second}
+		added, err := client.Add(s)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not add silence: ", err)
+			return 1
+		}
+		fmt.Printf("Added silence %s until %s\n", added.Id, added.EndsAt.Format(time.RFC3339))
+		return 0
+	case "remove":
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			silenceUsage()
+			return 2
+		}
+		client := silence.Client{BaseUrl: "http://" + *addrFlag, Timeout: 10 * time.Second}
+		err := client.Remove(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not remove silence: ", err)
+			return 1
+		}
+		fmt.Printf("Removed silence %s\n", flags.Arg(0))
+		return 0
+	default:
+		silenceUsage()
+		return 2
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/config"
+	"log"
+)
+
+/**
+target is one Vidispine installation being monitored, along with the checks that are run against it
+*/
+type target struct {
+	Name      string //empty if only one Vidispine is being monitored
+	Checks    []common.CheckEntry
+	scheduler *scheduler
+}
+
+/**
+returns a description of the target for log messages
+*/
+func describeTarget(name string) string {
+	if name == "" {
+		return "vidispine"
+	}
+	return "target '" + name + "'"
+}
+
+/**
+builds the checks for every target in the configuration. A target that is in existing keeps its scheduler, so
+that the rebuilt checks share what it has recorded
+*/
+func buildTargets(cfg *config.Config, existing []*target) ([]*target, error) {
+	schedulers := make(map[string]*scheduler, len(existing))
+	for _, t := range existing {
+		schedulers[t.Name] = t.scheduler
+	}
+
+	monitored := cfg.MonitoredTargets()
+	targets := make([]*target, 0, len(monitored))
+	for _, t := range monitored {
+		targetScheduler, haveScheduler := schedulers[t.Name]
+		if !haveScheduler {
+			targetScheduler = newScheduler(t.Name)
+		}
+		checks, buildErr := buildChecks(cfg, t, targetScheduler.sampler)
+		if buildErr != nil {
+			return nil, fmt.Errorf("%s: %s", describeTarget(t.Name), buildErr)
+		}
+		targets = append(targets, &target{
+			Name:      t.Name,
+			Checks:    checks,
+			scheduler: targetScheduler,
+		})
+	}
+	return targets, nil
+}
+
+/**
+returns the new list of targets, keeping the checks and schedule of any target that is still configured
+so that a reload doesn't make every check run at once
+*/
+func swapTargets(existing []*target, updated []*target) []*target {
+	existingByName := make(map[string]*target, len(existing))
+	for _, t := range existing {
+		existingByName[t.Name] = t
+	}
+
+	for _, t := range updated {
+		if previous, havePrevious := existingByName[t.Name]; havePrevious {
+			t.Checks = swapChecks(previous.Checks, t.Checks, t.Name)
+			t.scheduler = previous.scheduler
+			delete(existingByName, t.Name)
+		} else {
+			log.Printf("INFO %s added", describeTarget(t.Name))
+		}
+	}
+	for _, removed := range existingByName {
+		log.Printf("INFO %s removed", describeTarget(removed.Name))
+	}
+	return updated
+}
+
+/**
+runs the checks that are due against every target, returning all the alerts raised along with the names of the
+targets where a check could not run. A target that can't be reached doesn't stop the others being checked
+*/
+func runTargets(targets []*target, verboseMode bool) ([]common.RaisedAlert, []string) {
+	raised := make([]common.RaisedAlert, 0)
+	failed := make([]string, 0)
+	for _, t := range targets {
+		targetRaised, targetFailed := t.scheduler.runChecks(t.Checks, verboseMode)
+		raised = append(raised, targetRaised...)
+		if targetFailed {
+			failed = append(failed, describeTarget(t.Name))
+		}
+	}
+	return raised, failed
+}
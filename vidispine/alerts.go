@@ -0,0 +1,52 @@
+package vidispine
+
+import (
+	"errors"
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+	"time"
+)
+
+// kinds of problem that stop a check talking to Vidispine, sent as the class of the alert
+const (
+	ClassCredentialsRejected = "credentials-rejected" //the monitor's credentials were refused, e.g. after a password rotation
+	ClassUnavailable         = "api-unavailable"      //Vidispine could not be reached or answered with an error
+	ClassUnexpectedFormat    = "unexpected-format"    //Vidispine answered with something that could not be parsed
+)
+
+/**
+returns the class of the problem behind an error from the client
+*/
+func Classify(err error) string {
+	switch {
+	case errors.Is(err, ErrUnauthorized), errors.Is(err, ErrForbidden):
+		return ClassCredentialsRejected
+	case errors.Is(err, ErrUnexpectedFormat):
+		return ClassUnexpectedFormat
+	default:
+		return ClassUnavailable
+	}
+}
+
+var classDescriptions = map[string]string{
+	ClassCredentialsRejected: "Vidispine rejected the monitor's credentials",
+	ClassUnavailable:         "the Vidispine API is unavailable",
+	ClassUnexpectedFormat:    "Vidispine sent a response in an unexpected format",
+}
+
+/**
+returns the alert to raise when the named check could not get what it needs from Vidispine. The class is
+appended to dedupKey so that each kind of problem is its own incident
+*/
+func ErrorAlert(err error, checkName string, integrationKey string, dedupKey string) *pagerduty.TriggerEvent {
+	class := Classify(err)
+	nowTime := time.Now()
+	alert := pagerduty.NewTriggerEvent("vidispine-monitor",
+		integrationKey,
+		pagerduty.SeverityError,
+		dedupKey+"-"+class,
+		fmt.Sprintf("%s could not run, %s: %s", checkName, classDescriptions[class], err),
+		&nowTime)
+	alert.Payload.Class = class
+	return alert
+}
@@ -0,0 +1,131 @@
+package vidispine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	DefaultTimeout = 60 * time.Second
+	UserAgent      = "vidispine-monitor"
+)
+
+/**
+Client talks to the Vidispine API and admin service. Requests to the same endpoint share a connection pool.
+The zero Timeout means DefaultTimeout
+*/
+type Client struct {
+	Api       common.Endpoint   //the API, which the storage, job and version calls go to
+	Admin     common.Endpoint   //the admin service, which the healthcheck and metrics calls go to
+	Timeout   time.Duration     //how long each request may take
+	Transport http.RoundTripper //replaces the shared transport for the endpoint, e.g. in tests
+}
+
+func (c Client) httpClient(endpoint common.Endpoint) (*http.Client, error) {
+	if c.Transport != nil {
+		return &http.Client{Transport: c.Transport}, nil
+	}
+	return endpoint.Client()
+}
+
+/**
+gets path under the endpoint and decodes the JSON response into the given value. Any response status other
+than 2xx or one of alsoOk is returned as a *StatusError.
+With token auth, a 401 means the session token was not accepted (e.g. Vidispine was restarted), so the
+request is retried once with a new token
+*/
+func (c Client) getJSON(ctx context.Context, endpoint common.Endpoint, path string, query url.Values, into interface{}, alsoOk ...int) error {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancelFunc := context.WithTimeout(ctx, timeout)
+	defer cancelFunc()
+
+	httpClient, clientErr := c.httpClient(endpoint)
+	if clientErr != nil {
+		return clientErr
+	}
+	err := c.tryGetJSON(ctx, httpClient, endpoint, path, query, into, alsoOk)
+	var staleToken *staleTokenError
+	if errors.As(err, &staleToken) {
+		log.Printf("WARNING session token for %s was not accepted, logging in again", endpoint.BaseUrl)
+		forgetToken(endpoint, staleToken.token)
+		err = c.tryGetJSON(ctx, httpClient, endpoint, path, query, into, alsoOk)
+		if errors.As(err, &staleToken) {
+			err = staleToken.StatusError
+		}
+	}
+	return err
+}
+
+/**
+returned when Vidispine refuses a session token, so getJSON knows to log in again
+*/
+type staleTokenError struct {
+	*StatusError
+	token string
+}
+
+func (c Client) tryGetJSON(ctx context.Context, httpClient *http.Client, endpoint common.Endpoint, path string, query url.Values, into interface{}, alsoOk []int) error {
+	req, reqErr := endpoint.NewRequest(ctx, "GET", path)
+	if reqErr != nil {
+		return reqErr
+	}
+	if query != nil {
+		req.URL.RawQuery = query.Encode()
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", UserAgent)
+
+	token := ""
+	if endpoint.TokenAuth && endpoint.User != "" {
+		var tokenErr error
+		token, tokenErr = c.token(ctx, httpClient, endpoint)
+		if tokenErr != nil {
+			return tokenErr
+		}
+		req.Header.Set("Authorization", "token "+token)
+	}
+
+	response, httpErr := httpClient.Do(req)
+	if httpErr != nil {
+		return httpErr
+	}
+	defer response.Body.Close()
+
+	if !statusOk(response.StatusCode, alsoOk) {
+		statusErr := newStatusError(req.URL.String(), response)
+		if token != "" && response.StatusCode == http.StatusUnauthorized {
+			return &staleTokenError{StatusError: statusErr, token: token}
+		}
+		return statusErr
+	}
+	body, readErr := ioutil.ReadAll(response.Body)
+	if readErr != nil {
+		return readErr
+	}
+	if decodeErr := json.Unmarshal(body, into); decodeErr != nil {
+		return newFormatError(req.URL.String(), body, decodeErr)
+	}
+	return nil
+}
+
+func statusOk(statusCode int, alsoOk []int) bool {
+	if statusCode >= 200 && statusCode < 300 {
+		return true
+	}
+	for _, ok := range alsoOk {
+		if statusCode == ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,305 @@
+package vidispine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+fakeTransport answers every request with the given status and body, and remembers the last request
+*/
+type fakeTransport struct {
+	status  int
+	body    string
+	lastReq *http.Request
+}
+
+func (t *fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.lastReq = req
+	return &http.Response{
+		StatusCode: t.status,
+		Body:       ioutil.NopCloser(strings.NewReader(t.body)),
+		Header:     make(http.Header),
+		Request:    req,
+	}, nil
+}
+
+func fakeClient(transport http.RoundTripper) Client {
+	return Client{
+		Api:       common.Endpoint{BaseUrl: "https://vidispine.local/vs", User: "admin", Password: "secret"},
+		Admin:     common.Endpoint{BaseUrl: "http://vidispine.local:9001"},
+		Transport: transport,
+	}
+}
+
+func TestClient_Storages(t *testing.T) {
+	transport := &fakeTransport{status: 200, body: `{"storage": [{"id": "VX-1", "state": "READY", "type": "LOCAL"}]}`}
+	storages, err := fakeClient(transport).Storages(context.Background())
+	if err != nil {
+		t.Error("Storages returned an unexpected error: ", err)
+		t.FailNow()
+	}
+	if len(storages.Storage) != 1 || storages.Storage[0].Id != "VX-1" || storages.Storage[0].State != StorageStateReady {
+		t.Errorf("got unexpected storages %v", storages)
+	}
+
+	req := transport.lastReq
+	if req.URL.String() != "https://vidispine.local/vs/API/storage" {
+		t.Errorf("got unexpected url %s", req.URL)
+	}
+	if user, password, haveAuth := req.BasicAuth(); !haveAuth || user != "admin" || password != "secret" {
+		t.Error("basic auth was not sent")
+	}
+	if req.Header.Get("User-Agent") != UserAgent || req.Header.Get("Accept") != "application/json" {
+		t.Errorf("got unexpected headers %v", req.Header)
+	}
+}
+
+func TestClient_Healthcheck_unhealthy(t *testing.T) {
+	//the admin service uses a 500 to say that something is unhealthy
+	transport := &fakeTransport{status: 500, body: `{"database": {"healthy": false, "message": "Connection refused",
+		"error": {"type": "java.net.ConnectException", "message": "Connection refused", "stack": []}},
+		"broker": {"healthy": true}, "transcoder": {"healthy": false, "error": "no transcoders available"}}`}
+	healthcheck, err := fakeClient(transport).Healthcheck(context.Background())
+	if err != nil {
+		t.Error("Healthcheck returned an unexpected error: ", err)
+		t.FailNow()
+	}
+	if healthcheck["database"].Healthy || !healthcheck["broker"].Healthy {
+		t.Errorf("got unexpected healthcheck %v", healthcheck)
+	}
+	if database := healthcheck["database"]; database.Message != "Connection refused" || database.Error.String() != "java.net.ConnectException: Connection refused" {
+		t.Errorf("got unexpected database entry %v", database)
+	}
+	if transcoder := healthcheck["transcoder"]; transcoder.Error == nil || transcoder.Error.String() != "no transcoders available" {
+		t.Errorf("got unexpected transcoder entry %v", transcoder)
+	}
+	if names := healthcheck.Names(); len(names) != 3 || names[0] != "broker" || names[2] != "transcoder" {
+		t.Errorf("got unexpected names %v", names)
+	}
+	if transport.lastReq.URL.String() != "http://vidispine.local:9001/healthcheck" {
+		t.Errorf("got unexpected url %s", transport.lastReq.URL)
+	}
+}
+
+func TestClient_Jobs(t *testing.T) {
+	transport := &fakeTransport{status: 200, body: `{"hits": 1, "job": [{"jobId": "VX-10", "status": "RUNNING", "type": "TRANSCODE"}]}`}
+	jobs, err := fakeClient(transport).Jobs(context.Background(), []string{"RUNNING", "WAITING"}, 10)
+	if err != nil {
+		t.Error("Jobs returned an unexpected error: ", err)
+		t.FailNow()
+	}
+	if jobs.Hits != 1 || jobs.Job[0].JobId != "VX-10" {
+		t.Errorf("got unexpected jobs %v", jobs)
+	}
+	if transport.lastReq.URL.String() != "https://vidispine.local/vs/API/job?number=10&state=RUNNING%2CWAITING" {
+		t.Errorf("got unexpected url %s", transport.lastReq.URL)
+	}
+}
+
+func TestClient_Version(t *testing.T) {
+	transport := &fakeTransport{status: 200, body: `{"component": [{"name": "Vidispine", "version": "5.6.1"}]}`}
+	version, err := fakeClient(transport).Version(context.Background())
+	if err != nil {
+		t.Error("Version returned an unexpected error: ", err)
+		t.FailNow()
+	}
+	if version.Of("Vidispine") != "5.6.1" || version.Of("Other") != "" {
+		t.Errorf("got unexpected version %v", version)
+	}
+}
+
+func TestClient_statusErrors(t *testing.T) {
+	tests := []struct {
+		status   int
+		expected error
+	}{
+		{401, ErrUnauthorized},
+		{403, ErrForbidden},
+		{404, ErrNotFound},
+		{502, ErrServerError},
+	}
+	others := []error{ErrUnauthorized, ErrForbidden, ErrNotFound, ErrServerError}
+	for _, test := range tests {
+		transport := &fakeTransport{status: test.status, body: "  something went wrong\n"}
+		_, err := fakeClient(transport).Storages(context.Background())
+		if !errors.Is(err, test.expected) {
+			t.Errorf("expected a %d to be %s, got %v", test.status, test.expected, err)
+		}
+		for _, other := range others {
+			if other != test.expected && errors.Is(err, other) {
+				t.Errorf("a %d should not be %s", test.status, other)
+			}
+		}
+
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.Body != "something went wrong" {
+			t.Errorf("expected the body to be kept, got %v", err)
+		}
+	}
+
+	//the healthcheck only allows a 500, other errors still count
+	transport := &fakeTransport{status: 503, body: "unavailable"}
+	if _, err := fakeClient(transport).Healthcheck(context.Background()); !errors.Is(err, ErrServerError) {
+		t.Errorf("expected a 503 from the healthcheck to be an error, got %v", err)
+	}
+}
+
+func TestClient_unexpectedFormat(t *testing.T) {
+	transport := &fakeTransport{status: 200, body: "<html>login</html>"}
+	_, err := fakeClient(transport).Metrics(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "could not parse the response from http://vidispine.local:9001/metrics") {
+		t.Errorf("expected a parse error, got %v", err)
+	}
+}
+
+type slowTransport struct{}
+
+func (t slowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+func TestClient_timeout(t *testing.T) {
+	client := fakeClient(slowTransport{})
+	client.Timeout = 10 * time.Millisecond
+	_, err := client.Metrics(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the request to time out, got %v", err)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err   error
+		class string
+	}{
+		{&StatusError{StatusCode: 401}, ClassCredentialsRejected},
+		{&StatusError{StatusCode: 403}, ClassCredentialsRejected},
+		{&StatusError{StatusCode: 503}, ClassUnavailable},
+		{&StatusError{StatusCode: 404}, ClassUnavailable},
+		{&FormatError{Err: errors.New("invalid character '<'")}, ClassUnexpectedFormat},
+		{context.DeadlineExceeded, ClassUnavailable},
+	}
+	for _, test := range tests {
+		if class := Classify(test.err); class != test.class {
+			t.Errorf("expected %v to be %s, got %s", test.err, test.class, class)
+		}
+	}
+}
+
+func TestErrorAlert(t *testing.T) {
+	alert := ErrorAlert(&StatusError{Url: "https://vidispine.local/API/storage", StatusCode: 401}, "vidispine storage check", "key", "vsstoragecheck")
+	if alert.DeDupKey != "vsstoragecheck-credentials-rejected" || alert.Payload.Class != ClassCredentialsRejected {
+		t.Errorf("got unexpected alert %v", alert)
+	}
+	if alert.Payload.Summary != "vidispine storage check could not run, Vidispine rejected the monitor's credentials: https://vidispine.local/API/storage returned 401 Unauthorized" {
+		t.Errorf("got unexpected summary '%s'", alert.Payload.Summary)
+	}
+}
+
+/**
+tokenServer hands out numbered session tokens from /API/token and only accepts the latest one
+*/
+type tokenServer struct {
+	logins   int
+	current  string
+	requests []*http.Request
+}
+
+func (s *tokenServer) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.requests = append(s.requests, req)
+	status, body := 200, `{"storage": []}`
+	if req.URL.Path == "/vs/API/token" {
+		if user, password, _ := req.BasicAuth(); user != "admin" || password != "secret" {
+			status, body = 401, "bad login"
+		} else {
+			s.logins++
+			s.current = fmt.Sprintf("token-%d", s.logins)
+			body = s.current
+		}
+	} else if req.Header.Get("Authorization") != "token "+s.current {
+		status, body = 401, "token expired"
+	}
+	return &http.Response{
+		StatusCode: status,
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Header:     make(http.Header),
+		Request:    req,
+	}, nil
+}
+
+func TestClient_tokenAuth(t *testing.T) {
+	startTime := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	currentTime := startTime
+	now = func() time.Time { return currentTime }
+	defer func() { now = time.Now }()
+
+	server := &tokenServer{}
+	client := fakeClient(server)
+	client.Api.BaseUrl = "https://token-test.local/vs"
+	client.Api.TokenAuth = true
+	client.Api.TokenLifetime = 20 * time.Minute
+	client.Api.RunAs = "tenant"
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.Storages(context.Background()); err != nil {
+			t.Error("Storages returned an unexpected error: ", err)
+			t.FailNow()
+		}
+	}
+	if server.logins != 1 || len(server.requests) != 4 {
+		t.Errorf("expected one login to be reused, got %d logins and %d requests", server.logins, len(server.requests))
+	}
+	login := server.requests[0]
+	if login.URL.Query().Get("seconds") != "1200" || login.Header.Get("RunAs") != "" {
+		t.Errorf("got unexpected login request %s %v", login.URL, login.Header)
+	}
+	for _, req := range server.requests[1:] {
+		if _, _, haveAuth := req.BasicAuth(); haveAuth || req.Header.Get("RunAs") != "tenant" {
+			t.Errorf("expected only the token to be sent as tenant, got %v", req.Header)
+		}
+	}
+
+	//renewed once most of the lifetime is used up
+	currentTime = startTime.Add(16 * time.Minute)
+	if _, err := client.Storages(context.Background()); err != nil || server.logins != 2 {
+		t.Errorf("expected the token to be renewed, got %d logins and %v", server.logins, err)
+	}
+
+	//e.g. Vidispine was restarted and forgot the token
+	server.current = "something-else"
+	if _, err := client.Storages(context.Background()); err != nil || server.logins != 3 {
+		t.Errorf("expected a new login after a 401, got %d logins and %v", server.logins, err)
+	}
+
+	//a password that doesn't work is reported as such
+	client.Api.Password = "wrong"
+	if _, err := client.Storages(context.Background()); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected a rejected login to be unauthorized, got %v", err)
+	}
+}
+
+func TestMetricUnits(t *testing.T) {
+	meter := MetricMeter{Units: "events/minute"}
+	if rate := meter.PerSecond(120); rate != 2 {
+		t.Errorf("expected 120 events/minute to be 2/second, got %g", rate)
+	}
+	if rate := (MetricMeter{}).PerSecond(3); rate != 3 {
+		t.Errorf("expected rates without units to be per second, got %g", rate)
+	}
+	timer := MetricTimer{DurationUnits: "microseconds", RateUnits: "calls/second"}
+	if took, _ := timer.Duration(1500); took != 1500*time.Microsecond {
+		t.Errorf("got unexpected duration %s", took)
+	}
+	if _, err := (MetricTimer{DurationUnits: "fortnights"}).Duration(1); err == nil {
+		t.Error("expected unknown duration units to be an error")
+	}
+}
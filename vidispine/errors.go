@@ -0,0 +1,97 @@
+package vidispine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// how much of an error response body is kept
+const maxErrorBody = 512
+
+// use with errors.Is to find out why a request was refused
+var (
+	ErrUnauthorized = errors.New("not authorized") //401, the credentials were missing or rejected
+	ErrForbidden    = errors.New("forbidden")      //403, the user does not have permission
+	ErrNotFound     = errors.New("not found")      //404
+	ErrServerError  = errors.New("server error")   //any 5xx
+
+	ErrUnexpectedFormat = errors.New("unexpected response format") //the response could not be parsed
+)
+
+var errNotAToken = errors.New("the response is not a session token")
+
+/**
+StatusError is returned when Vidispine responds with an unexpected status code
+*/
+type StatusError struct {
+	Url        string
+	StatusCode int
+	Body       string //the start of the response body, which usually says what went wrong
+}
+
+func newStatusError(url string, response *http.Response) *StatusError {
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, maxErrorBody))
+	return &StatusError{
+		Url:        url,
+		StatusCode: response.StatusCode,
+		Body:       strings.TrimSpace(string(body)),
+	}
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("%s returned %d %s", e.Url, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Body != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Body)
+	}
+	return msg
+}
+
+/**
+lets errors.Is match the error against ErrUnauthorized, ErrForbidden, ErrNotFound and ErrServerError
+*/
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrServerError:
+		return e.StatusCode >= 500 && e.StatusCode < 600
+	default:
+		return false
+	}
+}
+
+/**
+FormatError is returned when a response from Vidispine can't be parsed, e.g. an HTML error page from a proxy
+*/
+type FormatError struct {
+	Url  string
+	Body string //the start of the response body
+	Err  error
+}
+
+func newFormatError(url string, body []byte, err error) *FormatError {
+	if len(body) > maxErrorBody {
+		body = body[:maxErrorBody]
+	}
+	return &FormatError{Url: url, Body: strings.TrimSpace(string(body)), Err: err}
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("could not parse the response from %s: %s (response began: %q)", e.Url, e.Err, e.Body)
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+func (e *FormatError) Is(target error) bool {
+	return target == ErrUnexpectedFormat
+}
@@ -0,0 +1,76 @@
+package vidispine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+/**
+the exception Dropwizard reports when a healthcheck throws, or the text it was given
+*/
+type HealthcheckError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+/**
+Dropwizard writes the error as an object with the exception's type, message and stack, but a plain string
+is accepted too
+*/
+func (e *HealthcheckError) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*e = HealthcheckError{Message: text}
+		return nil
+	}
+	type plain HealthcheckError
+	return json.Unmarshal(data, (*plain)(e))
+}
+
+func (e *HealthcheckError) String() string {
+	if e.Type != "" && e.Message != "" {
+		return e.Type + ": " + e.Message
+	}
+	return e.Type + e.Message
+}
+
+type HealthcheckEntry struct {
+	Healthy   bool              `json:"healthy"`
+	Message   string            `json:"message"` //why the entry is unhealthy, or sometimes more detail when healthy
+	Error     *HealthcheckError `json:"error"`   //set if the healthcheck threw
+	Duration  int               `json:"duration"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+/**
+HealthcheckResponse is every healthcheck that the admin service reports, by name, e.g. database, ldap or any
+that plugins add
+*/
+type HealthcheckResponse map[string]HealthcheckEntry
+
+/**
+returns the name of every entry, sorted
+*/
+func (r HealthcheckResponse) Names() []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/**
+gets the healthcheck from the admin service. The admin service responds with a 500 if any entry is
+unhealthy, so that is not an error here
+*/
+func (c Client) Healthcheck(ctx context.Context) (HealthcheckResponse, error) {
+	var healthcheck HealthcheckResponse
+	if err := c.getJSON(ctx, c.Admin, "healthcheck", nil, &healthcheck, http.StatusInternalServerError); err != nil {
+		return nil, err
+	}
+	return healthcheck, nil
+}
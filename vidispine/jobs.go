@@ -0,0 +1,43 @@
+package vidispine
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type Job struct {
+	JobId    string `json:"jobId"`
+	User     string `json:"user"`
+	Started  string `json:"started"`
+	Finished string `json:"finished"`
+	Status   string `json:"status"`
+	Type     string `json:"type"`
+	Priority string `json:"priority"`
+}
+
+type JobsResponse struct {
+	Hits int   `json:"hits"`
+	Job  []Job `json:"job"`
+}
+
+/**
+gets up to number jobs in any of the given states, e.g. RUNNING or WAITING, from the API. No states means
+jobs in every state
+*/
+func (c Client) Jobs(ctx context.Context, states []string, number int) (*JobsResponse, error) {
+	query := url.Values{}
+	if len(states) > 0 {
+		query.Set("state", strings.Join(states, ","))
+	}
+	if number > 0 {
+		query.Set("number", strconv.Itoa(number))
+	}
+
+	var jobs JobsResponse
+	if err := c.getJSON(ctx, c.Api, "API/job", query, &jobs); err != nil {
+		return nil, err
+	}
+	return &jobs, nil
+}
@@ -0,0 +1,208 @@
+package vidispine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type MetricGauge struct {
+	Value interface{} `json:"value"`
+}
+
+/**
+try to convert the gauge value into a decimal
+*/
+func (g MetricGauge) FloatValue() (float64, error) {
+	if floatVal, isFloat := g.Value.(float64); isFloat {
+		return floatVal, nil
+	}
+	if stringVal, isString := g.Value.(string); isString {
+		return strconv.ParseFloat(stringVal, 64)
+	}
+	return 0, errors.New("gauge did not contain a string or float")
+}
+
+/**
+returns a float64 of the value or panics
+*/
+func (g MetricGauge) MustFloat() float64 {
+	v, err := g.FloatValue()
+	if err != nil {
+		errString := fmt.Sprintf("%s is not a float value", g.Value)
+		panic(errString)
+	}
+	return v
+}
+
+/**
+returns the gauge value as a list of strings, for gauges such as jvm.threads.deadlocks whose value is a list
+*/
+func (g MetricGauge) StringsValue() ([]string, error) {
+	list, isList := g.Value.([]interface{})
+	if !isList {
+		return nil, errors.New("gauge did not contain a list")
+	}
+	values := make([]string, 0, len(list))
+	for _, item := range list {
+		stringVal, isString := item.(string)
+		if !isString {
+			return nil, fmt.Errorf("gauge list contained %v, which is not a string", item)
+		}
+		values = append(values, stringVal)
+	}
+	return values, nil
+}
+
+type MetricCounter struct {
+	Count int64 `json:"count"`
+}
+
+type MetricMeter struct {
+	Count    int64   `json:"count"`
+	M15Rate  float64 `json:"m15_rate"`
+	M1Rate   float64 `json:"m1_rate"`
+	M5Rate   float64 `json:"m5_rate"`
+	MeanRate float64 `json:"mean_rate"`
+	Units    string  `json:"units"`
+}
+
+/**
+returns a rate from the meter in events per second, whatever units the meter reports in
+*/
+func (m MetricMeter) PerSecond(rate float64) float64 {
+	return rate * perSecond(m.Units)
+}
+
+/**
+MetricSnapshot is the distribution of the values a histogram or timer has recorded recently
+*/
+type MetricSnapshot struct {
+	Count  int64   `json:"count"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	Min    float64 `json:"min"`
+	P50    float64 `json:"p50"`
+	P75    float64 `json:"p75"`
+	P95    float64 `json:"p95"`
+	P98    float64 `json:"p98"`
+	P99    float64 `json:"p99"`
+	P999   float64 `json:"p999"`
+	StdDev float64 `json:"stddev"`
+}
+
+// the statistics that Percentile can return
+var Percentiles = []string{"p50", "p75", "p95", "p98", "p99", "p999", "max", "mean", "min"}
+
+/**
+returns one of the statistics in Percentiles by name, e.g. p99
+*/
+func (s MetricSnapshot) Percentile(name string) (float64, bool) {
+	switch strings.ToLower(name) {
+	case "p50":
+		return s.P50, true
+	case "p75":
+		return s.P75, true
+	case "p95":
+		return s.P95, true
+	case "p98":
+		return s.P98, true
+	case "p99":
+		return s.P99, true
+	case "p999":
+		return s.P999, true
+	case "max":
+		return s.Max, true
+	case "mean":
+		return s.Mean, true
+	case "min":
+		return s.Min, true
+	default:
+		return 0, false
+	}
+}
+
+type MetricHistogram struct {
+	MetricSnapshot
+}
+
+/**
+MetricTimer is a histogram of how long something took, in DurationUnits, along with how often it happened,
+in RateUnits
+*/
+type MetricTimer struct {
+	MetricSnapshot
+	M15Rate       float64 `json:"m15_rate"`
+	M1Rate        float64 `json:"m1_rate"`
+	M5Rate        float64 `json:"m5_rate"`
+	MeanRate      float64 `json:"mean_rate"`
+	DurationUnits string  `json:"duration_units"` //e.g. seconds
+	RateUnits     string  `json:"rate_units"`     //e.g. calls/second
+}
+
+/**
+converts one of the timer's statistics to a duration, using its duration units
+*/
+func (t MetricTimer) Duration(value float64) (time.Duration, error) {
+	unit, knownUnit := durationUnits[strings.ToLower(t.DurationUnits)]
+	if !knownUnit {
+		return 0, fmt.Errorf("unknown duration units '%s'", t.DurationUnits)
+	}
+	return time.Duration(value * float64(unit)), nil
+}
+
+/**
+returns a rate from the timer in calls per second, whatever units the timer reports in
+*/
+func (t MetricTimer) PerSecond(rate float64) float64 {
+	return rate * perSecond(t.RateUnits)
+}
+
+var durationUnits = map[string]time.Duration{
+	"nanoseconds":  time.Nanosecond,
+	"microseconds": time.Microsecond,
+	"milliseconds": time.Millisecond,
+	"seconds":      time.Second,
+	"minutes":      time.Minute,
+	"hours":        time.Hour,
+	"days":         24 * time.Hour,
+}
+
+/**
+returns what to multiply a rate in the given units (e.g. events/minute) by to get a rate per second.
+Dropwizard reports per second unless configured otherwise, so that is assumed if the units can't be read
+*/
+func perSecond(units string) float64 {
+	slash := strings.LastIndex(units, "/")
+	if slash == -1 {
+		return 1
+	}
+	unit, knownUnit := durationUnits[strings.ToLower(units[slash+1:])+"s"]
+	if !knownUnit {
+		return 1
+	}
+	return float64(time.Second) / float64(unit)
+}
+
+type MetricsResponse struct {
+	Version    string                     `json:"version"`
+	Gauges     map[string]MetricGauge     `json:"gauges"`
+	Counters   map[string]MetricCounter   `json:"counters"`
+	Histograms map[string]MetricHistogram `json:"histograms"`
+	Meters     map[string]MetricMeter     `json:"meters"`
+	Timers     map[string]MetricTimer     `json:"timers"`
+}
+
+/**
+gets every metric from the admin service
+*/
+func (c Client) Metrics(ctx context.Context) (*MetricsResponse, error) {
+	var metrics MetricsResponse
+	if err := c.getJSON(ctx, c.Admin, "metrics", nil, &metrics); err != nil {
+		return nil, err
+	}
+	return &metrics, nil
+}
@@ -0,0 +1,64 @@
+package vidispine
+
+import "context"
+
+type StorageMethod struct {
+	Id          string `json:"id"`
+	Uri         string `json:"uri"`
+	Read        bool   `json:"read"`
+	Write       bool   `json:"write"`
+	Browse      bool   `json:"browse"`
+	LastSuccess string `json:"lastSuccess"`
+	Type        string `json:"type"`
+}
+
+// see https://apidoc.vidispine.com/latest/storage/storage.html#id1
+type StorageType string
+
+const (
+	LocalStorage    StorageType = "LOCAL"
+	SharedStorage   StorageType = "SHARED"
+	RemoteStorage   StorageType = "REMOTE"
+	ExternalStorage StorageType = "EXTERNAL"
+	ArchiveStorage  StorageType = "ARCHIVE"
+	ExportStorage   StorageType = "EXPORT"
+)
+
+type StorageState string
+
+const (
+	StorageStateNone       StorageState = "NONE"
+	StorageStateReady      StorageState = "READY"
+	StorageStateOffline    StorageState = "OFFLINE"
+	StorageStateFailed     StorageState = "FAILED"
+	StorageStateDisabled   StorageState = "DISABLED"
+	StorageStateEvacuating StorageState = "EVACUATING"
+	StorageStateEvacuated  StorageState = "EVACUATED"
+)
+
+type Storage struct {
+	Id            string          `json:"id"`
+	State         StorageState    `json:"state"`
+	Type          StorageType     `json:"type"`
+	Capacity      int64           `json:"capacity"`
+	FreeCapacity  int64           `json:"freeCapacity"`
+	Timestamp     string          `json:"timestamp"`
+	Methods       []StorageMethod `json:"method"`
+	LowWatermark  int64           `json:"lowWatermark"`
+	HighWatermark int64           `json:"highWatermark"`
+}
+
+type StoragesResponse struct {
+	Storage []Storage `json:"storage"`
+}
+
+/**
+gets every storage from the API
+*/
+func (c Client) Storages(ctx context.Context) (*StoragesResponse, error) {
+	var storages StoragesResponse
+	if err := c.getJSON(ctx, c.Api, "API/storage", nil, &storages); err != nil {
+		return nil, err
+	}
+	return &storages, nil
+}
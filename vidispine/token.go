@@ -0,0 +1,135 @@
+package vidispine
+
+import (
+	"context"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long session tokens are asked to last if the endpoint doesn't say
+const DefaultTokenLifetime = 15 * time.Minute
+
+// tokens are renewed once less than this fraction of their lifetime is left, so one never expires mid-request
+const tokenRenewFraction = 0.25
+
+/**
+a session token and when to get a new one
+*/
+type sessionToken struct {
+	value   string
+	renewAt time.Time
+}
+
+/**
+tokens are shared between every check that logs in to the same API as the same user, so that the password is
+only sent when a token is needed. A password change gives a new key, so the old token is never reused
+*/
+type tokenKey struct {
+	baseUrl  string
+	user     string
+	password string
+}
+
+var (
+	tokensLock sync.Mutex
+	tokens     = make(map[tokenKey]*sessionToken)
+)
+
+// replaced in tests
+var now = time.Now
+
+func keyFor(endpoint common.Endpoint) tokenKey {
+	return tokenKey{baseUrl: endpoint.BaseUrl, user: endpoint.User, password: endpoint.Password}
+}
+
+func tokenLifetime(endpoint common.Endpoint) time.Duration {
+	if endpoint.TokenLifetime > 0 {
+		return endpoint.TokenLifetime
+	}
+	return DefaultTokenLifetime
+}
+
+/**
+returns the session token for the endpoint, logging in for a new one if there isn't one or it is due for renewal
+*/
+func (c Client) token(ctx context.Context, httpClient *http.Client, endpoint common.Endpoint) (string, error) {
+	key := keyFor(endpoint)
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+
+	if existing, haveExisting := tokens[key]; haveExisting && now().Before(existing.renewAt) {
+		return existing.value, nil
+	}
+	lifetime := tokenLifetime(endpoint)
+	requested := now()
+	value, loginErr := c.login(ctx, httpClient, endpoint, lifetime)
+	if loginErr != nil {
+		delete(tokens, key)
+		return "", loginErr
+	}
+	tokens[key] = &sessionToken{
+		value:   value,
+		renewAt: requested.Add(lifetime - time.Duration(float64(lifetime)*tokenRenewFraction)),
+	}
+	return value, nil
+}
+
+/**
+forgets the endpoint's session token, e.g. after Vidispine stopped accepting it, so the next request logs in again
+*/
+func forgetToken(endpoint common.Endpoint, value string) {
+	key := keyFor(endpoint)
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+	if existing, haveExisting := tokens[key]; haveExisting && existing.value == value {
+		delete(tokens, key)
+	}
+}
+
+/**
+asks Vidispine for a new session token, which is the only request that sends the password
+*/
+func (c Client) login(ctx context.Context, httpClient *http.Client, endpoint common.Endpoint, lifetime time.Duration) (string, error) {
+	urlStr, urlErr := endpoint.Url("API/token")
+	if urlErr != nil {
+		return "", urlErr
+	}
+	req, reqErr := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
+	if reqErr != nil {
+		return "", reqErr
+	}
+	req.URL.RawQuery = url.Values{
+		"seconds":     {strconv.Itoa(int(lifetime.Seconds()))},
+		"autoRefresh": {"false"},
+	}.Encode()
+	req.SetBasicAuth(endpoint.User, endpoint.Password)
+	req.Header.Set("Accept", "text/plain")
+	req.Header.Set("User-Agent", UserAgent)
+
+	response, httpErr := httpClient.Do(req)
+	if httpErr != nil {
+		return "", httpErr
+	}
+	defer response.Body.Close()
+	if !statusOk(response.StatusCode, nil) {
+		return "", newStatusError(req.URL.String(), response)
+	}
+	body, readErr := ioutil.ReadAll(io.LimitReader(response.Body, maxErrorBody))
+	if readErr != nil {
+		return "", readErr
+	}
+	value := strings.TrimSpace(string(body))
+	if value == "" || strings.ContainsAny(value, " <\n") {
+		return "", newFormatError(req.URL.String(), body, errNotAToken)
+	}
+	log.Printf("INFO logged in to %s as %s for a session token lasting %s", endpoint.BaseUrl, endpoint.User, lifetime)
+	return value, nil
+}
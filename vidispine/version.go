@@ -0,0 +1,36 @@
+package vidispine
+
+import "context"
+
+type VersionComponent struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Build   string `json:"build"`
+}
+
+type VersionResponse struct {
+	Component []VersionComponent `json:"component"`
+}
+
+/**
+returns the version of the named component, e.g. Vidispine, or "" if it is not listed
+*/
+func (v *VersionResponse) Of(name string) string {
+	for _, c := range v.Component {
+		if c.Name == name {
+			return c.Version
+		}
+	}
+	return ""
+}
+
+/**
+gets the versions of the Vidispine components from the API
+*/
+func (c Client) Version(ctx context.Context) (*VersionResponse, error) {
+	var version VersionResponse
+	if err := c.getJSON(ctx, c.Api, "API/version", nil, &version); err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
@@ -0,0 +1,37 @@
+package vsapicheck
+
+import (
+	"context"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vidispine"
+	"log"
+)
+
+/**
+VSApiCheck logs in to the 8080 API with the monitor's credentials and asks for Vidispine's version. It raises no
+alerts of its own when that works; it is there so that checks which use the API, like the storage check, can
+depend on it and be skipped when the API is down or the credentials are rejected, leaving one alert for the cause
+*/
+type VSApiCheck struct {
+	Api            common.Endpoint
+	IntegrationKey string
+}
+
+func (c VSApiCheck) Name() string {
+	return "Vidispine API check"
+}
+
+func (c VSApiCheck) Run(verboseMode bool) ([]*pagerduty.TriggerEvent, error) {
+	version, err := vidispine.Client{Api: c.Api}.Version(context.Background())
+	if err != nil {
+		log.Print("ERROR could not log in to the Vidispine API: ", err)
+		return []*pagerduty.TriggerEvent{
+			vidispine.ErrorAlert(err, "vidispine API check", c.IntegrationKey, "vsapicheck"),
+		}, err
+	}
+	if verboseMode {
+		log.Printf("INFO (verbose) VSApiCheck.Run logged in to Vidispine %s as %s", version.Of("Vidispine"), c.Api.User)
+	}
+	return nil, nil
+}
@@ -0,0 +1,38 @@
+package vsapicheck
+
+import (
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vidispine"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVSApiCheck_Run(t *testing.T) {
+	password := "current"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, given, _ := r.BasicAuth(); given != password {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Authentication required"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"component": [{"name": "Vidispine", "version": "5.6"}]}`))
+	}))
+	defer server.Close()
+
+	c := VSApiCheck{Api: common.Endpoint{BaseUrl: server.URL, User: "monitor", Password: "current"}, IntegrationKey: "key"}
+	alerts, err := c.Run(true)
+	if err != nil || len(alerts) != 0 {
+		t.Errorf("expected no alerts when the credentials work, got %v %v", alerts, err)
+	}
+
+	password = "rotated"
+	alerts, err = c.Run(false)
+	if err == nil {
+		t.Error("expected an error when the credentials are rejected, so that dependent checks are skipped")
+	}
+	if len(alerts) != 1 || alerts[0].DeDupKey != "vsapicheck-"+vidispine.ClassCredentialsRejected {
+		t.Errorf("expected a single credentials-rejected alert, got %v", alerts)
+	}
+}
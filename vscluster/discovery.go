@@ -0,0 +1,59 @@
+package vscluster
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// replaced in tests
+var lookupSRV = net.LookupSRV
+
+/**
+Discovery finds the nodes of a Vidispine cluster, either from a fixed list or from a DNS SRV record such as
+the one Kubernetes publishes for a headless service
+*/
+type Discovery struct {
+	Nodes []string //hostnames of each node
+	Srv   string   //full name of the SRV record to look up, e.g. _admin._tcp.vidispine.media.svc.cluster.local
+}
+
+/**
+returns true if there is any way of finding the nodes
+*/
+func (d Discovery) Configured() bool {
+	return len(d.Nodes) > 0 || d.Srv != ""
+}
+
+/**
+returns the hostname of every node, sorted so that they are always checked in the same order
+*/
+func (d Discovery) Resolve() ([]string, error) {
+	if d.Srv == "" {
+		if len(d.Nodes) == 0 {
+			return nil, errors.New("no cluster nodes are configured")
+		}
+		return d.Nodes, nil
+	}
+
+	_, records, lookupErr := lookupSRV("", "", d.Srv)
+	if lookupErr != nil {
+		return nil, fmt.Errorf("could not look up cluster nodes from %s: %s", d.Srv, lookupErr)
+	}
+	seen := make(map[string]bool, len(records))
+	nodes := make([]string, 0, len(records))
+	for _, r := range records {
+		host := strings.TrimSuffix(r.Target, ".")
+		if host != "" && !seen[host] {
+			seen[host] = true
+			nodes = append(nodes, host)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no cluster nodes were found in %s", d.Srv)
+	}
+	sort.Strings(nodes)
+	return nodes, nil
+}
@@ -0,0 +1,78 @@
+package vscluster
+
+import (
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+	"log"
+	"strings"
+	"time"
+)
+
+/**
+PerNode runs a check against every node of a cluster instead of whichever node the load balancer picks.
+Each alert is marked with the node it was raised for. A node that can't be checked is only an error if no
+node can be checked; SizeCheck reports nodes that are not answering
+*/
+type PerNode struct {
+	Check          common.NodeCheck
+	Discovery      Discovery
+	IntegrationKey string
+}
+
+func (p PerNode) Name() string {
+	return p.Check.Name() + " on each node"
+}
+
+/**
+the checks' own dependencies between alerts apply on each node
+*/
+func (p PerNode) AlertDependsOn() map[string]string {
+	if deps, haveDeps := p.Check.(common.AlertDependencies); haveDeps {
+		return deps.AlertDependsOn()
+	}
+	return map[string]string{}
+}
+
+func (p PerNode) Run(verboseMode bool) ([]*pagerduty.TriggerEvent, error) {
+	nodes, resolveErr := p.Discovery.Resolve()
+	if resolveErr != nil {
+		log.Printf("ERROR %s could not find the cluster nodes: %s", p.Name(), resolveErr)
+		return []*pagerduty.TriggerEvent{discoveryAlert(p.IntegrationKey, resolveErr)}, resolveErr
+	}
+
+	alerts := make([]*pagerduty.TriggerEvent, 0)
+	failedNodes := make([]string, 0)
+	for _, node := range nodes {
+		if verboseMode {
+			log.Printf("INFO (verbose) Checking %s on node %s", p.Check.Name(), node)
+		}
+		nodeAlerts, runErr := p.Check.ForNode(node).Run(verboseMode)
+		if runErr != nil {
+			log.Printf("ERROR running '%s' on node %s failed: %s", p.Check.Name(), node, runErr)
+			failedNodes = append(failedNodes, node)
+		}
+		for _, alert := range nodeAlerts {
+			alert.ForNode(node)
+			alerts = append(alerts, alert)
+		}
+	}
+
+	if len(failedNodes) == len(nodes) {
+		return alerts, fmt.Errorf("%s could not run on any node: %s", p.Check.Name(), strings.Join(failedNodes, ", "))
+	}
+	return alerts, nil
+}
+
+/**
+returns the alert raised when the cluster nodes can't be found
+*/
+func discoveryAlert(integrationKey string, err error) *pagerduty.TriggerEvent {
+	nowTime := time.Now()
+	return pagerduty.NewTriggerEvent("vidispine-cluster",
+		integrationKey,
+		pagerduty.SeverityError,
+		"vidispine-cluster-discovery",
+		fmt.Sprintf("Could not find the Vidispine cluster nodes: %s", err),
+		&nowTime)
+}
@@ -0,0 +1,121 @@
+package vscluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vidispine"
+	"log"
+	"strings"
+	"time"
+)
+
+// replaced in tests
+var loadMetrics = func(admin common.Endpoint) (*vidispine.MetricsResponse, error) {
+	return vidispine.Client{Admin: admin}.Metrics(context.Background())
+}
+
+/**
+SizeCheck alerts when the number of nodes whose admin service answers is not the cluster.size that
+Vidispine itself reports
+*/
+type SizeCheck struct {
+	Discovery      Discovery
+	Admin          common.Endpoint //the admin service, with each node's name in place of its host
+	IntegrationKey string
+}
+
+func (c SizeCheck) Name() string {
+	return "Cluster size"
+}
+
+func (c SizeCheck) Run(verboseMode bool) ([]*pagerduty.TriggerEvent, error) {
+	nodes, resolveErr := c.Discovery.Resolve()
+	if resolveErr != nil {
+		log.Printf("ERROR %s could not find the cluster nodes: %s", c.Name(), resolveErr)
+		return []*pagerduty.TriggerEvent{discoveryAlert(c.IntegrationKey, resolveErr)}, resolveErr
+	}
+
+	sizes := make(map[string]float64, len(nodes))
+	unanswered := make([]string, 0)
+	for _, node := range nodes {
+		metrics, loadErr := loadMetrics(c.Admin.ForHost(node))
+		if loadErr != nil {
+			log.Printf("WARNING node %s did not answer: %s", node, loadErr)
+			unanswered = append(unanswered, node)
+			continue
+		}
+		gauge, haveGauge := metrics.Gauges["cluster.size"]
+		if !haveGauge {
+			log.Printf("WARNING metrics from node %s did not include cluster.size", node)
+			sizes[node] = 0
+			continue
+		}
+		size, floatErr := gauge.FloatValue()
+		if floatErr != nil {
+			log.Printf("WARNING cluster.size from node %s is not a number: %s", node, floatErr)
+		}
+		sizes[node] = size
+	}
+
+	if len(sizes) == 0 {
+		err := errors.New("no cluster node answered")
+		return []*pagerduty.TriggerEvent{noNodesAlert(c.IntegrationKey, unanswered)}, err
+	}
+	if verboseMode {
+		log.Printf("INFO (verbose) %d of %d nodes answered, reporting cluster sizes %v", len(sizes), len(nodes), sizes)
+	}
+
+	alert := c.checkSize(sizes, unanswered)
+	if alert == nil {
+		return []*pagerduty.TriggerEvent{}, nil
+	}
+	return []*pagerduty.TriggerEvent{alert}, nil
+}
+
+/**
+returns an alert if the number of nodes that answered, given by sizes, is not the largest cluster size any
+of them reported. Nodes that did not report a size are counted but don't affect the expected size
+*/
+func (c SizeCheck) checkSize(sizes map[string]float64, unanswered []string) *pagerduty.TriggerEvent {
+	expected := 0
+	for _, size := range sizes {
+		if int(size) > expected {
+			expected = int(size)
+		}
+	}
+	if expected == 0 {
+		log.Print("WARNING no node reported a cluster.size, can't alert on cluster size")
+		return nil
+	}
+	if len(sizes) == expected {
+		return nil
+	}
+
+	summary := fmt.Sprintf("%d nodes are answering but Vidispine reports a cluster size of %d", len(sizes), expected)
+	if len(unanswered) > 0 {
+		summary = fmt.Sprintf("%s; not answering: %s", summary, strings.Join(unanswered, ", "))
+	}
+	nowTime := time.Now()
+	return pagerduty.NewTriggerEvent("vidispine-cluster",
+		c.IntegrationKey,
+		pagerduty.SeverityWarning,
+		"vidispine-cluster-size",
+		summary,
+		&nowTime)
+}
+
+/**
+returns the alert raised when none of the cluster nodes answer
+*/
+func noNodesAlert(integrationKey string, unanswered []string) *pagerduty.TriggerEvent {
+	nowTime := time.Now()
+	return pagerduty.NewTriggerEvent("vidispine-cluster",
+		integrationKey,
+		pagerduty.SeverityError,
+		"vidispine-cluster-size",
+		fmt.Sprintf("None of the %d Vidispine cluster nodes are answering: %s", len(unanswered), strings.Join(unanswered, ", ")),
+		&nowTime)
+}
@@ -0,0 +1,226 @@
+package vscluster
+
+import (
+	"errors"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vidispine"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vshealthcheck"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vsmetriccheck"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiscovery_Resolve_srv(t *testing.T) {
+	defer func() { lookupSRV = net.LookupSRV }()
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		if name != "_admin._tcp.vidispine.local" {
+			t.Errorf("looked up unexpected name %s", name)
+		}
+		return name, []*net.SRV{
+			{Target: "vs-2.vidispine.local.", Port: 9001},
+			{Target: "vs-1.vidispine.local.", Port: 9001},
+			{Target: "vs-1.vidispine.local.", Port: 9002},
+		}, nil
+	}
+
+	nodes, err := Discovery{Srv: "_admin._tcp.vidispine.local"}.Resolve()
+	if err != nil {
+		t.Error("Resolve returned an unexpected error: ", err)
+		t.FailNow()
+	}
+	if strings.Join(nodes, ",") != "vs-1.vidispine.local,vs-2.vidispine.local" {
+		t.Errorf("got unexpected nodes %v", nodes)
+	}
+}
+
+func TestDiscovery_Resolve_errors(t *testing.T) {
+	defer func() { lookupSRV = net.LookupSRV }()
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		return name, []*net.SRV{}, nil
+	}
+
+	if _, err := (Discovery{Srv: "_admin._tcp.vidispine.local"}).Resolve(); err == nil {
+		t.Error("expected an error when the SRV record has no targets")
+	}
+	if _, err := (Discovery{}).Resolve(); err == nil {
+		t.Error("expected an error when nothing is configured")
+	}
+}
+
+type fakeNodeCheck struct {
+	node     string
+	down     map[string]bool
+	alerting map[string]bool
+}
+
+func (c fakeNodeCheck) Name() string {
+	return "fake"
+}
+
+func (c fakeNodeCheck) ForNode(host string) common.MonitorComponent {
+	c.node = host
+	return c
+}
+
+func (c fakeNodeCheck) Run(verboseMode bool) ([]*pagerduty.TriggerEvent, error) {
+	if c.down[c.node] {
+		return nil, errors.New("connection refused")
+	}
+	if c.alerting[c.node] {
+		now := time.Now()
+		return []*pagerduty.TriggerEvent{
+			pagerduty.NewTriggerEvent("vidispine-heap", "key", pagerduty.SeverityWarning, "vidispine-heap", "heap is full", &now),
+		}, nil
+	}
+	return nil, nil
+}
+
+func TestPerNode_Run(t *testing.T) {
+	check := PerNode{
+		Check: fakeNodeCheck{
+			down:     map[string]bool{"vs-3": true},
+			alerting: map[string]bool{"vs-2": true},
+		},
+		Discovery: Discovery{Nodes: []string{"vs-1", "vs-2", "vs-3"}},
+	}
+
+	alerts, err := check.Run(false)
+	if err != nil {
+		t.Error("one node being down should not be an error, got ", err)
+	}
+	if len(alerts) != 1 {
+		t.Errorf("expected 1 alert, got %d", len(alerts))
+		t.FailNow()
+	}
+	if alerts[0].DeDupKey != "vidispine-heap@vs-2" {
+		t.Errorf("got unexpected dedup key %s", alerts[0].DeDupKey)
+	}
+	if alerts[0].Payload.Summary != "On node vs-2: heap is full" {
+		t.Errorf("got unexpected summary %s", alerts[0].Payload.Summary)
+	}
+
+	allDown := PerNode{
+		Check:     fakeNodeCheck{down: map[string]bool{"vs-1": true}},
+		Discovery: Discovery{Nodes: []string{"vs-1"}},
+	}
+	if _, err := allDown.Run(false); err == nil {
+		t.Error("expected an error when no node could be checked")
+	}
+}
+
+func TestPerNode_oneNodeDown(t *testing.T) {
+	//the admin service answers on 127.0.0.1, and nothing listens on the same port on 127.0.0.2
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	admin := common.Endpoint{BaseUrl: server.URL}
+	nodes := Discovery{Nodes: []string{"127.0.0.1", "127.0.0.2"}}
+	healthcheck := PerNode{Check: vshealthcheck.VSHealthCheckMonitor{Admin: admin}, Discovery: nodes}
+	metrics := PerNode{Check: vsmetriccheck.VSMetricCheck{Admin: admin}, Discovery: nodes}
+
+	raised := make([]common.RaisedAlert, 0)
+	for _, check := range []PerNode{healthcheck, metrics} {
+		alerts, err := check.Run(false)
+		if err != nil {
+			t.Errorf("one node being down should not stop '%s', got %s", check.Name(), err)
+		}
+		for _, alert := range alerts {
+			raised = append(raised, common.RaisedAlert{CheckName: check.Name(), Event: alert})
+		}
+	}
+
+	downAlerts := make([]*pagerduty.TriggerEvent, 0)
+	for _, r := range common.FoldAlerts(raised, metrics.AlertDependsOn()) {
+		if strings.HasSuffix(r.Event.DeDupKey, "@127.0.0.2") {
+			downAlerts = append(downAlerts, r.Event)
+		}
+	}
+	if len(downAlerts) != 1 {
+		t.Fatalf("expected a single alert for the node that is down, got %v", downAlerts)
+	}
+	if downAlerts[0].DeDupKey != "vshealthcheck-api-unavailable@127.0.0.2" {
+		t.Errorf("expected the healthcheck's alert for the node that is down, got %s", downAlerts[0].DeDupKey)
+	}
+	if !strings.Contains(downAlerts[0].Payload.Summary, "also: On node 127.0.0.2: vidispine metrics check could not run") {
+		t.Errorf("expected the metrics check's failure to be folded into the healthcheck's, got %s", downAlerts[0].Payload.Summary)
+	}
+}
+
+func fakeClusterMetrics(size interface{}) *vidispine.MetricsResponse {
+	return &vidispine.MetricsResponse{
+		Gauges: map[string]vidispine.MetricGauge{"cluster.size": {Value: size}},
+	}
+}
+
+func TestSizeCheck_Run(t *testing.T) {
+	defer func(original func(common.Endpoint) (*vidispine.MetricsResponse, error)) { loadMetrics = original }(loadMetrics)
+	loadMetrics = func(admin common.Endpoint) (*vidispine.MetricsResponse, error) {
+		if admin.BaseUrl == "http://vs-3:9001" {
+			return nil, errors.New("connection refused")
+		}
+		return fakeClusterMetrics(3.0), nil
+	}
+
+	check := SizeCheck{
+		Discovery: Discovery{Nodes: []string{"vs-1", "vs-2", "vs-3"}},
+		Admin:     common.HostEndpoint("vidispine.local", 9001, false),
+	}
+	alerts, err := check.Run(false)
+	if err != nil {
+		t.Error("Run returned an unexpected error: ", err)
+	}
+	if len(alerts) != 1 {
+		t.Errorf("expected 1 alert, got %d", len(alerts))
+		t.FailNow()
+	}
+	if alerts[0].DeDupKey != "vidispine-cluster-size" {
+		t.Errorf("got unexpected dedup key %s", alerts[0].DeDupKey)
+	}
+	if alerts[0].Payload.Summary != "2 nodes are answering but Vidispine reports a cluster size of 3; not answering: vs-3" {
+		t.Errorf("got unexpected summary %s", alerts[0].Payload.Summary)
+	}
+}
+
+func TestSizeCheck_Run_healthy(t *testing.T) {
+	defer func(original func(common.Endpoint) (*vidispine.MetricsResponse, error)) { loadMetrics = original }(loadMetrics)
+	loadMetrics = func(admin common.Endpoint) (*vidispine.MetricsResponse, error) {
+		return fakeClusterMetrics("2"), nil
+	}
+
+	alerts, err := SizeCheck{Discovery: Discovery{Nodes: []string{"vs-1", "vs-2"}}}.Run(false)
+	if err != nil || len(alerts) != 0 {
+		t.Errorf("expected no alerts and no error, got %v, %v", alerts, err)
+	}
+}
+
+func TestSizeCheck_Run_noneAnswering(t *testing.T) {
+	defer func(original func(common.Endpoint) (*vidispine.MetricsResponse, error)) { loadMetrics = original }(loadMetrics)
+	loadMetrics = func(admin common.Endpoint) (*vidispine.MetricsResponse, error) {
+		return nil, errors.New("connection refused")
+	}
+
+	check := SizeCheck{
+		Discovery: Discovery{Nodes: []string{"vs-1", "vs-2"}},
+		Admin:     common.HostEndpoint("vidispine.local", 9001, false),
+	}
+	alerts, err := check.Run(false)
+	if err == nil {
+		t.Error("expected an error when no node answered")
+	}
+	if len(alerts) != 1 {
+		t.Fatalf("expected 1 alert, got %d", len(alerts))
+	}
+	if alerts[0].DeDupKey != "vidispine-cluster-size" || alerts[0].Payload.Severity != pagerduty.SeverityError {
+		t.Errorf("expected an error alert on the cluster size, got %s %s", alerts[0].DeDupKey, alerts[0].Payload.Severity)
+	}
+	if alerts[0].Payload.Summary != "None of the 2 Vidispine cluster nodes are answering: vs-1, vs-2" {
+		t.Errorf("got unexpected summary %s", alerts[0].Payload.Summary)
+	}
+}
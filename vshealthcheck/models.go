@@ -1,17 +1,7 @@
 package vshealthcheck
 
-import "time"
+import "gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vidispine"
 
-type HealthcheckEntry struct {
-	Healthy   bool      `json:"healthy"`
-	Duration  int       `json:"duration"`
-	Timestamp time.Time `json:"timestamp"`
-}
-
-type HealthcheckResponse struct {
-	Broker        HealthcheckEntry `json:"broker"`
-	Database      HealthcheckEntry `json:"database"`
-	Deadlocks     HealthcheckEntry `json:"deadlocks"`
-	Elasticsearch HealthcheckEntry `json:"elasticsearch"`
-	Ldap          HealthcheckEntry `json:"ldap"`
-}
+// the responses are read by the vidispine client, these names are kept for the existing code
+type HealthcheckEntry = vidispine.HealthcheckEntry
+type HealthcheckResponse = vidispine.HealthcheckResponse
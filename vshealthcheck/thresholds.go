@@ -0,0 +1,98 @@
+package vshealthcheck
+
+import (
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"sort"
+	"strings"
+	"time"
+)
+
+/**
+TimingThresholds are how long a healthcheck entry may take, and how old its result may be, before an alert is
+raised. Any that are left at zero are inherited, from the general thresholds and then from DefaultThresholds
+*/
+type TimingThresholds struct {
+	DurationWarning  common.Duration `yaml:"duration_warning"`  //how long the entry may take to run
+	DurationCritical common.Duration `yaml:"duration_critical"` //how long the entry may take before failure is likely
+	MaxAge           common.Duration `yaml:"max_age"`           //how old the entry's timestamp may be, e.g. if the healthcheck thread is stuck
+}
+
+/**
+Thresholds are the timing thresholds for every healthcheck entry, along with any that apply to particular
+entries by name, e.g. a higher duration_warning for elasticsearch
+*/
+type Thresholds struct {
+	TimingThresholds `yaml:",inline"`
+	Entries          map[string]TimingThresholds `yaml:"entries"`
+}
+
+var DefaultThresholds = Thresholds{
+	TimingThresholds: TimingThresholds{
+		DurationWarning:  common.Duration{Duration: 5 * time.Second},
+		DurationCritical: common.Duration{Duration: 20 * time.Second},
+		MaxAge:           common.Duration{Duration: 10 * time.Minute},
+	},
+}
+
+func (t TimingThresholds) withDefaults(defaults TimingThresholds) TimingThresholds {
+	common.FillDefaults(&t, defaults)
+	return t
+}
+
+/**
+returns the thresholds that apply to the named entry
+*/
+func (t Thresholds) For(name string) TimingThresholds {
+	general := t.TimingThresholds.withDefaults(DefaultThresholds.TimingThresholds)
+	for entryName, entry := range t.Entries {
+		if strings.EqualFold(entryName, name) {
+			return entry.withDefaults(general)
+		}
+	}
+	return general
+}
+
+func (t TimingThresholds) validate(prefix string) []string {
+	problems := make([]string, 0)
+	durations := []struct {
+		name  string
+		value common.Duration
+	}{
+		{"duration_warning", t.DurationWarning},
+		{"duration_critical", t.DurationCritical},
+		{"max_age", t.MaxAge},
+	}
+	for _, d := range durations {
+		if err := d.value.Err(); err != nil {
+			problems = append(problems, fmt.Sprintf("%s%s: %s", prefix, d.name, err))
+		} else if d.value.Duration < 0 {
+			problems = append(problems, fmt.Sprintf("%s%s must not be negative", prefix, d.name))
+		}
+	}
+	return problems
+}
+
+/**
+returns a description of every problem with the thresholds, or an empty list if they are usable
+*/
+func (t Thresholds) Validate() []string {
+	problems := t.TimingThresholds.validate("")
+	if general := t.TimingThresholds.withDefaults(DefaultThresholds.TimingThresholds); general.DurationWarning.Duration > general.DurationCritical.Duration {
+		problems = append(problems, "duration_warning must not be higher than duration_critical")
+	}
+	names := make([]string, 0, len(t.Entries))
+	for name := range t.Entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entry := t.Entries[name]
+		prefix := fmt.Sprintf("entries.%s.", name)
+		problems = append(problems, entry.validate(prefix)...)
+		if resolved := t.For(name); resolved.DurationWarning.Duration > resolved.DurationCritical.Duration {
+			problems = append(problems, prefix+"duration_warning must not be higher than duration_critical")
+		}
+	}
+	return problems
+}
@@ -1,13 +1,13 @@
 package vshealthcheck
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
 	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
-	"io/ioutil"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vidispine"
 	"log"
-	"net/http"
-	"net/url"
+	"path"
 	"strings"
 	"time"
 )
@@ -15,49 +15,40 @@ import (
 type VSHealthCheckMonitor struct {
 	VidispineHost  string
 	VidispineHttps bool
+	Admin          common.Endpoint //the admin service, if not set then port 9001 of VidispineHost is used
 	PDServiceId    string
+	Include        []string //names of the healthcheck entries to alert on, which can be patterns like transcoder-*. All if empty
+	Exclude        []string //names of the healthcheck entries to ignore, which can also be patterns
+	Thresholds     Thresholds
 }
 
-/**
-gets helthcheck data from the VS endpoint at the given host
-*/
-func (m VSHealthCheckMonitor) loadHealthcheck(vsHost string, vsHttps bool) (*HealthcheckResponse, error) {
-	proto := "https:/"
-	if !vsHttps {
-		proto = "http:/"
-	}
-
-	urlParts := []string{
-		proto,
-		vsHost + ":9001",
-		"healthcheck",
-	}
-
-	urlStr := strings.Join(urlParts, "/")
-	_, urlParseErr := url.Parse(urlStr)
-	if urlParseErr != nil {
-		log.Printf("ERROR vsHealthcheck.loadHealthcheck URL %s is not valid: %s", urlStr, urlParseErr)
-		return nil, urlParseErr
-	}
-
-	httpResp, httpErr := http.Get(urlStr)
-	if httpErr != nil {
-		return nil, httpErr
-	}
-	defer httpResp.Body.Close()
+// the entries Vidispine has always reported, as they are named in alerts. Any others are named as reported
+var displayNames = map[string]string{
+	"broker":        "Broker",
+	"database":      "Database",
+	"deadlocks":     "Deadlocks",
+	"elasticsearch": "Elasticsearch",
+	"ldap":          "LDAP",
+}
 
-	content, readErr := ioutil.ReadAll(httpResp.Body)
-	if readErr != nil {
-		return nil, readErr
-	}
+// how much of the message from the server goes in an alert summary
+const maxMessageLength = 300
 
-	var healthcheck HealthcheckResponse
-	unmarshalErr := json.Unmarshal(content, &healthcheck)
-	if unmarshalErr != nil {
-		return nil, unmarshalErr
+/**
+returns where the admin service is
+*/
+func (m VSHealthCheckMonitor) adminEndpoint() common.Endpoint {
+	if m.Admin.BaseUrl != "" {
+		return m.Admin
 	}
+	return common.HostEndpoint(m.VidispineHost, 9001, m.VidispineHttps)
+}
 
-	return &healthcheck, nil
+/**
+gets helthcheck data from the VS admin endpoint
+*/
+func (m VSHealthCheckMonitor) loadHealthcheck(admin common.Endpoint) (HealthcheckResponse, error) {
+	return vidispine.Client{Admin: admin}.Healthcheck(context.Background())
 }
 
 /**
@@ -69,6 +60,9 @@ func (m VSHealthCheckMonitor) validateHealthcheckEntry(name string, entry *Healt
 	}
 	if !entry.Healthy {
 		bodyText := fmt.Sprintf("The %s check failed at %s", name, entry.Timestamp.String())
+		if reason := failureReason(entry); reason != "" {
+			bodyText = fmt.Sprintf("%s: %s", bodyText, reason)
+		}
 		if verboseMode {
 			log.Printf("INFO (verbose) %s", bodyText)
 		}
@@ -87,51 +81,143 @@ func (m VSHealthCheckMonitor) validateHealthcheckEntry(name string, entry *Healt
 	}
 }
 
+/**
+checks how long a healthy entry took to run and how long ago it ran. A slow entry is an early warning of it
+failing, and one that hasn't run for a while suggests the healthcheck thread is stuck. An entry without a
+timestamp is not checked for staleness
+*/
+func (m VSHealthCheckMonitor) checkEntryTiming(name string, entry *HealthcheckEntry, thresholds TimingThresholds, now time.Time, verboseMode bool) []*pagerduty.TriggerEvent {
+	problems := make([]*pagerduty.TriggerEvent, 0)
+	key := fmt.Sprintf("vidispine-%s", strings.ToLower(name))
+
+	took := time.Duration(entry.Duration) * time.Millisecond
+	if verboseMode {
+		log.Printf("INFO (verbose) checkEntryTiming %s took %s and ran at %s", name, took, entry.Timestamp)
+	}
+	var severity pagerduty.Severity
+	var threshold time.Duration
+	switch {
+	case took > thresholds.DurationCritical.Duration:
+		severity, threshold = pagerduty.SeverityCritical, thresholds.DurationCritical.Duration
+	case took > thresholds.DurationWarning.Duration:
+		severity, threshold = pagerduty.SeverityWarning, thresholds.DurationWarning.Duration
+	}
+	if severity != "" {
+		log.Printf("WARNING the %s healthcheck took %s, alerting", name, took)
+		problems = append(problems, pagerduty.NewTriggerEvent(fmt.Sprintf("Vidispine %s", name),
+			m.PDServiceId,
+			severity,
+			key+"-slow",
+			fmt.Sprintf("The %s check took %s, over the %s threshold of %s", name, took, severity, threshold),
+			&now,
+		))
+	}
+
+	if !entry.Timestamp.IsZero() {
+		if age := now.Sub(entry.Timestamp); age > thresholds.MaxAge.Duration {
+			log.Printf("WARNING the %s healthcheck last ran %s ago, alerting", name, age.Round(time.Second))
+			problems = append(problems, pagerduty.NewTriggerEvent(fmt.Sprintf("Vidispine %s", name),
+				m.PDServiceId,
+				pagerduty.SeverityWarning,
+				key+"-stale",
+				fmt.Sprintf("The %s check last ran at %s, %s ago, the healthcheck may be stuck", name, entry.Timestamp.String(), age.Round(time.Second)),
+				&now,
+			))
+		}
+	}
+	return problems
+}
+
+/**
+returns what the server said about a failed entry, if anything
+*/
+func failureReason(entry *HealthcheckEntry) string {
+	reason := entry.Message
+	if entry.Error != nil {
+		errText := entry.Error.String()
+		switch {
+		case reason == "":
+			reason = errText
+		case errText != "" && !strings.Contains(errText, reason):
+			reason = fmt.Sprintf("%s (%s)", reason, errText)
+		}
+	}
+	if runes := []rune(reason); len(runes) > maxMessageLength {
+		reason = string(runes[:maxMessageLength]) + "..."
+	}
+	return reason
+}
+
+/**
+returns true if the entry with the given name should be alerted on, according to Include and Exclude
+*/
+func (m VSHealthCheckMonitor) wanted(name string) bool {
+	if len(m.Include) > 0 && !matchesAny(name, m.Include) {
+		return false
+	}
+	return !matchesAny(name, m.Exclude)
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name)); matched {
+			return true
+		}
+	}
+	return false
+}
+
 func (m VSHealthCheckMonitor) Name() string {
 	return "Vidispine basic health checks"
 }
 
+/**
+returns a copy of the check that reads the healthcheck of one node of a cluster
+*/
+func (m VSHealthCheckMonitor) ForNode(host string) common.MonitorComponent {
+	m.Admin = m.adminEndpoint().ForHost(host)
+	return m
+}
+
 /**
 runs the check on Vidispine health
 */
 func (m VSHealthCheckMonitor) Run(verboseMode bool) ([]*pagerduty.TriggerEvent, error) {
 	if verboseMode {
-		log.Printf("INFO (verbose) Checking %s on %s", m.Name(), m.VidispineHost)
+		log.Printf("INFO (verbose) Checking %s on %s", m.Name(), m.adminEndpoint().BaseUrl)
 	}
 
-	healthCheckResponse, err := m.loadHealthcheck(m.VidispineHost, m.VidispineHttps)
+	healthCheckResponse, err := m.loadHealthcheck(m.adminEndpoint())
 	if err != nil {
 		log.Print("ERROR vshealthcheck could not run: ", err)
-		bodyText := fmt.Sprint("vidispine healthcheck could not run: ", err.Error())
-		nowtime := time.Now()
 		return []*pagerduty.TriggerEvent{
-			pagerduty.NewTriggerEvent("vidispine-monitor", m.PDServiceId, pagerduty.SeverityError, "vshealthcheck", bodyText, &nowtime),
+			vidispine.ErrorAlert(err, "vidispine healthcheck", m.PDServiceId, "vshealthcheck"),
 		}, err
 	}
 
 	if verboseMode {
 		log.Printf("INFO (verbose) Got check results, evaluating...")
 	}
-	checkList := []*HealthcheckEntry{
-		&healthCheckResponse.Broker,
-		&healthCheckResponse.Database,
-		&healthCheckResponse.Deadlocks,
-		&healthCheckResponse.Elasticsearch,
-		&healthCheckResponse.Ldap,
-	}
-	checkNames := []string{
-		"Broker",
-		"Database",
-		"Deadlocks",
-		"Elasticsearch",
-		"LDAP",
-	}
-
+	now := time.Now()
 	errors := make([]*pagerduty.TriggerEvent, 0)
-	for i, check := range checkList {
-		problem := m.validateHealthcheckEntry(checkNames[i], check, verboseMode)
+	for _, name := range healthCheckResponse.Names() {
+		if !m.wanted(name) {
+			if verboseMode {
+				log.Printf("INFO (verbose) Skipping the %s healthcheck, it is not included", name)
+			}
+			continue
+		}
+		displayName, haveDisplayName := displayNames[name]
+		if !haveDisplayName {
+			displayName = name
+		}
+		entry := healthCheckResponse[name]
+		problem := m.validateHealthcheckEntry(displayName, &entry, verboseMode)
 		if problem != nil {
 			errors = append(errors, problem)
+		} else {
+			//a failed entry has already been alerted on, how long it took doesn't add anything
+			errors = append(errors, m.checkEntryTiming(displayName, &entry, m.Thresholds.For(name), now, verboseMode)...)
 		}
 	}
 
@@ -1,7 +1,10 @@
 package vshealthcheck
 
 import (
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
 	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -62,3 +65,137 @@ func TestVSHealthCheckMonitor_validateHealthcheckEntry_healthy(t *testing.T) {
 	}
 
 }
+
+func TestVSHealthCheckMonitor_Run_adminUrl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/vs-admin/healthcheck" {
+			t.Errorf("healthcheck was requested from unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("X-Auth-Token") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"broker": {"healthy": true}, "database": {"healthy": false, "timestamp": "2010-01-02T03:04:05Z"},
+			"deadlocks": {"healthy": true}, "elasticsearch": {"healthy": true}, "ldap": {"healthy": true}}`))
+	}))
+	defer server.Close()
+
+	m := VSHealthCheckMonitor{
+		Admin: common.Endpoint{
+			BaseUrl: server.URL + "/vs-admin/",
+			Headers: map[string]string{"X-Auth-Token": "token"},
+		},
+		PDServiceId: "someservice",
+	}
+	alerts, err := m.Run(false)
+	if err != nil {
+		t.Error("Run returned an unexpected error: ", err)
+		t.FailNow()
+	}
+	if len(alerts) != 1 || alerts[0].DeDupKey != "vidispine-database" {
+		t.Errorf("expected a single database alert, got %v", alerts)
+	}
+}
+
+func TestVSHealthCheckMonitor_Run_everyEntry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{
+			"database": {"healthy": true},
+			"ldap": {"healthy": false, "message": "Connection timed out"},
+			"transcoder-1": {"healthy": false, "message": "No response",
+				"error": {"type": "java.net.SocketTimeoutException", "message": "Read timed out", "stack": []}},
+			"transcoder-2": {"healthy": false, "error": {"type": "java.io.IOException", "message": "Broken pipe"}},
+			"solr": {"healthy": false}
+		}`))
+	}))
+	defer server.Close()
+
+	m := VSHealthCheckMonitor{Admin: common.Endpoint{BaseUrl: server.URL}, PDServiceId: "someservice"}
+	alerts, err := m.Run(false)
+	if err != nil {
+		t.Error("Run returned an unexpected error: ", err)
+		t.FailNow()
+	}
+	summaries := make(map[string]string)
+	for _, alert := range alerts {
+		summaries[alert.DeDupKey] = alert.Payload.Summary
+	}
+	expected := map[string]string{
+		"vidispine-ldap":         "The LDAP check failed at 0001-01-01 00:00:00 +0000 UTC: Connection timed out",
+		"vidispine-transcoder-1": "The transcoder-1 check failed at 0001-01-01 00:00:00 +0000 UTC: No response (java.net.SocketTimeoutException: Read timed out)",
+		"vidispine-transcoder-2": "The transcoder-2 check failed at 0001-01-01 00:00:00 +0000 UTC: java.io.IOException: Broken pipe",
+		"vidispine-solr":         "The solr check failed at 0001-01-01 00:00:00 +0000 UTC",
+	}
+	if len(summaries) != len(expected) {
+		t.Errorf("expected %d alerts, got %v", len(expected), summaries)
+	}
+	for key, summary := range expected {
+		if summaries[key] != summary {
+			t.Errorf("expected %s to say '%s', got '%s'", key, summary, summaries[key])
+		}
+	}
+
+	m.Include = []string{"transcoder-*", "solr"}
+	m.Exclude = []string{"Transcoder-2"}
+	filtered, _ := m.Run(false)
+	if len(filtered) != 2 || filtered[0].DeDupKey != "vidispine-solr" || filtered[1].DeDupKey != "vidispine-transcoder-1" {
+		t.Errorf("expected only the included entries, got %v", filtered)
+	}
+}
+
+func TestVSHealthCheckMonitor_checkEntryTiming(t *testing.T) {
+	m := VSHealthCheckMonitor{PDServiceId: "someservice"}
+	thresholds := Thresholds{
+		TimingThresholds: TimingThresholds{DurationWarning: common.Duration{Duration: 2 * time.Second}},
+		Entries: map[string]TimingThresholds{
+			"Elasticsearch": {DurationWarning: common.Duration{Duration: 10 * time.Second}, MaxAge: common.Duration{Duration: time.Hour}},
+		},
+	}
+	now, _ := time.Parse(time.RFC3339, "2021-03-11T12:00:00Z")
+	tests := []struct {
+		name     string
+		entry    HealthcheckEntry
+		expected map[string]pagerduty.Severity
+	}{
+		{"database", HealthcheckEntry{Healthy: true, Duration: 100, Timestamp: now}, map[string]pagerduty.Severity{}},
+		{"database", HealthcheckEntry{Healthy: true, Duration: 3000, Timestamp: now},
+			map[string]pagerduty.Severity{"vidispine-database-slow": pagerduty.SeverityWarning}},
+		{"database", HealthcheckEntry{Healthy: true, Duration: 25000, Timestamp: now.Add(-20 * time.Minute)},
+			map[string]pagerduty.Severity{"vidispine-database-slow": pagerduty.SeverityCritical, "vidispine-database-stale": pagerduty.SeverityWarning}},
+		{"elasticsearch", HealthcheckEntry{Healthy: true, Duration: 3000, Timestamp: now.Add(-20 * time.Minute)}, map[string]pagerduty.Severity{}},
+		{"ldap", HealthcheckEntry{Healthy: true}, map[string]pagerduty.Severity{}},
+	}
+	for i, test := range tests {
+		alerts := m.checkEntryTiming(test.name, &test.entry, thresholds.For(test.name), now, false)
+		if len(alerts) != len(test.expected) {
+			t.Errorf("test %d: expected %d alerts, got %v", i, len(test.expected), alerts)
+			continue
+		}
+		for _, alert := range alerts {
+			if severity, expected := test.expected[alert.DeDupKey]; !expected || alert.Payload.Severity != severity {
+				t.Errorf("test %d: got unexpected %s alert %s", i, alert.Payload.Severity, alert.DeDupKey)
+			}
+		}
+	}
+
+	slow := m.checkEntryTiming("Database", &HealthcheckEntry{Duration: 3000}, thresholds.For("database"), now, false)
+	if slow[0].Payload.Summary != "The Database check took 3s, over the warning threshold of 2s" {
+		t.Errorf("got unexpected summary '%s'", slow[0].Payload.Summary)
+	}
+}
+
+func TestThresholds_Validate(t *testing.T) {
+	if problems := DefaultThresholds.Validate(); len(problems) != 0 {
+		t.Errorf("the default thresholds should be valid, got %v", problems)
+	}
+	thresholds := Thresholds{
+		TimingThresholds: TimingThresholds{MaxAge: common.Duration{Duration: -time.Minute}},
+		Entries:          map[string]TimingThresholds{"database": {DurationWarning: common.Duration{Duration: time.Minute}}},
+	}
+	problems := thresholds.Validate()
+	if len(problems) != 2 || problems[0] != "max_age must not be negative" ||
+		problems[1] != "entries.database.duration_warning must not be higher than duration_critical" {
+		t.Errorf("got unexpected problems %v", problems)
+	}
+}
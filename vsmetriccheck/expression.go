@@ -0,0 +1,220 @@
+package vsmetriccheck
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/**
+expression is arithmetic over the named metrics of a rule, e.g. "(active + idle) / size". It supports numbers,
+names, + - * /, unary minus and brackets
+*/
+type expression interface {
+	eval(values map[string]float64) float64
+}
+
+type numberExpr float64
+
+func (n numberExpr) eval(map[string]float64) float64 {
+	return float64(n)
+}
+
+type nameExpr string
+
+func (n nameExpr) eval(values map[string]float64) float64 {
+	return values[string(n)]
+}
+
+type negateExpr struct {
+	operand expression
+}
+
+func (n negateExpr) eval(values map[string]float64) float64 {
+	return -n.operand.eval(values)
+}
+
+type binaryExpr struct {
+	op          byte
+	left, right expression
+}
+
+func (b binaryExpr) eval(values map[string]float64) float64 {
+	left, right := b.left.eval(values), b.right.eval(values)
+	switch b.op {
+	case '+':
+		return left + right
+	case '-':
+		return left - right
+	case '*':
+		return left * right
+	default:
+		return left / right
+	}
+}
+
+/**
+parses an expression, returning it along with the names it uses
+*/
+func parseExpression(text string) (expression, []string, error) {
+	p := &exprParser{text: text}
+	expr, err := p.parseSum()
+	if err != nil {
+		return nil, nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.text) {
+		return nil, nil, fmt.Errorf("unexpected '%c' at position %d", p.text[p.pos], p.pos+1)
+	}
+	return expr, p.names, nil
+}
+
+type exprParser struct {
+	text  string
+	pos   int
+	names []string
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.text) && unicode.IsSpace(rune(p.text[p.pos])) {
+		p.pos++
+	}
+}
+
+/**
+peeks at the next character, which is 0 at the end of the text
+*/
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.text) {
+		return 0
+	}
+	return p.text[p.pos]
+}
+
+// sum = product { ("+" | "-") product }
+func (p *exprParser) parseSum() (expression, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, rightErr := p.parseProduct()
+		if rightErr != nil {
+			return nil, rightErr
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// product = unary { ("*" | "/") unary }
+func (p *exprParser) parseProduct() (expression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		right, rightErr := p.parseUnary()
+		if rightErr != nil {
+			return nil, rightErr
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// unary = "-" unary | "(" sum ")" | number | name
+func (p *exprParser) parseUnary() (expression, error) {
+	next := p.peek()
+	switch {
+	case next == 0:
+		return nil, fmt.Errorf("expression ends too soon")
+	case next == '-':
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return negateExpr{operand: operand}, nil
+	case next == '(':
+		p.pos++
+		inner, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ')' at position %d", p.pos+1)
+		}
+		p.pos++
+		return inner, nil
+	case next == '.' || unicode.IsDigit(rune(next)):
+		start := p.pos
+		for p.pos < len(p.text) && (p.text[p.pos] == '.' || unicode.IsDigit(rune(p.text[p.pos]))) {
+			p.pos++
+		}
+		value, parseErr := strconv.ParseFloat(p.text[start:p.pos], 64)
+		if parseErr != nil {
+			return nil, fmt.Errorf("'%s' is not a number", p.text[start:p.pos])
+		}
+		return numberExpr(value), nil
+	case isNameChar(next, true):
+		start := p.pos
+		for p.pos < len(p.text) && isNameChar(p.text[p.pos], false) {
+			p.pos++
+		}
+		name := p.text[start:p.pos]
+		p.names = append(p.names, name)
+		return nameExpr(name), nil
+	default:
+		return nil, fmt.Errorf("unexpected '%c' at position %d", next, p.pos+1)
+	}
+}
+
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+/**
+comparison is a threshold such as "> 0.8" that a rule's value is compared against
+*/
+type comparison struct {
+	op        string
+	threshold float64
+}
+
+var comparisonOps = []string{">=", "<=", "==", "!=", ">", "<"}
+
+func parseComparison(text string) (comparison, error) {
+	text = strings.TrimSpace(text)
+	for _, op := range comparisonOps {
+		if strings.HasPrefix(text, op) {
+			threshold, parseErr := strconv.ParseFloat(strings.TrimSpace(text[len(op):]), 64)
+			if parseErr != nil {
+				return comparison{}, fmt.Errorf("'%s' does not compare against a number", text)
+			}
+			return comparison{op: op, threshold: threshold}, nil
+		}
+	}
+	return comparison{}, fmt.Errorf("'%s' must start with one of %s", text, strings.Join(comparisonOps, " "))
+}
+
+func (c comparison) matches(value float64) bool {
+	switch c.op {
+	case ">=":
+		return value >= c.threshold
+	case "<=":
+		return value <= c.threshold
+	case "==":
+		return value == c.threshold
+	case "!=":
+		return value != c.threshold
+	case ">":
+		return value > c.threshold
+	default:
+		return value < c.threshold
+	}
+}
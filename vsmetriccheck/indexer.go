@@ -0,0 +1,220 @@
+package vsmetriccheck
+
+import (
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vidispine"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+/**
+IndexerThresholds are the levels at which the Elasticsearch indexer raises alerts. Zero means the level from
+DefaultIndexerThresholds, except for stalled_polls, where it turns the stalled indexing alert off
+*/
+type IndexerThresholds struct {
+	FailedWarning  float64         `yaml:"failed_warning"`           //failed Elasticsearch requests as a fraction of indexer requests since the previous poll
+	FailedCritical float64         `yaml:"failed_critical"`          //as above
+	FailedMinimum  int             `yaml:"failed_minimum"`           //fewer failed requests than this since the previous poll never alert
+	StalledPolls   int             `yaml:"stalled_polls" zero:"off"` //how many polls in a row requests may come in for a type with nothing indexed
+	Percentile     string          `yaml:"percentile"`               //of the index and query timers, defaults to p99
+	IndexWarning   common.Duration `yaml:"index_warning"`            //indexer.<type>.index.time
+	IndexCritical  common.Duration `yaml:"index_critical"`           //as above
+	QueryWarning   common.Duration `yaml:"query_warning"`            //elasticsearch.query.time
+	QueryCritical  common.Duration `yaml:"query_critical"`           //as above
+}
+
+var DefaultIndexerThresholds = IndexerThresholds{
+	FailedWarning:  0.05,
+	FailedCritical: 0.25,
+	FailedMinimum:  5,
+	StalledPolls:   2,
+	Percentile:     DefaultPercentile,
+	IndexWarning:   common.Duration{Duration: 2 * time.Second},
+	IndexCritical:  common.Duration{Duration: 10 * time.Second},
+	QueryWarning:   common.Duration{Duration: 5 * time.Second},
+	QueryCritical:  common.Duration{Duration: 20 * time.Second},
+}
+
+/**
+returns the levels in effect, i.e. these with DefaultIndexerThresholds filling the gaps
+*/
+func (t IndexerThresholds) WithDefaults() IndexerThresholds {
+	common.FillDefaults(&t, DefaultIndexerThresholds)
+	return t
+}
+
+/**
+returns a description of each level that can't be used, including an unknown percentile
+*/
+func (t IndexerThresholds) Validate() []string {
+	problems := checkFractions(make([]string, 0),
+		namedValue{"failed_warning", t.FailedWarning},
+		namedValue{"failed_critical", t.FailedCritical},
+	)
+	problems = checkPolls(problems, "stalled_polls", t.StalledPolls, 1)
+	problems = checkDurations(problems,
+		namedDuration{"index_warning", t.IndexWarning},
+		namedDuration{"index_critical", t.IndexCritical},
+		namedDuration{"query_warning", t.QueryWarning},
+		namedDuration{"query_critical", t.QueryCritical},
+	)
+	problems = checkNotNegative(problems,
+		namedValue{"failed_minimum", float64(t.FailedMinimum)},
+		namedValue{"index_warning", float64(t.IndexWarning.Duration)},
+		namedValue{"index_critical", float64(t.IndexCritical.Duration)},
+		namedValue{"query_warning", float64(t.QueryWarning.Duration)},
+		namedValue{"query_critical", float64(t.QueryCritical.Duration)},
+	)
+
+	withDefaults := t.WithDefaults()
+	if _, known := (vidispine.MetricSnapshot{}).Percentile(strings.ToLower(withDefaults.Percentile)); !known {
+		problems = append(problems, fmt.Sprintf("percentile '%s' is not one of %s", t.Percentile, strings.Join(vidispine.Percentiles, ", ")))
+	}
+	problems = checkOrder(problems, namedValue{"failed_warning", withDefaults.FailedWarning}, namedValue{"failed_critical", withDefaults.FailedCritical})
+	problems = checkOrder(problems, namedValue{"index_warning", float64(withDefaults.IndexWarning.Duration)}, namedValue{"index_critical", float64(withDefaults.IndexCritical.Duration)})
+	return checkOrder(problems, namedValue{"query_warning", float64(withDefaults.QueryWarning.Duration)}, namedValue{"query_critical", float64(withDefaults.QueryCritical.Duration)})
+}
+
+/**
+returns the types of thing the indexer has an indexer.<type>.requests meter for, e.g. item or collection
+*/
+func indexerTypes(metrics *MetricsResponse) []string {
+	types := make([]string, 0)
+	for key := range metrics.Meters {
+		if strings.HasPrefix(key, "indexer.") && strings.HasSuffix(key, ".requests") {
+			types = append(types, strings.TrimSuffix(strings.TrimPrefix(key, "indexer."), ".requests"))
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+func meterCount(name string) func(metrics *MetricsResponse) (float64, error) {
+	return func(metrics *MetricsResponse) (float64, error) {
+		return metricSelector{section: "meter", name: name, field: "count"}.read(metrics, name)
+	}
+}
+
+func timerCount(name string) func(metrics *MetricsResponse) (float64, error) {
+	return func(metrics *MetricsResponse) (float64, error) {
+		return metricSelector{section: "timer", name: name, field: "count"}.read(metrics, name)
+	}
+}
+
+/**
+returns how much the series rose from its first value to its last, or false if it went down on the way, e.g.
+because Vidispine restarted
+*/
+func steadyRise(series []float64) (float64, bool) {
+	for i := 1; i < len(series); i++ {
+		if series[i] < series[i-1] {
+			return 0, false
+		}
+	}
+	return series[len(series)-1] - series[0], true
+}
+
+/**
+returns PD events if too many Elasticsearch requests from the indexer failed since the previous poll, if requests
+to index a type of thing keep coming in with nothing being indexed, or if indexing or searching is slow. Any of
+these leave search results stale while the Elasticsearch healthcheck entry still looks healthy
+*/
+func (m VSMetricCheck) CheckIndexer(samples Samples, verboseMode bool) []*pagerduty.TriggerEvent {
+	alerts := make([]*pagerduty.TriggerEvent, 0)
+	thresholds := m.IndexerThresholds.WithDefaults()
+	metrics := samples.Current.Metrics
+	types := indexerTypes(metrics)
+	if len(types) == 0 {
+		log.Print("WARNING metrics response has no indexer.*.requests meters, can't check the indexer")
+		return alerts
+	}
+
+	if failedAlert := m.checkIndexerFailures(samples, types, thresholds, verboseMode); failedAlert != nil {
+		alerts = append(alerts, failedAlert)
+	}
+
+	for _, indexType := range types {
+		requests, haveRequests := lastPolls(samples.Series(meterCount("indexer."+indexType+".requests")), thresholds.StalledPolls)
+		indexed, haveIndexed := lastPolls(samples.Series(timerCount("indexer."+indexType+".index.time")), thresholds.StalledPolls)
+		if !haveRequests || !haveIndexed {
+			continue
+		}
+		requested, requestsSteady := steadyRise(requests)
+		done, indexedSteady := steadyRise(indexed)
+		if !requestsSteady || !indexedSteady {
+			continue
+		}
+		if verboseMode {
+			log.Printf("INFO (verbose) vsmetriccheck.CheckIndexer %.0f %s requests and %.0f indexed over the last %d polls", requested, indexType, done, thresholds.StalledPolls)
+		}
+		if requested > 0 && done == 0 {
+			nowTime := time.Now()
+			log.Printf("WARNING %.0f %s indexer requests and nothing indexed over the last %d polls, alerting", requested, indexType, thresholds.StalledPolls)
+			alerts = append(alerts, pagerduty.NewTriggerEvent("vidispine-indexer",
+				m.IntegrationKey,
+				pagerduty.SeverityError,
+				"vidispine-indexer-stalled-"+indexType,
+				fmt.Sprintf("Vidispine received %.0f requests to index %s over the last %d polls but indexed nothing, search results are going stale", requested, indexType, thresholds.StalledPolls),
+				&nowTime))
+		}
+	}
+
+	for _, indexType := range types {
+		timer := "indexer." + indexType + ".index.time"
+		if _, haveTimer := metrics.Timers[timer]; !haveTimer {
+			continue
+		}
+		threshold := TimerThreshold{Timer: timer, Percentile: thresholds.Percentile, Warning: thresholds.IndexWarning, Critical: thresholds.IndexCritical}
+		if alert := m.checkTimer(metrics, threshold, "vidispine-indexer", "vidispine-indexer-slow-"+indexType, verboseMode); alert != nil {
+			alerts = append(alerts, alert)
+		}
+	}
+	query := TimerThreshold{Timer: "elasticsearch.query.time", Percentile: thresholds.Percentile, Warning: thresholds.QueryWarning, Critical: thresholds.QueryCritical}
+	if alert := m.checkTimer(metrics, query, "vidispine-indexer", "vidispine-elasticsearch-query-slow", verboseMode); alert != nil {
+		alerts = append(alerts, alert)
+	}
+	return alerts
+}
+
+func (m VSMetricCheck) checkIndexerFailures(samples Samples, types []string, thresholds IndexerThresholds, verboseMode bool) *pagerduty.TriggerEvent {
+	failed, failedErr := samples.MeterDelta("indexer.elasticsearch.request.failed")
+	if failedErr != nil {
+		if failedErr != ErrNoPreviousSample {
+			log.Printf("WARNING can't check failed indexer requests: %s", failedErr)
+		}
+		return nil
+	}
+	var requests float64
+	for _, indexType := range types {
+		if delta, deltaErr := samples.MeterDelta("indexer." + indexType + ".requests"); deltaErr == nil {
+			requests += delta.Change
+		}
+	}
+	if failed.Change == 0 {
+		return nil
+	}
+	if verboseMode {
+		log.Printf("INFO (verbose) vsmetriccheck.CheckIndexer %.0f failed Elasticsearch requests for %.0f indexer requests in the last %s", failed.Change, requests, failed.Interval)
+	}
+	//a few failures in a quiet interval would look like a high failure rate, so they aren't counted
+	if requests == 0 || failed.Change < float64(thresholds.FailedMinimum) {
+		return nil
+	}
+
+	severity := levelSeverity(failed.Change/requests, thresholds.FailedWarning, thresholds.FailedCritical)
+	if severity == "" {
+		return nil
+	}
+	nowTime := time.Now()
+	log.Printf("WARNING %.0f failed Elasticsearch requests for %.0f indexer requests, alerting", failed.Change, requests)
+	return pagerduty.NewTriggerEvent("vidispine-indexer",
+		m.IntegrationKey,
+		severity,
+		"vidispine-indexer-failed",
+		fmt.Sprintf("%.0f Elasticsearch requests from the indexer failed in the last %s, against %.0f indexing requests, search results will be missing changes", failed.Change, failed.Interval.Round(time.Second), requests),
+		&nowTime)
+}
@@ -0,0 +1,146 @@
+package vsmetriccheck
+
+import (
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+	"log"
+	"time"
+)
+
+/**
+JettyThresholds are the levels at which the Jetty thread pools that handle requests, and the requests in progress,
+raise alerts. The utilization and queue levels are fractions between 0 and 1. Leaving a level or the pools out
+uses the one in DefaultJettyThresholds
+*/
+type JettyThresholds struct {
+	Pools               []string `yaml:"pools"`                //QueuedThreadPool names to check, dw is the API and dw-admin the admin service
+	UtilizationWarning  float64  `yaml:"utilization_warning"`  //busy threads as a fraction of the most the pool can grow to (utilization-max)
+	UtilizationCritical float64  `yaml:"utilization_critical"` //as above
+	QueueWarning        float64  `yaml:"queue_warning"`        //jobs waiting for a thread as a fraction of the queue's capacity (jobs-queue-utilization)
+	QueueCritical       float64  `yaml:"queue_critical"`       //as above
+	ActiveRequests      int      `yaml:"active_requests"`      //MutableServletContextHandler.active-requests
+	SuspendedRequests   int      `yaml:"suspended_requests"`   //MutableServletContextHandler.active-suspended, async requests waiting to resume
+}
+
+var DefaultJettyThresholds = JettyThresholds{
+	Pools:               []string{"dw", "dw-admin"},
+	UtilizationWarning:  0.8,
+	UtilizationCritical: 0.95,
+	QueueWarning:        0.1,
+	QueueCritical:       0.5,
+	ActiveRequests:      500,
+	SuspendedRequests:   100,
+}
+
+/**
+returns the pools and levels to check, using the defaults for whatever was not configured
+*/
+func (t JettyThresholds) WithDefaults() JettyThresholds {
+	common.FillDefaults(&t, DefaultJettyThresholds)
+	return t
+}
+
+/**
+returns a description of every problem with the pools and levels, or an empty list if there are none
+*/
+func (t JettyThresholds) Validate() []string {
+	problems := checkFractions(make([]string, 0),
+		namedValue{"utilization_warning", t.UtilizationWarning},
+		namedValue{"utilization_critical", t.UtilizationCritical},
+		namedValue{"queue_warning", t.QueueWarning},
+		namedValue{"queue_critical", t.QueueCritical},
+	)
+	problems = checkNotNegative(problems,
+		namedValue{"active_requests", float64(t.ActiveRequests)},
+		namedValue{"suspended_requests", float64(t.SuspendedRequests)},
+	)
+	for i, pool := range t.Pools {
+		if pool == "" {
+			problems = append(problems, fmt.Sprintf("pools[%d] must not be empty", i))
+		}
+	}
+
+	withDefaults := t.WithDefaults()
+	problems = checkOrder(problems, namedValue{"utilization_warning", withDefaults.UtilizationWarning}, namedValue{"utilization_critical", withDefaults.UtilizationCritical})
+	return checkOrder(problems, namedValue{"queue_warning", withDefaults.QueueWarning}, namedValue{"queue_critical", withDefaults.QueueCritical})
+}
+
+/**
+returns PD events for each Jetty thread pool that is close to running out of threads or has requests queueing for
+one, and for too many requests in progress. Requests start timing out once the dw pool is exhausted, which is
+usually well before the heap or database pool look unhealthy
+*/
+func (m VSMetricCheck) CheckJetty(metrics *MetricsResponse, verboseMode bool) []*pagerduty.TriggerEvent {
+	alerts := make([]*pagerduty.TriggerEvent, 0)
+	thresholds := m.JettyThresholds.WithDefaults()
+
+	for _, pool := range thresholds.Pools {
+		prefix := "org.eclipse.jetty.util.thread.QueuedThreadPool." + pool + "."
+		utilization, utilizationErr := gaugeValue(metrics, prefix+"utilization-max")
+		if utilizationErr != nil {
+			log.Printf("WARNING metrics response was missing %sutilization-max, can't check the %s thread pool", prefix, pool)
+			continue
+		}
+		queue, queueErr := gaugeValue(metrics, prefix+"jobs-queue-utilization")
+		queued, _ := gaugeValue(metrics, prefix+"jobs")
+		if verboseMode {
+			log.Printf("INFO (verbose) vsmetriccheck.CheckJetty %s pool is %s utilised with %.0f jobs queued", pool, percent(utilization), queued)
+		}
+
+		if severity := levelSeverity(utilization, thresholds.UtilizationWarning, thresholds.UtilizationCritical); severity != "" {
+			nowTime := time.Now()
+			log.Printf("WARNING %s thread pool is %s utilised, alerting", pool, percent(utilization))
+			alerts = append(alerts, pagerduty.NewTriggerEvent("vidispine-jetty",
+				m.IntegrationKey,
+				severity,
+				"vidispine-jetty-"+pool,
+				fmt.Sprintf("Vidispine's %s request thread pool is %s utilised, requests will start timing out when it runs out", pool, percent(utilization)),
+				&nowTime))
+		}
+
+		if queueErr == nil {
+			if severity := levelSeverity(queue, thresholds.QueueWarning, thresholds.QueueCritical); severity != "" {
+				nowTime := time.Now()
+				log.Printf("WARNING %s thread pool queue is %s full, alerting", pool, percent(queue))
+				alerts = append(alerts, pagerduty.NewTriggerEvent("vidispine-jetty",
+					m.IntegrationKey,
+					severity,
+					"vidispine-jetty-"+pool+"-queue",
+					fmt.Sprintf("%.0f requests are queued waiting for a %s thread, the queue is %s full", queued, pool, percent(queue)),
+					&nowTime))
+			}
+		}
+	}
+
+	requestCounts := []struct {
+		name      string
+		limit     int
+		dedupKey  string
+		describes string
+	}{
+		{"active-requests", thresholds.ActiveRequests, "vidispine-jetty-requests", "requests in progress"},
+		{"active-suspended", thresholds.SuspendedRequests, "vidispine-jetty-suspended", "suspended requests waiting to resume"},
+	}
+	for _, requests := range requestCounts {
+		counter, haveCounter := metrics.Counters["io.dropwizard.jetty.MutableServletContextHandler."+requests.name]
+		if !haveCounter {
+			log.Printf("WARNING metrics response was missing MutableServletContextHandler.%s, can't check it", requests.name)
+			continue
+		}
+		if verboseMode {
+			log.Printf("INFO (verbose) vsmetriccheck.CheckJetty %d %s", counter.Count, requests.describes)
+		}
+		if counter.Count > int64(requests.limit) {
+			nowTime := time.Now()
+			log.Printf("WARNING %d %s, alerting", counter.Count, requests.describes)
+			alerts = append(alerts, pagerduty.NewTriggerEvent("vidispine-jetty",
+				m.IntegrationKey,
+				pagerduty.SeverityWarning,
+				requests.dedupKey,
+				fmt.Sprintf("Vidispine has %d %s, over the limit of %d", counter.Count, requests.describes, requests.limit),
+				&nowTime))
+		}
+	}
+	return alerts
+}
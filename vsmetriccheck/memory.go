@@ -0,0 +1,286 @@
+package vsmetriccheck
+
+import (
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+/**
+MemoryThresholds are the levels at which garbage collection and the memory pools raise alerts, mostly as fractions
+between 0 and 1. Unset levels are taken from DefaultMemoryThresholds; setting leak_window to zero turns off the
+alerts on memory trending up
+*/
+type MemoryThresholds struct {
+	GCTimeWarning          float64 `yaml:"gc_time_warning"`          //fraction of the time since the previous poll spent in GC
+	GCTimeCritical         float64 `yaml:"gc_time_critical"`         //as above
+	OldGenWarning          float64 `yaml:"old_gen_warning"`          //old generation used after GC, as a fraction of its max
+	OldGenCritical         float64 `yaml:"old_gen_critical"`         //as above
+	LeakGrowthWarning      float64 `yaml:"leak_growth_warning"`      //how far old generation used after GC may trend up over leak_window, as a fraction of its max
+	LeakWindow             int     `yaml:"leak_window" zero:"off"`   //how many polls the leak and Metaspace growth trends are measured over
+	MetaspaceWarning       float64 `yaml:"metaspace_warning"`        //Metaspace used as a fraction of its max, if it has one
+	MetaspaceGrowthWarning float64 `yaml:"metaspace_growth_warning"` //how far Metaspace used may trend up over leak_window, as a fraction of where it started
+}
+
+var DefaultMemoryThresholds = MemoryThresholds{
+	GCTimeWarning:          0.1,
+	GCTimeCritical:         0.3,
+	OldGenWarning:          0.8,
+	OldGenCritical:         0.9,
+	LeakGrowthWarning:      0.1,
+	LeakWindow:             DefaultHistory,
+	MetaspaceWarning:       0.9,
+	MetaspaceGrowthWarning: 0.2,
+}
+
+/**
+returns a copy of the levels with DefaultMemoryThresholds standing in for any that were not set
+*/
+func (t MemoryThresholds) WithDefaults() MemoryThresholds {
+	common.FillDefaults(&t, DefaultMemoryThresholds)
+	return t
+}
+
+/**
+returns a description of every level that is out of range or in the wrong order
+*/
+func (t MemoryThresholds) Validate() []string {
+	problems := checkFractions(make([]string, 0),
+		namedValue{"gc_time_warning", t.GCTimeWarning},
+		namedValue{"gc_time_critical", t.GCTimeCritical},
+		namedValue{"old_gen_warning", t.OldGenWarning},
+		namedValue{"old_gen_critical", t.OldGenCritical},
+		namedValue{"leak_growth_warning", t.LeakGrowthWarning},
+		namedValue{"metaspace_warning", t.MetaspaceWarning},
+	)
+	problems = checkNotNegative(problems, namedValue{"metaspace_growth_warning", t.MetaspaceGrowthWarning})
+	//a trend needs at least two polls to compare
+	problems = checkPolls(problems, "leak_window", t.LeakWindow, 2)
+
+	withDefaults := t.WithDefaults()
+	problems = checkOrder(problems, namedValue{"gc_time_warning", withDefaults.GCTimeWarning}, namedValue{"gc_time_critical", withDefaults.GCTimeCritical})
+	return checkOrder(problems, namedValue{"old_gen_warning", withDefaults.OldGenWarning}, namedValue{"old_gen_critical", withDefaults.OldGenCritical})
+}
+
+func gaugeValue(metrics *MetricsResponse, name string) (float64, error) {
+	return metricSelector{section: "gauge", name: name, field: "value"}.read(metrics, name)
+}
+
+/**
+returns the names of the collectors with a jvm.gc.<name>.time gauge, e.g. G1-Old-Generation
+*/
+func garbageCollectors(metrics *MetricsResponse) []string {
+	names := make([]string, 0)
+	for key := range metrics.Gauges {
+		if strings.HasPrefix(key, "jvm.gc.") && strings.HasSuffix(key, ".time") {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(key, "jvm.gc."), ".time"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+/**
+returns the name of the old generation memory pool, whose used-after-gc is what the application really keeps
+hold of. It depends on the collector, e.g. Tenured-Gen, G1-Old-Gen or PS-Old-Gen
+*/
+func oldGenPool(metrics *MetricsResponse) (string, bool) {
+	for key := range metrics.Gauges {
+		if !strings.HasPrefix(key, "jvm.memory.pools.") || !strings.HasSuffix(key, ".used-after-gc") {
+			continue
+		}
+		pool := strings.TrimSuffix(strings.TrimPrefix(key, "jvm.memory.pools."), ".used-after-gc")
+		if strings.HasSuffix(pool, "Old-Gen") || pool == "Tenured-Gen" {
+			return pool, true
+		}
+	}
+	return "", false
+}
+
+/**
+returns how far the line of best fit through the series rises from its first value to its last, so a single
+spike or a full collection part way through does not decide the trend on its own
+*/
+func trendRise(series []float64) float64 {
+	n := float64(len(series))
+	if n < 2 {
+		return 0
+	}
+	var sumX, sumY, sumXY, sumXX float64
+	for i, y := range series {
+		x := float64(i)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	slope := (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+	return slope * (n - 1)
+}
+
+/**
+returns the last window+1 values of the series, or false if it is not that long yet
+*/
+func lastPolls(series []float64, window int) ([]float64, bool) {
+	if window <= 0 || len(series) < window+1 {
+		return nil, false
+	}
+	return series[len(series)-window-1:], true
+}
+
+/**
+returns a PD event if the JVM spent too much of the time since the previous poll in garbage collection, which is
+what makes Vidispine stall when it is short of memory
+*/
+func (m VSMetricCheck) CheckGCTime(samples Samples, verboseMode bool) *pagerduty.TriggerEvent {
+	thresholds := m.MemoryThresholds.WithDefaults()
+	var gcTime, interval time.Duration
+	breakdown := make([]string, 0)
+	for _, collector := range garbageCollectors(samples.Current.Metrics) {
+		delta, deltaErr := samples.GaugeDelta("jvm.gc." + collector + ".time")
+		if deltaErr != nil {
+			if deltaErr != ErrNoPreviousSample {
+				log.Printf("WARNING can't read GC time of %s: %s", collector, deltaErr)
+			}
+			continue
+		}
+		took := time.Duration(delta.Change) * time.Millisecond
+		gcTime += took
+		interval = delta.Interval
+		if took > 0 {
+			breakdown = append(breakdown, fmt.Sprintf("%s %s", collector, took))
+		}
+	}
+	if interval <= 0 {
+		return nil
+	}
+
+	fraction := gcTime.Seconds() / interval.Seconds()
+	if verboseMode {
+		log.Printf("INFO (verbose) vsmetriccheck.CheckGCTime JVM spent %s of the last %s in GC", percent(fraction), interval)
+	}
+	severity := levelSeverity(fraction, thresholds.GCTimeWarning, thresholds.GCTimeCritical)
+	if severity == "" {
+		return nil
+	}
+	nowTime := time.Now()
+	log.Printf("WARNING JVM spent %s of the last %s in GC, alerting", percent(fraction), interval)
+	return pagerduty.NewTriggerEvent("vidispine-gc",
+		m.IntegrationKey,
+		severity,
+		"vidispine-gc-time",
+		fmt.Sprintf("Vidispine spent %s of the last %s in garbage collection (%s), it is short of heap and will be slow to respond", percent(fraction), interval.Round(time.Second), strings.Join(breakdown, ", ")),
+		&nowTime)
+}
+
+/**
+returns PD events if the old generation is too full straight after a collection, which unlike heap usage does not
+swing up and down between collections, or if what is left after each collection keeps going up, which is a leak
+*/
+func (m VSMetricCheck) CheckOldGen(samples Samples, verboseMode bool) []*pagerduty.TriggerEvent {
+	alerts := make([]*pagerduty.TriggerEvent, 0)
+	thresholds := m.MemoryThresholds.WithDefaults()
+	metrics := samples.Current.Metrics
+	pool, havePool := oldGenPool(metrics)
+	if !havePool {
+		log.Print("WARNING metrics response has no old generation used-after-gc gauge, can't check for memory leaks")
+		return alerts
+	}
+	afterGCKey := "jvm.memory.pools." + pool + ".used-after-gc"
+	afterGC, _ := gaugeValue(metrics, afterGCKey)
+	maxSize, maxErr := gaugeValue(metrics, "jvm.memory.pools."+pool+".max")
+	if maxErr != nil || maxSize <= 0 {
+		log.Printf("WARNING %s has no maximum size, can't check it", pool)
+		return alerts
+	}
+	if verboseMode {
+		log.Printf("INFO (verbose) vsmetriccheck.CheckOldGen %s is %s full after GC", pool, percent(afterGC/maxSize))
+	}
+
+	if severity := levelSeverity(afterGC/maxSize, thresholds.OldGenWarning, thresholds.OldGenCritical); severity != "" {
+		nowTime := time.Now()
+		log.Printf("WARNING %s is %s full after GC, alerting", pool, percent(afterGC/maxSize))
+		alerts = append(alerts, pagerduty.NewTriggerEvent("vidispine-heap",
+			m.IntegrationKey,
+			severity,
+			"vidispine-old-gen",
+			fmt.Sprintf("Vidispine's old generation (%s) is still %s full after garbage collection, RAM allocation needs re-assessing", pool, percent(afterGC/maxSize)),
+			&nowTime))
+	}
+
+	series := samples.Series(func(metrics *MetricsResponse) (float64, error) {
+		return gaugeValue(metrics, afterGCKey)
+	})
+	if window, haveWindow := lastPolls(series, thresholds.LeakWindow); haveWindow {
+		rise := trendRise(window)
+		if verboseMode {
+			log.Printf("INFO (verbose) vsmetriccheck.CheckOldGen %s after GC has trended %s of its max over %d polls", pool, percent(rise/maxSize), thresholds.LeakWindow)
+		}
+		if rise > thresholds.LeakGrowthWarning*maxSize {
+			nowTime := time.Now()
+			log.Printf("WARNING %s after GC has trended up by %s over %d polls, alerting", pool, percent(rise/maxSize), thresholds.LeakWindow)
+			alerts = append(alerts, pagerduty.NewTriggerEvent("vidispine-heap",
+				m.IntegrationKey,
+				pagerduty.SeverityWarning,
+				"vidispine-memory-leak",
+				fmt.Sprintf("Memory left in use after garbage collection (%s) has trended up by %s of its max over the last %d polls, Vidispine may be leaking memory", pool, percent(rise/maxSize), thresholds.LeakWindow),
+				&nowTime))
+		}
+	}
+	return alerts
+}
+
+/**
+returns PD events if Metaspace, where the JVM keeps loaded classes, is nearly full or keeps growing. It usually
+has no maximum, in which case only growth is checked
+*/
+func (m VSMetricCheck) CheckMetaspace(samples Samples, verboseMode bool) []*pagerduty.TriggerEvent {
+	alerts := make([]*pagerduty.TriggerEvent, 0)
+	thresholds := m.MemoryThresholds.WithDefaults()
+	metrics := samples.Current.Metrics
+	used, usedErr := gaugeValue(metrics, "jvm.memory.pools.Metaspace.used")
+	if usedErr != nil {
+		log.Print("WARNING jvm.memory.pools.Metaspace.used is not present in metric gauges, can't check Metaspace")
+		return alerts
+	}
+	if maxSize, maxErr := gaugeValue(metrics, "jvm.memory.pools.Metaspace.max"); maxErr == nil && maxSize > 0 {
+		if verboseMode {
+			log.Printf("INFO (verbose) vsmetriccheck.CheckMetaspace Metaspace is %s full", percent(used/maxSize))
+		}
+		if used > thresholds.MetaspaceWarning*maxSize {
+			nowTime := time.Now()
+			log.Printf("WARNING Metaspace is %s full, alerting", percent(used/maxSize))
+			alerts = append(alerts, pagerduty.NewTriggerEvent("vidispine-metaspace",
+				m.IntegrationKey,
+				pagerduty.SeverityWarning,
+				"vidispine-metaspace",
+				fmt.Sprintf("Vidispine's Metaspace is %s full, it will fail to load classes when it runs out", percent(used/maxSize)),
+				&nowTime))
+		}
+	}
+
+	series := samples.Series(func(metrics *MetricsResponse) (float64, error) {
+		return gaugeValue(metrics, "jvm.memory.pools.Metaspace.used")
+	})
+	if window, haveWindow := lastPolls(series, thresholds.LeakWindow); haveWindow && window[0] > 0 {
+		growth := trendRise(window) / window[0]
+		if verboseMode {
+			log.Printf("INFO (verbose) vsmetriccheck.CheckMetaspace Metaspace has trended %s over %d polls", percent(growth), thresholds.LeakWindow)
+		}
+		if growth > thresholds.MetaspaceGrowthWarning {
+			nowTime := time.Now()
+			log.Printf("WARNING Metaspace has grown %s over %d polls, alerting", percent(growth), thresholds.LeakWindow)
+			alerts = append(alerts, pagerduty.NewTriggerEvent("vidispine-metaspace",
+				m.IntegrationKey,
+				pagerduty.SeverityWarning,
+				"vidispine-metaspace-growth",
+				fmt.Sprintf("Vidispine's Metaspace has trended up by %s over the last %d polls, classes may be leaking, e.g. from redeployed plugins", percent(growth), thresholds.LeakWindow),
+				&nowTime))
+		}
+	}
+	return alerts
+}
@@ -54,4 +54,18 @@ func TestParseMetrics(t *testing.T) {
 			}
 		}
 	}
+
+	queryTime, haveQueryTime := parsed.Timers["elasticsearch.query.time"]
+	if !haveQueryTime {
+		t.Error("Expected timers to have elasticsearch.query.time")
+	} else {
+		if queryTime.Count != 2 || queryTime.DurationUnits != "seconds" || queryTime.RateUnits != "calls/second" {
+			t.Errorf("Got unexpected elasticsearch.query.time timer %v", queryTime)
+		}
+		p99, _ := queryTime.Percentile("p99")
+		took, durationErr := queryTime.Duration(p99)
+		if durationErr != nil || took.Milliseconds() != 366 {
+			t.Errorf("Expected the p99 of elasticsearch.query.time to be 366ms, got %s (%v)", took, durationErr)
+		}
+	}
 }
@@ -0,0 +1,616 @@
+package vsmetriccheck
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+/**
+Rule is a metric alert defined in the configuration rather than in code. It reads one or more metrics into
+named values, works out a value from them and compares that against the warning and critical thresholds:
+
+	name: database-pool-active
+	metrics:
+	  active: gauge:io.dropwizard.db.ManagedPooledDataSource.*.active
+	  size: gauge:io.dropwizard.db.ManagedPooledDataSource.*.size
+	value: active / size
+	critical: "> 0.9"
+	message: "Active connections to {{.Match}} are at {{percent .Value}} of the pool"
+
+Metrics are given as section:name:field, where the field is optional. The delta field is how much a counter
+(or the count of a meter, histogram or timer, or a gauge that only goes up) rose since the previous poll, and
+rate is that per second. A name can contain one *, in which case
+the rule is evaluated for every match that all of its metrics have, and the matched text is available to the
+templates as .Match
+*/
+type Rule struct {
+	Name      string            `yaml:"name"`
+	Metrics   map[string]string `yaml:"metrics"`   //name to use in value -> the metric to read
+	Value     string            `yaml:"value"`     //arithmetic over the metrics, defaults to the metric if there is only one
+	Warning   string            `yaml:"warning"`   //e.g. "> 0.8"
+	Critical  string            `yaml:"critical"`  //e.g. ">= 0.9"
+	DedupKey  string            `yaml:"dedup_key"` //template, defaults to vidispine-rule-<name>, with -<match> for patterns
+	Message   string            `yaml:"message"`   //template for the alert summary
+	Component string            `yaml:"component"` //defaults to vidispine-metrics
+}
+
+const (
+	defaultRuleDedupKey  = "vidispine-rule-{{.Rule}}{{if .Match}}-{{.Match}}{{end}}"
+	defaultRuleMessage   = "{{.Rule}}{{if .Match}} for {{.Match}}{{end}} is {{.Value}}, which is {{.Threshold}}"
+	defaultRuleComponent = "vidispine-metrics"
+)
+
+var (
+	ruleNamePattern  = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+	valueNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+/**
+what the dedup key and message templates can use
+*/
+type RuleAlertData struct {
+	Rule      string
+	Match     string             //the text matched by the * in the metric names, if there is one
+	Value     float64            //the rule's value
+	Threshold string             //the comparison that the value met, e.g. "> 0.9"
+	Severity  pagerduty.Severity //warning or critical
+	Metrics   map[string]float64 //the value of each named metric
+	Interval  time.Duration      //how long it has been since the previous poll, which delta fields are over
+}
+
+var ruleTemplateFuncs = template.FuncMap{
+	"percent": percent,
+	"bytes": func(value float64) string {
+		return common.FormatBytes(int64(value))
+	},
+	"duration": func(seconds float64) string {
+		return time.Duration(seconds * float64(time.Second)).String()
+	},
+}
+
+// the sections of the metrics that can be read, and the field read if none is given
+var ruleSections = map[string]string{
+	"gauge":     "value",
+	"counter":   "count",
+	"meter":     "m1_rate",
+	"histogram": "p99",
+	"timer":     "p99",
+}
+
+var rateFields = []string{"m1_rate", "m5_rate", "m15_rate", "mean_rate"}
+
+// the field of each section that only goes up, which the delta and rate fields are worked out from
+var cumulativeFields = map[string]string{
+	"gauge":     "value",
+	"counter":   "count",
+	"meter":     "count",
+	"histogram": "count",
+	"timer":     "count",
+}
+
+/**
+delta is how much the metric went up since the last poll, and rate is that per second
+*/
+func isDeltaField(field string) bool {
+	return field == "delta" || field == "rate"
+}
+
+/**
+metricSelector is a parsed metric reference such as timer:elasticsearch.query.time:p95
+*/
+type metricSelector struct {
+	section string
+	name    string
+	field   string
+}
+
+// what the part of a selector after its last colon must look like to be taken as the field
+var fieldPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+/**
+parses a selector such as timer:elasticsearch.query.time:p95. Metric names can contain colons, so the part after
+the last colon is only the field if it looks like one; a name whose last part is a plain word needs the field
+given as well, e.g. gauge:cache:hits:value
+*/
+func parseSelector(text string) (metricSelector, error) {
+	parts := strings.SplitN(text, ":", 2)
+	if len(parts) < 2 || parts[1] == "" {
+		return metricSelector{}, fmt.Errorf("'%s' must look like section:name or section:name:field", text)
+	}
+	defaultField, knownSection := ruleSections[parts[0]]
+	if !knownSection {
+		return metricSelector{}, fmt.Errorf("'%s' is not one of gauge, counter, meter, histogram or timer", parts[0])
+	}
+	s := metricSelector{section: parts[0], name: parts[1], field: defaultField}
+	if colon := strings.LastIndex(s.name, ":"); colon != -1 && fieldPattern.MatchString(s.name[colon+1:]) {
+		s.name, s.field = s.name[:colon], strings.ToLower(s.name[colon+1:])
+	}
+	if s.name == "" {
+		return metricSelector{}, fmt.Errorf("'%s' must look like section:name or section:name:field", text)
+	}
+	if strings.Count(s.name, "*") > 1 {
+		return metricSelector{}, fmt.Errorf("'%s' can only contain one *", s.name)
+	}
+	check := s
+	if isDeltaField(s.field) {
+		check.field = cumulativeFields[s.section]
+	}
+	if _, fieldErr := check.read(nil, ""); fieldErr != nil {
+		return metricSelector{}, fieldErr
+	}
+	return s, nil
+}
+
+func (s metricSelector) isPattern() bool {
+	return strings.Contains(s.name, "*")
+}
+
+/**
+returns what the * in the selector's name matched in key, or false if key doesn't match
+*/
+func (s metricSelector) match(key string) (string, bool) {
+	star := strings.Index(s.name, "*")
+	if star == -1 {
+		return "", key == s.name
+	}
+	prefix, suffix := s.name[:star], s.name[star+1:]
+	if len(key) <= len(prefix)+len(suffix) || !strings.HasPrefix(key, prefix) || !strings.HasSuffix(key, suffix) {
+		return "", false
+	}
+	return key[len(prefix) : len(key)-len(suffix)], true
+}
+
+/**
+reads the selected field of the named metric. With nil metrics this only checks that the field exists.
+Timer durations are in seconds and rates are per second, whatever units Vidispine reports in
+*/
+func (s metricSelector) read(metrics *MetricsResponse, key string) (float64, error) {
+	missing := fmt.Errorf("there is no %s %s", s.section, key)
+	unknownField := fmt.Errorf("%s has no field %s", s.section, s.field)
+	switch s.section {
+	case "gauge":
+		if s.field != "value" {
+			return 0, unknownField
+		}
+		if metrics == nil {
+			return 0, nil
+		}
+		gauge, haveGauge := metrics.Gauges[key]
+		if !haveGauge {
+			return 0, missing
+		}
+		return gauge.FloatValue()
+	case "counter":
+		if s.field != "count" {
+			return 0, unknownField
+		}
+		if metrics == nil {
+			return 0, nil
+		}
+		counter, haveCounter := metrics.Counters[key]
+		if !haveCounter {
+			return 0, missing
+		}
+		return float64(counter.Count), nil
+	case "meter":
+		if s.field != "count" && !isRateField(s.field) {
+			return 0, unknownField
+		}
+		if metrics == nil {
+			return 0, nil
+		}
+		meter, haveMeter := metrics.Meters[key]
+		if !haveMeter {
+			return 0, missing
+		}
+		return meterField(meter, s.field), nil
+	case "histogram":
+		if _, isStat := (MetricHistogram{}).Percentile(s.field); !isStat && s.field != "count" && s.field != "stddev" {
+			return 0, unknownField
+		}
+		if metrics == nil {
+			return 0, nil
+		}
+		histogram, haveHistogram := metrics.Histograms[key]
+		if !haveHistogram {
+			return 0, missing
+		}
+		return snapshotField(histogram.MetricSnapshot, s.field), nil
+	default:
+		_, isStat := (MetricTimer{}).Percentile(s.field)
+		if !isStat && s.field != "count" && s.field != "stddev" && !isRateField(s.field) {
+			return 0, unknownField
+		}
+		if metrics == nil {
+			return 0, nil
+		}
+		timer, haveTimer := metrics.Timers[key]
+		if !haveTimer {
+			return 0, missing
+		}
+		if isRateField(s.field) {
+			return timer.PerSecond(rateField(s.field, timer.M1Rate, timer.M5Rate, timer.M15Rate, timer.MeanRate)), nil
+		}
+		if s.field == "count" {
+			return float64(timer.Count), nil
+		}
+		took, unitsErr := timer.Duration(snapshotField(timer.MetricSnapshot, s.field))
+		return took.Seconds(), unitsErr
+	}
+}
+
+func isRateField(field string) bool {
+	for _, rate := range rateFields {
+		if field == rate {
+			return true
+		}
+	}
+	return false
+}
+
+func rateField(field string, m1, m5, m15, mean float64) float64 {
+	switch field {
+	case "m5_rate":
+		return m5
+	case "m15_rate":
+		return m15
+	case "mean_rate":
+		return mean
+	default:
+		return m1
+	}
+}
+
+func meterField(meter MetricMeter, field string) float64 {
+	if field == "count" {
+		return float64(meter.Count)
+	}
+	return meter.PerSecond(rateField(field, meter.M1Rate, meter.M5Rate, meter.M15Rate, meter.MeanRate))
+}
+
+func snapshotField(snapshot MetricSnapshot, field string) float64 {
+	switch field {
+	case "count":
+		return float64(snapshot.Count)
+	case "stddev":
+		return snapshot.StdDev
+	default:
+		value, _ := snapshot.Percentile(field)
+		return value
+	}
+}
+
+/**
+reads the selected field of the named metric from the latest sample, or works out how much it has changed
+since the previous one for the delta and rate fields
+*/
+func (s metricSelector) readSamples(samples Samples, key string) (float64, error) {
+	if !isDeltaField(s.field) {
+		return s.read(samples.Current.Metrics, key)
+	}
+	cumulative := s
+	cumulative.field = cumulativeFields[s.section]
+	delta, deltaErr := samples.Delta(func(metrics *MetricsResponse) (float64, error) {
+		return cumulative.read(metrics, key)
+	})
+	if deltaErr != nil {
+		return 0, deltaErr
+	}
+	if s.field == "rate" {
+		return delta.Rate(), nil
+	}
+	return delta.Change, nil
+}
+
+/**
+the metric keys in the selector's section
+*/
+func (s metricSelector) keys(metrics *MetricsResponse) []string {
+	keys := make([]string, 0)
+	switch s.section {
+	case "gauge":
+		for key := range metrics.Gauges {
+			keys = append(keys, key)
+		}
+	case "counter":
+		for key := range metrics.Counters {
+			keys = append(keys, key)
+		}
+	case "meter":
+		for key := range metrics.Meters {
+			keys = append(keys, key)
+		}
+	case "histogram":
+		for key := range metrics.Histograms {
+			keys = append(keys, key)
+		}
+	default:
+		for key := range metrics.Timers {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+/**
+returns the selected value of every metric the selector matches, by what its * matched
+*/
+func (s metricSelector) values(samples Samples) (map[string]float64, error) {
+	if !s.isPattern() {
+		value, err := s.readSamples(samples, s.name)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]float64{"": value}, nil
+	}
+	values := make(map[string]float64)
+	for _, key := range s.keys(samples.Current.Metrics) {
+		if matched, isMatch := s.match(key); isMatch {
+			value, err := s.readSamples(samples, key)
+			if errors.Is(err, ErrNoPreviousSample) {
+				continue //it will be compared next time
+			}
+			if err != nil {
+				return nil, err
+			}
+			values[matched] = value
+		}
+	}
+	return values, nil
+}
+
+func (r Rule) expression() (expression, error) {
+	text := r.Value
+	if text == "" && len(r.Metrics) == 1 {
+		for name := range r.Metrics {
+			text = name
+		}
+	}
+	if text == "" {
+		return nil, fmt.Errorf("value must be given when there is more than one metric")
+	}
+	expr, names, err := parseExpression(text)
+	if err != nil {
+		return nil, fmt.Errorf("value '%s' is not valid: %s", text, err)
+	}
+	for _, name := range names {
+		if _, known := r.Metrics[name]; !known {
+			return nil, fmt.Errorf("value uses '%s', which is not one of the metrics", name)
+		}
+	}
+	return expr, nil
+}
+
+func parseTemplate(name string, text string, defaultText string) (*template.Template, error) {
+	if text == "" {
+		text = defaultText
+	}
+	return template.New(name).Funcs(ruleTemplateFuncs).Option("missingkey=error").Parse(text)
+}
+
+/**
+returns a description of every problem with the rule, or an empty list if it is usable
+*/
+func (r Rule) Validate() []string {
+	problems := make([]string, 0)
+	if !ruleNamePattern.MatchString(r.Name) {
+		problems = append(problems, fmt.Sprintf("name '%s' must be lower case letters, numbers, '.', '-' and '_'", r.Name))
+	}
+	if len(r.Metrics) == 0 {
+		problems = append(problems, "metrics must name at least one metric")
+	}
+	for _, name := range sortedNames(r.Metrics) {
+		if !valueNamePattern.MatchString(name) {
+			problems = append(problems, fmt.Sprintf("metrics: '%s' must be letters, numbers and '_', to be used in value", name))
+		}
+		if _, selectorErr := parseSelector(r.Metrics[name]); selectorErr != nil {
+			problems = append(problems, fmt.Sprintf("metrics.%s: %s", name, selectorErr))
+		}
+	}
+	if len(r.Metrics) > 0 {
+		if _, exprErr := r.expression(); exprErr != nil {
+			problems = append(problems, exprErr.Error())
+		}
+	}
+	if r.Warning == "" && r.Critical == "" {
+		problems = append(problems, "at least one of warning and critical must be given")
+	}
+	for _, c := range []struct {
+		name string
+		text string
+	}{{"warning", r.Warning}, {"critical", r.Critical}} {
+		if c.text == "" {
+			continue
+		}
+		if _, compErr := parseComparison(c.text); compErr != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", c.name, compErr))
+		}
+	}
+	if _, templateErr := parseTemplate("dedup_key", r.DedupKey, defaultRuleDedupKey); templateErr != nil {
+		problems = append(problems, fmt.Sprintf("dedup_key is not a valid template: %s", templateErr))
+	}
+	if _, templateErr := parseTemplate("message", r.Message, defaultRuleMessage); templateErr != nil {
+		problems = append(problems, fmt.Sprintf("message is not a valid template: %s", templateErr))
+	}
+	return problems
+}
+
+func sortedNames(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/**
+evaluates the rule against the latest metrics, returning an alert for each match that meets a threshold. Rules
+are validated when the configuration is loaded, so problems here are with the metrics, e.g. one is missing.
+Rules using delta or rate are skipped until there is a previous sample to compare with
+*/
+func (r Rule) Evaluate(samples Samples, integrationKey string, verboseMode bool) ([]*pagerduty.TriggerEvent, error) {
+	expr, exprErr := r.expression()
+	if exprErr != nil {
+		return nil, exprErr
+	}
+
+	//the value of each metric, by what the * in its name matched
+	values := make(map[string]map[string]float64)
+	isPattern := make(map[string]bool)
+	var matches []string
+	for _, name := range sortedNames(r.Metrics) {
+		selector, selectorErr := parseSelector(r.Metrics[name])
+		if selectorErr != nil {
+			return nil, selectorErr
+		}
+		selected, readErr := selector.values(samples)
+		if errors.Is(readErr, ErrNoPreviousSample) {
+			if verboseMode {
+				log.Printf("INFO (verbose) vsmetriccheck rule %s will be evaluated from the next poll, %s", r.Name, readErr)
+			}
+			return nil, nil
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("%s: %s", name, readErr)
+		}
+		values[name] = selected
+		isPattern[name] = selector.isPattern()
+		if selector.isPattern() {
+			matches = intersect(matches, selected)
+		}
+	}
+	if matches == nil {
+		matches = []string{""}
+	}
+
+	alerts := make([]*pagerduty.TriggerEvent, 0)
+	for _, match := range matches {
+		named := make(map[string]float64)
+		for name, selected := range values {
+			if isPattern[name] {
+				named[name] = selected[match]
+			} else {
+				named[name] = selected[""]
+			}
+		}
+		value := expr.eval(named)
+		if verboseMode {
+			log.Printf("INFO (verbose) vsmetriccheck rule %s for '%s' is %g from %v", r.Name, match, value, named)
+		}
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			log.Printf("WARNING rule %s for '%s' has no value (%g), e.g. it divides by zero", r.Name, match, value)
+			continue
+		}
+		var interval time.Duration
+		if samples.HavePrevious() {
+			interval = samples.Current.At.Sub(samples.Previous.At)
+		}
+		alert, alertErr := r.alertFor(RuleAlertData{Rule: r.Name, Match: match, Value: value, Metrics: named, Interval: interval}, integrationKey)
+		if alertErr != nil {
+			return alerts, alertErr
+		}
+		if alert != nil {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts, nil
+}
+
+/**
+returns the matches that the existing list and the new values both have. A nil list means nothing has been
+matched yet
+*/
+func intersect(existing []string, values map[string]float64) []string {
+	result := make([]string, 0)
+	if existing == nil {
+		for match := range values {
+			result = append(result, match)
+		}
+		sort.Strings(result)
+		return result
+	}
+	for _, match := range existing {
+		if _, haveMatch := values[match]; haveMatch {
+			result = append(result, match)
+		}
+	}
+	return result
+}
+
+func (r Rule) alertFor(data RuleAlertData, integrationKey string) (*pagerduty.TriggerEvent, error) {
+	for _, level := range []struct {
+		text     string
+		severity pagerduty.Severity
+	}{{r.Critical, pagerduty.SeverityCritical}, {r.Warning, pagerduty.SeverityWarning}} {
+		if level.text == "" {
+			continue
+		}
+		threshold, compErr := parseComparison(level.text)
+		if compErr != nil {
+			return nil, compErr
+		}
+		if threshold.matches(data.Value) {
+			data.Threshold = strings.TrimSpace(level.text)
+			data.Severity = level.severity
+			break
+		}
+	}
+	if data.Severity == "" {
+		return nil, nil
+	}
+
+	dedupKey, keyErr := r.render("dedup_key", r.DedupKey, defaultRuleDedupKey, data)
+	if keyErr != nil {
+		return nil, keyErr
+	}
+	message, messageErr := r.render("message", r.Message, defaultRuleMessage, data)
+	if messageErr != nil {
+		return nil, messageErr
+	}
+	component := r.Component
+	if component == "" {
+		component = defaultRuleComponent
+	}
+	log.Printf("WARNING rule %s raised a %s alert: %s", r.Name, data.Severity, message)
+	nowTime := time.Now()
+	return pagerduty.NewTriggerEvent(component, integrationKey, data.Severity, dedupKey, message, &nowTime), nil
+}
+
+func (r Rule) render(name string, text string, defaultText string, data RuleAlertData) (string, error) {
+	tmpl, parseErr := parseTemplate(name, text, defaultText)
+	if parseErr != nil {
+		return "", parseErr
+	}
+	var output bytes.Buffer
+	if execErr := tmpl.Execute(&output, data); execErr != nil {
+		return "", fmt.Errorf("rule %s could not write its %s: %s", r.Name, name, execErr)
+	}
+	return output.String(), nil
+}
+
+/**
+evaluates each of the check's rules. A rule that can't be evaluated is logged and skipped, like the other
+metric checks do when a metric is missing
+*/
+func (m VSMetricCheck) CheckRules(samples Samples, verboseMode bool) []*pagerduty.TriggerEvent {
+	alerts := make([]*pagerduty.TriggerEvent, 0)
+	for _, rule := range m.Rules {
+		ruleAlerts, ruleErr := rule.Evaluate(samples, m.IntegrationKey, verboseMode)
+		if ruleErr != nil {
+			log.Printf("WARNING could not evaluate metric rule %s: %s", rule.Name, ruleErr)
+		}
+		alerts = append(alerts, ruleAlerts...)
+	}
+	return alerts
+}
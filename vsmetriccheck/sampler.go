@@ -0,0 +1,194 @@
+package vsmetriccheck
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+/**
+Sample is the metrics read from one host at one time
+*/
+type Sample struct {
+	Metrics *MetricsResponse
+	At      time.Time
+}
+
+/**
+Samples are the latest metrics from a host along with the ones read before them, if there were any, so that
+counters can be turned into how much they changed in between
+*/
+type Samples struct {
+	Previous Sample
+	Current  Sample
+	History  []Sample //every sample kept from before Current, oldest first, so Previous is the last of them
+}
+
+/**
+Delta is how much a cumulative value changed between two samples
+*/
+type Delta struct {
+	Change   float64
+	Interval time.Duration
+	Reset    bool //the value went down, e.g. because Vidispine restarted, so Change is counted from zero
+}
+
+/**
+returns the change per second
+*/
+func (d Delta) Rate() float64 {
+	if d.Interval <= 0 {
+		return 0
+	}
+	return d.Change / d.Interval.Seconds()
+}
+
+// returned by Samples.Delta when there is nothing to compare with yet, e.g. on the first poll
+var ErrNoPreviousSample = errors.New("there is no previous sample to compare with yet")
+
+func (s Samples) HavePrevious() bool {
+	return s.Previous.Metrics != nil
+}
+
+/**
+returns how much a cumulative value changed since the previous sample. read gets the value from one sample
+*/
+func (s Samples) Delta(read func(metrics *MetricsResponse) (float64, error)) (Delta, error) {
+	if !s.HavePrevious() {
+		return Delta{}, ErrNoPreviousSample
+	}
+	current, currentErr := read(s.Current.Metrics)
+	if currentErr != nil {
+		return Delta{}, currentErr
+	}
+	previous, previousErr := read(s.Previous.Metrics)
+	if previousErr != nil {
+		//the metric is new since the last poll, so there's nothing to compare it with yet
+		return Delta{}, ErrNoPreviousSample
+	}
+	delta := Delta{Change: current - previous, Interval: s.Current.At.Sub(s.Previous.At)}
+	if current < previous {
+		delta = Delta{Change: current, Interval: delta.Interval, Reset: true}
+	}
+	return delta, nil
+}
+
+/**
+returns how much the named counter changed since the previous sample
+*/
+func (s Samples) CounterDelta(name string) (Delta, error) {
+	return s.Delta(func(metrics *MetricsResponse) (float64, error) {
+		return metricSelector{section: "counter", name: name, field: "count"}.read(metrics, name)
+	})
+}
+
+/**
+returns how much the named gauge changed since the previous sample, for gauges that only go up, e.g.
+jvm.gc.G1-Old-Generation.count
+*/
+func (s Samples) GaugeDelta(name string) (Delta, error) {
+	return s.Delta(func(metrics *MetricsResponse) (float64, error) {
+		return metricSelector{section: "gauge", name: name, field: "value"}.read(metrics, name)
+	})
+}
+
+/**
+returns how many events the named meter counted since the previous sample
+*/
+func (s Samples) MeterDelta(name string) (Delta, error) {
+	return s.Delta(func(metrics *MetricsResponse) (float64, error) {
+		return metricSelector{section: "meter", name: name, field: "count"}.read(metrics, name)
+	})
+}
+
+/**
+returns a value from each sample, oldest first and ending with the current one. Only the unbroken run of
+samples that the value can be read from is returned, so a metric that has just appeared has a short series
+*/
+func (s Samples) Series(read func(metrics *MetricsResponse) (float64, error)) []float64 {
+	samples := append(append([]Sample{}, s.History...), s.Current)
+	series := make([]float64, 0, len(samples))
+	for i := len(samples) - 1; i >= 0; i-- {
+		value, readErr := read(samples[i].Metrics)
+		if readErr != nil {
+			break
+		}
+		series = append([]float64{value}, series...)
+	}
+	return series
+}
+
+/**
+returns how many times in a row the series went up at its end, e.g. 2 for 5, 3, 4, 6
+*/
+func risingFor(series []float64) int {
+	rises := 0
+	for i := len(series) - 1; i > 0 && series[i] > series[i-1]; i-- {
+		rises++
+	}
+	return rises
+}
+
+// how many samples are kept from each host by default, which is as far back as Series can go
+const DefaultHistory = 12
+
+// how long a host can go without being sampled before its samples are dropped, e.g. a node that has left the cluster
+const DefaultForgetAfter = time.Hour
+
+/**
+Sampler remembers the last few metrics read from each host. Each target has its own, which is kept when the
+configuration is reloaded so that a rebuilt check carries on from the samples the old one took
+*/
+type Sampler struct {
+	MaxHistory  int
+	ForgetAfter time.Duration
+
+	lock    sync.Mutex
+	history map[string][]Sample
+}
+
+func NewSampler() *Sampler {
+	return &Sampler{MaxHistory: DefaultHistory, ForgetAfter: DefaultForgetAfter, history: make(map[string][]Sample)}
+}
+
+/**
+records the metrics read from host and returns them along with the previous sample from that host. Hosts that
+have not been sampled for ForgetAfter are dropped
+*/
+func (s *Sampler) Record(host string, metrics *MetricsResponse, at time.Time) Samples {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	history := s.history[host]
+	samples := Samples{Current: Sample{Metrics: metrics, At: at}, History: history}
+	if len(history) > 0 {
+		samples.Previous = history[len(history)-1]
+	}
+
+	kept := append(append([]Sample{}, history...), samples.Current)
+	if len(kept) > s.MaxHistory {
+		kept = kept[len(kept)-s.MaxHistory:]
+	}
+	s.history[host] = kept
+
+	for otherHost, otherHistory := range s.history {
+		if at.Sub(otherHistory[len(otherHistory)-1].At) > s.ForgetAfter {
+			delete(s.history, otherHost)
+		}
+	}
+	return samples
+}
+
+/**
+returns the hosts that samples are being kept for
+*/
+func (s *Sampler) Hosts() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	hosts := make([]string, 0, len(s.history))
+	for host := range s.history {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
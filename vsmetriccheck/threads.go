@@ -0,0 +1,145 @@
+package vsmetriccheck
+
+import (
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+	"log"
+	"strings"
+	"time"
+)
+
+/**
+ThreadThresholds are the limits on the JVM's threads. A limit left at zero comes from DefaultThreadThresholds,
+but a growth_polls of zero turns the growth alert off
+*/
+type ThreadThresholds struct {
+	BlockedWarning int `yaml:"blocked_warning"`         //jvm.threads.blocked.count, threads waiting to take a lock
+	TotalWarning   int `yaml:"total_warning"`           //jvm.threads.count
+	GrowthPolls    int `yaml:"growth_polls" zero:"off"` //how many polls in a row the total may go up before it looks like a leak
+}
+
+var DefaultThreadThresholds = ThreadThresholds{
+	BlockedWarning: 20,
+	TotalWarning:   1000,
+	GrowthPolls:    6,
+}
+
+/**
+returns the limits to check against, with the ones that were left out filled in
+*/
+func (t ThreadThresholds) WithDefaults() ThreadThresholds {
+	common.FillDefaults(&t, DefaultThreadThresholds)
+	return t
+}
+
+/**
+returns a description of each limit that can't be used, e.g. a negative count
+*/
+func (t ThreadThresholds) Validate() []string {
+	problems := checkNotNegative(make([]string, 0),
+		namedValue{"blocked_warning", float64(t.BlockedWarning)},
+		namedValue{"total_warning", float64(t.TotalWarning)},
+	)
+	return checkPolls(problems, "growth_polls", t.GrowthPolls, 1)
+}
+
+/**
+returns the names of the threads in the deadlock descriptions from jvm.threads.deadlocks, which look like
+"name locked on lock (owned by other):" followed by the stack trace
+*/
+func deadlockedThreads(descriptions []string) []string {
+	names := make([]string, 0, len(descriptions))
+	for _, description := range descriptions {
+		name := strings.SplitN(description, "\n", 2)[0]
+		if at := strings.Index(name, " locked on "); at > 0 {
+			name = name[:at]
+		}
+		names = append(names, strings.TrimSpace(name))
+	}
+	return names
+}
+
+/**
+returns a critical PD event if any threads are deadlocked, and warnings if too many are blocked, if there are too
+many altogether or if the number of threads has gone up on each of the last few polls
+*/
+func (m VSMetricCheck) CheckThreads(samples Samples, verboseMode bool) []*pagerduty.TriggerEvent {
+	alerts := make([]*pagerduty.TriggerEvent, 0)
+	metrics := samples.Current.Metrics
+	thresholds := m.ThreadThresholds.WithDefaults()
+
+	var deadlocked []string
+	if deadlocks, haveDeadlocks := metrics.Gauges["jvm.threads.deadlocks"]; haveDeadlocks {
+		descriptions, listErr := deadlocks.StringsValue()
+		if listErr != nil {
+			log.Printf("WARNING could not read jvm.threads.deadlocks: %s", listErr)
+		}
+		deadlocked = deadlockedThreads(descriptions)
+	}
+	deadlockCount, countErr := gaugeValue(metrics, "jvm.threads.deadlock.count")
+	if countErr == nil && int(deadlockCount) > len(deadlocked) {
+		//the list is only filled in when the deadlock is found, so fall back to the count
+		for i := len(deadlocked); i < int(deadlockCount); i++ {
+			deadlocked = append(deadlocked, "(unnamed)")
+		}
+	}
+	if len(deadlocked) > 0 {
+		nowTime := time.Now()
+		log.Printf("WARNING %d JVM threads are deadlocked, alerting", len(deadlocked))
+		alerts = append(alerts, pagerduty.NewTriggerEvent("vidispine-threads",
+			m.IntegrationKey,
+			pagerduty.SeverityCritical,
+			"vidispine-threads-deadlock",
+			fmt.Sprintf("%d Vidispine threads are deadlocked and will never finish, the pod needs restarting: %s", len(deadlocked), strings.Join(deadlocked, ", ")),
+			&nowTime))
+	}
+
+	blocked, blockedErr := gaugeValue(metrics, "jvm.threads.blocked.count")
+	total, totalErr := gaugeValue(metrics, "jvm.threads.count")
+	if blockedErr != nil || totalErr != nil {
+		log.Print("WARNING metrics response was missing jvm.threads.blocked.count or jvm.threads.count, can't check thread counts")
+		return alerts
+	}
+	if verboseMode {
+		log.Printf("INFO (verbose) vsmetriccheck.CheckThreads JVM has %.0f threads, %.0f of them blocked and %d deadlocked", total, blocked, len(deadlocked))
+	}
+
+	if blocked > float64(thresholds.BlockedWarning) {
+		nowTime := time.Now()
+		log.Printf("WARNING %.0f JVM threads are blocked, alerting", blocked)
+		alerts = append(alerts, pagerduty.NewTriggerEvent("vidispine-threads",
+			m.IntegrationKey,
+			pagerduty.SeverityWarning,
+			"vidispine-threads-blocked",
+			fmt.Sprintf("%.0f Vidispine threads are blocked waiting for locks, over the limit of %d", blocked, thresholds.BlockedWarning),
+			&nowTime))
+	}
+
+	if total > float64(thresholds.TotalWarning) {
+		nowTime := time.Now()
+		log.Printf("WARNING JVM has %.0f threads, alerting", total)
+		alerts = append(alerts, pagerduty.NewTriggerEvent("vidispine-threads",
+			m.IntegrationKey,
+			pagerduty.SeverityWarning,
+			"vidispine-threads-total",
+			fmt.Sprintf("Vidispine is running %.0f threads, over the limit of %d", total, thresholds.TotalWarning),
+			&nowTime))
+	}
+
+	series := samples.Series(func(metrics *MetricsResponse) (float64, error) {
+		return gaugeValue(metrics, "jvm.threads.count")
+	})
+	if thresholds.GrowthPolls > 0 && risingFor(series) >= thresholds.GrowthPolls {
+		from := series[len(series)-1-thresholds.GrowthPolls]
+		nowTime := time.Now()
+		log.Printf("WARNING JVM thread count has gone up on each of the last %d polls, alerting", thresholds.GrowthPolls)
+		alerts = append(alerts, pagerduty.NewTriggerEvent("vidispine-threads",
+			m.IntegrationKey,
+			pagerduty.SeverityWarning,
+			"vidispine-threads-growth",
+			fmt.Sprintf("Vidispine's thread count has gone up on each of the last %d polls, from %.0f to %.0f, threads may be leaking", thresholds.GrowthPolls, from, total),
+			&nowTime))
+	}
+	return alerts
+}
@@ -0,0 +1,150 @@
+package vsmetriccheck
+
+import (
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+)
+
+/**
+Thresholds are the levels at which the metric checks raise alerts. Each one is a fraction between 0 and 1;
+any that are left at zero use the value from DefaultThresholds
+*/
+type Thresholds struct {
+	PoolActiveCritical  float64 `yaml:"pool_active_critical"`   //active connections as a fraction of the pool size
+	PoolInUseWarning    float64 `yaml:"pool_in_use_warning"`    //active+idle connections as a fraction of the pool size
+	HeapCritical        float64 `yaml:"heap_critical"`          //jvm.memory.heap.usage
+	HeapWarning         float64 `yaml:"heap_warning"`           //jvm.memory.heap.usage
+	Errors5xx1mError    float64 `yaml:"errors_5xx_1m_error"`    //fraction of responses in the last minute that were 5xx
+	Errors5xx5mWarning  float64 `yaml:"errors_5xx_5m_warning"`  //fraction of responses in the last 5 minutes that were 5xx
+	Errors5xx15mWarning float64 `yaml:"errors_5xx_15m_warning"` //fraction of responses in the last 15 minutes that were 5xx
+}
+
+var DefaultThresholds = Thresholds{
+	PoolActiveCritical:  0.9,
+	PoolInUseWarning:    0.8,
+	HeapCritical:        0.9,
+	HeapWarning:         0.8,
+	Errors5xx1mError:    0.95,
+	Errors5xx5mWarning:  0.6,
+	Errors5xx15mWarning: 0.4,
+}
+
+/**
+returns a copy of the thresholds where anything left at zero is taken from DefaultThresholds
+*/
+func (t Thresholds) WithDefaults() Thresholds {
+	common.FillDefaults(&t, DefaultThresholds)
+	return t
+}
+
+/**
+returns a description of every problem with the thresholds, or an empty list if they are usable
+*/
+func (t Thresholds) Validate() []string {
+	problems := checkFractions(make([]string, 0),
+		namedValue{"pool_active_critical", t.PoolActiveCritical},
+		namedValue{"pool_in_use_warning", t.PoolInUseWarning},
+		namedValue{"heap_critical", t.HeapCritical},
+		namedValue{"heap_warning", t.HeapWarning},
+		namedValue{"errors_5xx_1m_error", t.Errors5xx1mError},
+		namedValue{"errors_5xx_5m_warning", t.Errors5xx5mWarning},
+		namedValue{"errors_5xx_15m_warning", t.Errors5xx15mWarning},
+	)
+	withDefaults := t.WithDefaults()
+	return checkOrder(problems, namedValue{"heap_warning", withDefaults.HeapWarning}, namedValue{"heap_critical", withDefaults.HeapCritical})
+}
+
+/**
+a threshold along with its name in the config file, for reporting problems with it
+*/
+type namedValue struct {
+	name  string
+	value float64
+}
+
+/**
+adds a problem for each value that is not a fraction between 0 and 1
+*/
+func checkFractions(problems []string, values ...namedValue) []string {
+	for _, v := range values {
+		if v.value < 0 || v.value > 1 {
+			problems = append(problems, fmt.Sprintf("%s must be a fraction between 0 and 1, not %g", v.name, v.value))
+		}
+	}
+	return problems
+}
+
+/**
+a duration along with its name in the config file, for reporting problems with it
+*/
+type namedDuration struct {
+	name  string
+	value common.Duration
+}
+
+/**
+adds a problem for each duration that could not be parsed from the config file
+*/
+func checkDurations(problems []string, durations ...namedDuration) []string {
+	for _, d := range durations {
+		if err := d.value.Err(); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", d.name, err))
+		}
+	}
+	return problems
+}
+
+/**
+adds a problem for each value that is negative
+*/
+func checkNotNegative(problems []string, values ...namedValue) []string {
+	for _, v := range values {
+		if v.value < 0 {
+			problems = append(problems, fmt.Sprintf("%s must not be negative", v.name))
+		}
+	}
+	return problems
+}
+
+/**
+adds a problem if a warning level is higher than the critical one. Both should have had their defaults filled in
+*/
+func checkOrder(problems []string, warning namedValue, critical namedValue) []string {
+	if warning.value > critical.value {
+		problems = append(problems, fmt.Sprintf("%s must not be higher than %s", warning.name, critical.name))
+	}
+	return problems
+}
+
+/**
+adds a problem if a number of polls to look back over is neither 0, which turns its alert off, nor between least and
+the number of polls the sampler keeps
+*/
+func checkPolls(problems []string, name string, polls int, least int) []string {
+	if polls != 0 && (polls < least || polls > DefaultHistory) {
+		problems = append(problems, fmt.Sprintf("%s must be between %d and %d, as only that many polls are kept, or 0 to turn it off", name, least, DefaultHistory))
+	}
+	return problems
+}
+
+/**
+returns the severity for a value that is checked against warning and critical levels, or "" if it is under both
+*/
+func levelSeverity(value float64, warning float64, critical float64) pagerduty.Severity {
+	switch {
+	case value > critical:
+		return pagerduty.SeverityCritical
+	case value > warning:
+		return pagerduty.SeverityWarning
+	default:
+		return ""
+	}
+}
+
+/**
+formats a fraction as a whole percentage, e.g. 0.9 -> "90%"
+*/
+func percent(fraction float64) string {
+	return fmt.Sprintf("%.0f%%", fraction*100)
+}
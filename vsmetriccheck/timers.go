@@ -0,0 +1,118 @@
+package vsmetriccheck
+
+import (
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vidispine"
+	"log"
+	"strings"
+	"time"
+)
+
+/**
+TimerThreshold alerts when a percentile of one of the Dropwizard timers, e.g. elasticsearch.query.time, is
+too slow. Either or both of Warning and Critical can be given
+*/
+type TimerThreshold struct {
+	Timer      string          `yaml:"timer"`      //name of the timer in the metrics
+	Percentile string          `yaml:"percentile"` //p50, p75, p95, p98, p99, p999, max, mean or min. Defaults to p99
+	Warning    common.Duration `yaml:"warning"`
+	Critical   common.Duration `yaml:"critical"`
+}
+
+const DefaultPercentile = "p99"
+
+func (t TimerThreshold) percentile() string {
+	if t.Percentile == "" {
+		return DefaultPercentile
+	}
+	return strings.ToLower(t.Percentile)
+}
+
+/**
+returns a description of every problem with the threshold, or an empty list if it is usable
+*/
+func (t TimerThreshold) Validate() []string {
+	problems := make([]string, 0)
+	if t.Timer == "" {
+		problems = append(problems, "timer must be given")
+	}
+	if _, known := (vidispine.MetricSnapshot{}).Percentile(t.percentile()); !known {
+		problems = append(problems, fmt.Sprintf("percentile '%s' is not one of %s", t.Percentile, strings.Join(vidispine.Percentiles, ", ")))
+	}
+	problems = checkDurations(problems, namedDuration{"warning", t.Warning}, namedDuration{"critical", t.Critical})
+	warning, critical := t.Warning.Duration, t.Critical.Duration
+	if warning < 0 || critical < 0 {
+		problems = append(problems, "warning and critical must not be negative")
+	}
+	if warning == 0 && critical == 0 && t.Warning.Err() == nil && t.Critical.Err() == nil {
+		problems = append(problems, "at least one of warning and critical must be given")
+	}
+	if warning > 0 && critical > 0 && warning > critical {
+		problems = append(problems, "warning must not be higher than critical")
+	}
+	return problems
+}
+
+/**
+returns a PD event for each timer threshold that is exceeded. Timers that have not recorded anything are
+skipped, as their percentiles are all zero
+*/
+func (m VSMetricCheck) CheckTimers(metrics *MetricsResponse, verboseMode bool) []*pagerduty.TriggerEvent {
+	alerts := make([]*pagerduty.TriggerEvent, 0)
+	for _, threshold := range m.TimerThresholds {
+		dedupKey := fmt.Sprintf("vidispine-timer-%s-%s", threshold.Timer, threshold.percentile())
+		if alert := m.checkTimer(metrics, threshold, "vidispine-timers", dedupKey, verboseMode); alert != nil {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+/**
+returns a PD event if the timer's percentile is over the threshold
+*/
+func (m VSMetricCheck) checkTimer(metrics *MetricsResponse, threshold TimerThreshold, component string, dedupKey string, verboseMode bool) *pagerduty.TriggerEvent {
+	timer, haveTimer := metrics.Timers[threshold.Timer]
+	if !haveTimer {
+		log.Printf("WARNING timer %s is not present in the metrics, can't check it", threshold.Timer)
+		return nil
+	}
+	if timer.Count == 0 {
+		if verboseMode {
+			log.Printf("INFO (verbose) vsmetriccheck.CheckTimers %s has not recorded anything yet", threshold.Timer)
+		}
+		return nil
+	}
+
+	percentile := threshold.percentile()
+	value, _ := timer.Percentile(percentile)
+	took, unitsErr := timer.Duration(value)
+	if unitsErr != nil {
+		log.Printf("WARNING can't check timer %s: %s", threshold.Timer, unitsErr)
+		return nil
+	}
+	if verboseMode {
+		log.Printf("INFO (verbose) vsmetriccheck.CheckTimers %s of %s is %s, at %.2f calls/second", percentile, threshold.Timer, took, timer.PerSecond(timer.M1Rate))
+	}
+
+	var severity pagerduty.Severity
+	var limit time.Duration
+	switch {
+	case threshold.Critical.Duration > 0 && took > threshold.Critical.Duration:
+		severity, limit = pagerduty.SeverityCritical, threshold.Critical.Duration
+	case threshold.Warning.Duration > 0 && took > threshold.Warning.Duration:
+		severity, limit = pagerduty.SeverityWarning, threshold.Warning.Duration
+	default:
+		return nil
+	}
+	nowTime := time.Now()
+	log.Printf("WARNING %s of %s is %s, alerting", percentile, threshold.Timer, took)
+	return pagerduty.NewTriggerEvent(component,
+		m.IntegrationKey,
+		severity,
+		dedupKey,
+		fmt.Sprintf("The %s of %s is %s, over the %s threshold of %s", percentile, threshold.Timer, took, severity, limit),
+		&nowTime)
+}
@@ -2,22 +2,30 @@ package vsmetriccheck
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
 	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
-	"io/ioutil"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vidispine"
 	"log"
-	"net/http"
-	"net/url"
+	"sort"
 	"strings"
 	"time"
 )
 
 type VSMetricCheck struct {
-	VidispineHost   string
-	VidispineHttps  bool
-	VidispineDbName string
-	IntegrationKey  string
+	VidispineHost     string
+	VidispineHttps    bool
+	Admin             common.Endpoint //the admin service, if not set then port 9001 of VidispineHost is used
+	VidispineDbName   string
+	IntegrationKey    string
+	Thresholds        Thresholds
+	ThreadThresholds  ThreadThresholds
+	MemoryThresholds  MemoryThresholds
+	JettyThresholds   JettyThresholds
+	IndexerThresholds IndexerThresholds
+	TimerThresholds   []TimerThreshold
+	Rules             []Rule
+	Sampler           *Sampler //remembers earlier polls for the alerts on how metrics change; if nil, each poll is treated as the first
 }
 
 func (m VSMetricCheck) Name() string {
@@ -25,104 +33,144 @@ func (m VSMetricCheck) Name() string {
 }
 
 /**
-get the metrics response from the :9001 admin service
+database pool alerts are just noise if the database healthcheck itself is failing, as are failed indexer requests if
+the elasticsearch one is. If the admin service can't be reached or refuses the credentials, the healthcheck raises
+that first; on a cluster it still runs on the other nodes, so this check does too and would raise the same problem
+again for the same node
 */
-func (m VSMetricCheck) loadMetrics() (*MetricsResponse, error) {
-	httpClient := http.Client{}
-
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancelFunc()
-
-	proto := "https:/"
-	if !m.VidispineHttps {
-		proto = "http:/"
+func (m VSMetricCheck) AlertDependsOn() map[string]string {
+	return map[string]string{
+		"vidispine-database-pool":                             "vidispine-database",
+		"vidispine-indexer-failed":                            "vidispine-elasticsearch",
+		"vsmetriccheck-" + vidispine.ClassUnavailable:         "vshealthcheck-" + vidispine.ClassUnavailable,
+		"vsmetriccheck-" + vidispine.ClassCredentialsRejected: "vshealthcheck-" + vidispine.ClassCredentialsRejected,
 	}
+}
 
-	urlParts := []string{
-		proto,
-		m.VidispineHost + ":9001",
-		"metrics",
+/**
+returns where the admin service is
+*/
+func (m VSMetricCheck) adminEndpoint() common.Endpoint {
+	if m.Admin.BaseUrl != "" {
+		return m.Admin
 	}
+	return common.HostEndpoint(m.VidispineHost, 9001, m.VidispineHttps)
+}
 
-	urlStr := strings.Join(urlParts, "/")
-	_, urlParseErr := url.Parse(urlStr)
-	if urlParseErr != nil {
-		log.Printf("ERROR vsHealthcheck.loadHealthcheck URL %s is not valid: %s", urlStr, urlParseErr)
-		return nil, urlParseErr
-	}
+/**
+get the metrics response from the admin service
+*/
+func (m VSMetricCheck) loadMetrics() (*MetricsResponse, error) {
+	return vidispine.Client{Admin: m.adminEndpoint()}.Metrics(context.Background())
+}
 
-	httpReq, reqErr := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
-	if reqErr != nil {
-		return nil, reqErr
-	}
+/**
+returns a copy of the check that reads the metrics of one node of a cluster
+*/
+func (m VSMetricCheck) ForNode(host string) common.MonitorComponent {
+	m.Admin = m.adminEndpoint().ForHost(host)
+	return m
+}
 
-	response, httpErr := httpClient.Do(httpReq)
-	if httpErr != nil {
-		return nil, httpErr
-	}
-	defer response.Body.Close()
+const dataSourcePrefix = "io.dropwizard.db.ManagedPooledDataSource."
 
-	rawContent, readErr := ioutil.ReadAll(response.Body)
-	if readErr != nil {
-		return nil, readErr
+/**
+returns the names of every database connection pool in the metrics, e.g. vidispinedb, found from their size gauges
+*/
+func DatabasePools(metrics *MetricsResponse) []string {
+	pools := make([]string, 0)
+	for key := range metrics.Gauges {
+		if strings.HasPrefix(key, dataSourcePrefix) && strings.HasSuffix(key, ".size") {
+			pools = append(pools, strings.TrimSuffix(strings.TrimPrefix(key, dataSourcePrefix), ".size"))
+		}
 	}
+	sort.Strings(pools)
+	return pools
+}
 
-	var metrics MetricsResponse
-	unmarshalErr := json.Unmarshal(rawContent, &metrics)
-	if unmarshalErr != nil {
-		return nil, unmarshalErr
+/**
+returns PD events for every database connection pool in the metrics that is running out of connections, and one if
+the Vidispine pool, VidispineDbName, is not there at all, as then it is not being checked
+*/
+func (m VSMetricCheck) CheckDatabasePools(metrics *MetricsResponse, verboseMode bool) []*pagerduty.TriggerEvent {
+	alerts := make([]*pagerduty.TriggerEvent, 0)
+	pools := DatabasePools(metrics)
+	haveExpected := false
+	for _, pool := range pools {
+		dedupKey := "vidispine-database-pool"
+		if pool == m.VidispineDbName {
+			haveExpected = true
+		} else {
+			dedupKey += "-" + pool
+		}
+		if alert := m.checkPool(metrics, pool, dedupKey, verboseMode); alert != nil {
+			alerts = append(alerts, alert)
+		}
 	}
 
-	return &metrics, nil
+	if !haveExpected {
+		found := "none were"
+		if len(pools) > 0 {
+			found = "found " + strings.Join(pools, ", ")
+		}
+		nowTime := time.Now()
+		log.Printf("WARNING there are no metrics for database pool %s (%s), alerting", m.VidispineDbName, found)
+		alerts = append(alerts, pagerduty.NewTriggerEvent("vidispine-database",
+			m.IntegrationKey,
+			pagerduty.SeverityWarning,
+			"vidispine-database-pool-missing",
+			fmt.Sprintf("Vidispine reports no metrics for the %s database pool so it is not being checked (%s), check database_name", m.VidispineDbName, found),
+			&nowTime))
+	}
+	return alerts
 }
 
 /**
 returns a PD event if either active connections makes up for >90% of total pool or idle+active makes up for >80%
+(or whatever PoolActiveCritical and PoolInUseWarning are set to)
 */
-func (m VSMetricCheck) CheckDatabasePool(metrics *MetricsResponse, verboseMode bool) *pagerduty.TriggerEvent {
+func (m VSMetricCheck) checkPool(metrics *MetricsResponse, pool string, dedupKey string, verboseMode bool) *pagerduty.TriggerEvent {
 	//we use MustFloat() to simplify coding, therefore we need to catch any panics that occur
 	defer func() {
 		if r := recover(); r != nil {
-			log.Print("ERROR could not process database metrics: ", r)
+			log.Printf("ERROR could not process metrics of database pool %s: %s", pool, r)
 		}
 	}()
 
-	sizeKey := fmt.Sprintf("io.dropwizard.db.ManagedPooledDataSource.%s.size", m.VidispineDbName)
-	poolSizeTotal, havePoolSizeTotal := metrics.Gauges[sizeKey]
-	idleKey := fmt.Sprintf("io.dropwizard.db.ManagedPooledDataSource.%s.idle", m.VidispineDbName)
-	poolIdle, havePoolIdle := metrics.Gauges[idleKey]
-	activeKey := fmt.Sprintf("io.dropwizard.db.ManagedPooledDataSource.%s.active", m.VidispineDbName)
-	poolActive, havePoolActive := metrics.Gauges[activeKey]
+	poolSizeTotal, havePoolSizeTotal := metrics.Gauges[dataSourcePrefix+pool+".size"]
+	poolIdle, havePoolIdle := metrics.Gauges[dataSourcePrefix+pool+".idle"]
+	poolActive, havePoolActive := metrics.Gauges[dataSourcePrefix+pool+".active"]
 
 	if !havePoolActive || !havePoolIdle || !havePoolSizeTotal {
-		log.Print("WARNING metrics response was missing some of the database metrics, can't alert on database")
+		log.Printf("WARNING metrics response was missing some of the metrics of database pool %s, can't alert on it", pool)
 		return nil
 	}
 
 	if verboseMode {
-		log.Printf("INFO (verbose) vsmetriccheck.CheckDatabasePool total pool size is %.1f, with %.1f currently active and %.1f idle",
-			poolSizeTotal.MustFloat(), poolActive.MustFloat(), poolIdle.MustFloat())
+		log.Printf("INFO (verbose) vsmetriccheck.CheckDatabasePools %s pool size is %.1f, with %.1f currently active and %.1f idle",
+			pool, poolSizeTotal.MustFloat(), poolActive.MustFloat(), poolIdle.MustFloat())
 	}
 
-	if poolActive.MustFloat() > 0.9*poolSizeTotal.MustFloat() {
+	thresholds := m.Thresholds.WithDefaults()
+	if poolActive.MustFloat() > thresholds.PoolActiveCritical*poolSizeTotal.MustFloat() {
 		nowTime := time.Now()
-		log.Print("WARNING 90% or more of connection pool active, alerting")
+		log.Printf("WARNING %s or more of connection pool %s active, alerting", percent(thresholds.PoolActiveCritical), pool)
 		return pagerduty.NewTriggerEvent("vidispine-database",
 			m.IntegrationKey,
 			pagerduty.SeverityCritical,
-			"vidispine-database-pool",
-			"Active database connections account for over 90% of pool capacity, failure is imminent",
+			dedupKey,
+			fmt.Sprintf("Active database connections account for over %s of %s pool capacity, failure is imminent", percent(thresholds.PoolActiveCritical), pool),
 			&nowTime)
 	}
 
-	if (poolIdle.MustFloat() + poolActive.MustFloat()) > 0.8*poolSizeTotal.MustFloat() {
+	if (poolIdle.MustFloat() + poolActive.MustFloat()) > thresholds.PoolInUseWarning*poolSizeTotal.MustFloat() {
 		nowTime := time.Now()
-		log.Print("WARNING 80% or more of connection pool capacity is either idle or active, alerting")
+		log.Printf("WARNING %s or more of connection pool %s capacity is either idle or active, alerting", percent(thresholds.PoolInUseWarning), pool)
 		return pagerduty.NewTriggerEvent("vidispine-database",
 			m.IntegrationKey,
 			pagerduty.SeverityWarning,
-			"vidispine-database-pool",
-			"Spare database connection pool capacity (neither active nor idle) is less than 20%",
+			dedupKey,
+			fmt.Sprintf("Spare %s connection pool capacity (neither active nor idle) is less than %s", pool, percent(1-thresholds.PoolInUseWarning)),
 			&nowTime)
 	}
 	return nil
@@ -145,28 +193,29 @@ func (m VSMetricCheck) CheckHeapUsage(metrics *MetricsResponse, verboseMode bool
 		log.Printf("INFO (verbose) vsmetriccheck.CheckHeapUsage JVM heap usage is %.1f%%", heapUsage.MustFloat()*100)
 	}
 
-	if heapUsage.MustFloat() > 0.9 {
+	thresholds := m.Thresholds.WithDefaults()
+	if heapUsage.MustFloat() > thresholds.HeapCritical {
 		nowTime := time.Now()
-		log.Print("WARNING heap usage is at 90%, alerting")
+		log.Printf("WARNING heap usage is at %s, alerting", percent(thresholds.HeapCritical))
 		return pagerduty.NewTriggerEvent(
 			"vidispine-heap",
 			m.IntegrationKey,
 			pagerduty.SeverityCritical,
 			"vidispine-heap",
-			"Vidispine heap RAM usage is at 90%, failure is likely. Pod needs restarting and RAM allocation re-assessing",
+			fmt.Sprintf("Vidispine heap RAM usage is at %s, failure is likely. Pod needs restarting and RAM allocation re-assessing", percent(thresholds.HeapCritical)),
 			&nowTime,
 		)
 	}
 
-	if heapUsage.MustFloat() > 0.8 {
+	if heapUsage.MustFloat() > thresholds.HeapWarning {
 		nowTime := time.Now()
-		log.Print("WARNING heap usage is at 80%, alerting")
+		log.Printf("WARNING heap usage is at %s, alerting", percent(thresholds.HeapWarning))
 		return pagerduty.NewTriggerEvent(
 			"vidispine-heap",
 			m.IntegrationKey,
 			pagerduty.SeverityWarning,
 			"vidispine-heap",
-			"Vidispine heap RAM usage is at 80%, monitor and update RAM allocation before failures are likely",
+			fmt.Sprintf("Vidispine heap RAM usage is at %s, monitor and update RAM allocation before failures are likely", percent(thresholds.HeapWarning)),
 			&nowTime,
 		)
 	}
@@ -192,36 +241,37 @@ func (m VSMetricCheck) CheckExcessive500s(metrics *MetricsResponse, verboseMode
 	if verboseMode {
 		log.Printf("INFO (verbose) vsmetriccheck.CheckExcessive500s 500 rate over 15mins is %0.1f%%, over 5mins is %0.1f%%", longCheck.MustFloat()*100, medCheck.MustFloat()*100)
 	}
-	if haveShortCheck && shortCheck.MustFloat() > 0.95 {
+	thresholds := m.Thresholds.WithDefaults()
+	if haveShortCheck && shortCheck.MustFloat() > thresholds.Errors5xx1mError {
 		nowTime := time.Now()
-		log.Print("WARNING 95% of responses in last minute were 5xx, alerting")
+		log.Printf("WARNING %s of responses in last minute were 5xx, alerting", percent(thresholds.Errors5xx1mError))
 		return pagerduty.NewTriggerEvent("vidispine-5xx",
 			m.IntegrationKey,
 			pagerduty.SeverityError,
 			"vidispine-5xx",
-			"95% of responses in the last minute were 5xx, needs investigation",
+			fmt.Sprintf("%s of responses in the last minute were 5xx, needs investigation", percent(thresholds.Errors5xx1mError)),
 			&nowTime)
 	}
 
-	if medCheck.MustFloat() > 0.6 {
+	if medCheck.MustFloat() > thresholds.Errors5xx5mWarning {
 		nowTime := time.Now()
-		log.Print("WARNING 60% of responses in last 5mins were 5xx, alerting")
+		log.Printf("WARNING %s of responses in last 5mins were 5xx, alerting", percent(thresholds.Errors5xx5mWarning))
 		return pagerduty.NewTriggerEvent("vidispine-5xx",
 			m.IntegrationKey,
 			pagerduty.SeverityWarning,
 			"vidispine-5xx",
-			"60% of responses in last 5mins were 5xx, needs investigation",
+			fmt.Sprintf("%s of responses in last 5mins were 5xx, needs investigation", percent(thresholds.Errors5xx5mWarning)),
 			&nowTime)
 	}
 
-	if longCheck.MustFloat() > 0.4 {
+	if longCheck.MustFloat() > thresholds.Errors5xx15mWarning {
 		nowTime := time.Now()
-		log.Print("WARNING 40% of responses in last 15mins were 5xx, alerting")
+		log.Printf("WARNING %s of responses in last 15mins were 5xx, alerting", percent(thresholds.Errors5xx15mWarning))
 		return pagerduty.NewTriggerEvent("vidispine-5xx",
 			m.IntegrationKey,
 			pagerduty.SeverityWarning,
 			"vidispine-5xx",
-			"40% of responses in last 15mins were 5xx, needs investigation",
+			fmt.Sprintf("%s of responses in last 15mins were 5xx, needs investigation", percent(thresholds.Errors5xx15mWarning)),
 			&nowTime)
 	}
 
@@ -232,15 +282,14 @@ func (m VSMetricCheck) Run(verboseMode bool) ([]*pagerduty.TriggerEvent, error)
 	metrics, err := m.loadMetrics()
 	if err != nil {
 		log.Print("ERROR could not load metrics from Vidispine admin service: ", err)
-		return nil, err
+		return []*pagerduty.TriggerEvent{
+			vidispine.ErrorAlert(err, "vidispine metrics check", m.IntegrationKey, "vsmetriccheck"),
+		}, err
 	}
 
 	alerts := make([]*pagerduty.TriggerEvent, 0)
 
-	poolAlert := m.CheckDatabasePool(metrics, verboseMode)
-	if poolAlert != nil {
-		alerts = append(alerts, poolAlert)
-	}
+	alerts = append(alerts, m.CheckDatabasePools(metrics, verboseMode)...)
 
 	heapAlert := m.CheckHeapUsage(metrics, verboseMode)
 	if heapAlert != nil {
@@ -252,5 +301,21 @@ func (m VSMetricCheck) Run(verboseMode bool) ([]*pagerduty.TriggerEvent, error)
 		alerts = append(alerts, responsesAlert)
 	}
 
+	alerts = append(alerts, m.CheckJetty(metrics, verboseMode)...)
+	alerts = append(alerts, m.CheckTimers(metrics, verboseMode)...)
+	sampler := m.Sampler
+	if sampler == nil {
+		sampler = NewSampler()
+	}
+	samples := sampler.Record(m.adminEndpoint().BaseUrl, metrics, time.Now())
+	alerts = append(alerts, m.CheckThreads(samples, verboseMode)...)
+	if gcAlert := m.CheckGCTime(samples, verboseMode); gcAlert != nil {
+		alerts = append(alerts, gcAlert)
+	}
+	alerts = append(alerts, m.CheckOldGen(samples, verboseMode)...)
+	alerts = append(alerts, m.CheckMetaspace(samples, verboseMode)...)
+	alerts = append(alerts, m.CheckIndexer(samples, verboseMode)...)
+	alerts = append(alerts, m.CheckRules(samples, verboseMode)...)
+
 	return alerts, nil
 }
@@ -1,11 +1,18 @@
 package vsmetriccheck
 
 import (
+	"fmt"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
 	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vidispine"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
-func TestVSMetricCheck_CheckDatabasePool_normal(t *testing.T) {
+func TestVSMetricCheck_CheckDatabasePools_normal(t *testing.T) {
 	fakeMetrics := &MetricsResponse{
 		Version: "4.0.0",
 		Gauges: map[string]MetricGauge{
@@ -18,13 +25,13 @@ func TestVSMetricCheck_CheckDatabasePool_normal(t *testing.T) {
 	}
 
 	c := VSMetricCheck{VidispineDbName: "vsdb"}
-	result := c.CheckDatabasePool(fakeMetrics, false)
-	if result != nil {
-		t.Error("CheckDatabasePool returned an alert when everything is in order")
+	result := c.CheckDatabasePools(fakeMetrics, false)
+	if len(result) != 0 {
+		t.Errorf("CheckDatabasePools returned alerts %v when everything is in order", result)
 	}
 }
 
-func TestVSMetricCheck_CheckDatabasePool_70pc(t *testing.T) {
+func TestVSMetricCheck_CheckDatabasePools_70pc(t *testing.T) {
 	fakeMetrics := &MetricsResponse{
 		Version: "4.0.0",
 		Gauges: map[string]MetricGauge{
@@ -37,17 +44,15 @@ func TestVSMetricCheck_CheckDatabasePool_70pc(t *testing.T) {
 	}
 
 	c := VSMetricCheck{VidispineDbName: "vsdb"}
-	result := c.CheckDatabasePool(fakeMetrics, false)
-	if result == nil {
-		t.Error("CheckDatabasePool returned no alert at 70% utilization")
-	} else {
-		if result.Payload.Severity != pagerduty.SeverityWarning {
-			t.Errorf("CheckDatabasePool returned severity %s instead of warning for >70%%", result.Payload.Severity)
-		}
+	result := c.CheckDatabasePools(fakeMetrics, false)
+	if len(result) != 1 {
+		t.Errorf("CheckDatabasePools returned %d alerts at 70%% utilization, expected 1", len(result))
+	} else if result[0].DeDupKey != "vidispine-database-pool" || result[0].Payload.Severity != pagerduty.SeverityWarning {
+		t.Errorf("CheckDatabasePools returned %s %s instead of a warning vidispine-database-pool alert for >70%%", result[0].Payload.Severity, result[0].DeDupKey)
 	}
 }
 
-func TestVSMetricCheck_CheckDatabasePool_90pc(t *testing.T) {
+func TestVSMetricCheck_CheckDatabasePools_90pc(t *testing.T) {
 	fakeMetrics := &MetricsResponse{
 		Version: "4.0.0",
 		Gauges: map[string]MetricGauge{
@@ -60,17 +65,15 @@ func TestVSMetricCheck_CheckDatabasePool_90pc(t *testing.T) {
 	}
 
 	c := VSMetricCheck{VidispineDbName: "vsdb"}
-	result := c.CheckDatabasePool(fakeMetrics, false)
-	if result == nil {
-		t.Error("CheckDatabasePool returned no alert at 90% utilization")
-	} else {
-		if result.Payload.Severity != pagerduty.SeverityCritical {
-			t.Errorf("CheckDatabasePool returned severity %s instead of critical for >90%%", result.Payload.Severity)
-		}
+	result := c.CheckDatabasePools(fakeMetrics, false)
+	if len(result) != 1 {
+		t.Errorf("CheckDatabasePools returned %d alerts at 90%% utilization, expected 1", len(result))
+	} else if result[0].DeDupKey != "vidispine-database-pool" || result[0].Payload.Severity != pagerduty.SeverityCritical {
+		t.Errorf("CheckDatabasePools returned %s %s instead of a critical vidispine-database-pool alert for >90%%", result[0].Payload.Severity, result[0].DeDupKey)
 	}
 }
 
-func TestVSMetricCheck_CheckDatabasePool_notfound(t *testing.T) {
+func TestVSMetricCheck_CheckDatabasePools_notfound(t *testing.T) {
 	fakeMetrics := &MetricsResponse{
 		Version: "4.0.0",
 		Gauges: map[string]MetricGauge{
@@ -81,10 +84,10 @@ func TestVSMetricCheck_CheckDatabasePool_notfound(t *testing.T) {
 	}
 
 	c := VSMetricCheck{VidispineDbName: "vsdb"}
-	result := c.CheckDatabasePool(fakeMetrics, false)
+	result := c.CheckDatabasePools(fakeMetrics, false)
 
-	if result != nil {
-		t.Errorf("CheckDatabasePool returned an alert %v when there was no data", result)
+	if len(result) != 0 {
+		t.Errorf("CheckDatabasePools returned alerts %v when there was no data", result)
 	}
 }
 
@@ -221,3 +224,638 @@ func TestVSMetricCheck_CheckExcessive500s_5min(t *testing.T) {
 		}
 	}
 }
+
+func TestVSMetricCheck_CheckTimers(t *testing.T) {
+	fakeMetrics := &MetricsResponse{
+		Version: "4.0.0",
+		Timers: map[string]MetricTimer{
+			"elasticsearch.query.time": {
+				MetricSnapshot: vidispine.MetricSnapshot{Count: 10, P95: 1.5, P99: 12},
+				DurationUnits:  "seconds",
+			},
+			"storage.fs.stat": {
+				MetricSnapshot: vidispine.MetricSnapshot{Count: 10, P95: 800},
+				DurationUnits:  "milliseconds",
+			},
+			"indexer.item.index.time": {
+				MetricSnapshot: vidispine.MetricSnapshot{Count: 0},
+				DurationUnits:  "seconds",
+			},
+		},
+	}
+
+	c := VSMetricCheck{
+		IntegrationKey: "someservice",
+		TimerThresholds: []TimerThreshold{
+			{Timer: "elasticsearch.query.time", Warning: common.Duration{Duration: 2 * time.Second}, Critical: common.Duration{Duration: 10 * time.Second}},
+			{Timer: "elasticsearch.query.time", Percentile: "p95", Warning: common.Duration{Duration: 2 * time.Second}},
+			{Timer: "storage.fs.stat", Percentile: "P95", Warning: common.Duration{Duration: 500 * time.Millisecond}},
+			{Timer: "indexer.item.index.time", Warning: common.Duration{Duration: time.Nanosecond}},
+			{Timer: "not.a.timer", Warning: common.Duration{Duration: time.Second}},
+		},
+	}
+	result := c.CheckTimers(fakeMetrics, false)
+	if len(result) != 2 {
+		t.Errorf("expected 2 alerts, got %v", result)
+		t.FailNow()
+	}
+	if result[0].DeDupKey != "vidispine-timer-elasticsearch.query.time-p99" || result[0].Payload.Severity != pagerduty.SeverityCritical {
+		t.Errorf("got unexpected alert %s %s", result[0].DeDupKey, result[0].Payload.Severity)
+	}
+	if result[1].Payload.Summary != "The p95 of storage.fs.stat is 800ms, over the warning threshold of 500ms" {
+		t.Errorf("got unexpected summary '%s'", result[1].Payload.Summary)
+	}
+}
+
+func TestTimerThreshold_Validate(t *testing.T) {
+	if problems := (TimerThreshold{Timer: "storage.fs.stat", Warning: common.Duration{Duration: time.Second}}).Validate(); len(problems) != 0 {
+		t.Errorf("expected the threshold to be valid, got %v", problems)
+	}
+	problems := TimerThreshold{Percentile: "p90", Warning: common.Duration{Duration: time.Minute}, Critical: common.Duration{Duration: time.Second}}.Validate()
+	if len(problems) != 3 || problems[0] != "timer must be given" || problems[2] != "warning must not be higher than critical" {
+		t.Errorf("got unexpected problems %v", problems)
+	}
+}
+
+func TestParseExpression(t *testing.T) {
+	values := map[string]float64{"active": 9, "idle": 3, "size": 20}
+	tests := []struct {
+		text     string
+		expected float64
+	}{
+		{"active / size", 0.45},
+		{"(active + idle) / size", 0.6},
+		{"active + idle / size", 9.15},
+		{"-active * 2 - -1", -17},
+		{"1 - idle/size", 0.85},
+	}
+	for _, test := range tests {
+		expr, _, err := parseExpression(test.text)
+		if err != nil {
+			t.Errorf("parseExpression(%s) returned an unexpected error: %s", test.text, err)
+			continue
+		}
+		if result := expr.eval(values); result < test.expected-1e-9 || result > test.expected+1e-9 {
+			t.Errorf("%s should be %g, got %g", test.text, test.expected, result)
+		}
+	}
+
+	for _, bad := range []string{"", "active /", "(active", "active size", "active % size", "1.2.3"} {
+		if _, _, err := parseExpression(bad); err == nil {
+			t.Errorf("expected '%s' not to parse", bad)
+		}
+	}
+}
+
+func TestRule_Evaluate(t *testing.T) {
+	fakeMetrics := &MetricsResponse{
+		Gauges: map[string]MetricGauge{
+			"io.dropwizard.db.ManagedPooledDataSource.vidispinedb.size":   {Value: 100.0},
+			"io.dropwizard.db.ManagedPooledDataSource.vidispinedb.active": {Value: 95.0},
+			"io.dropwizard.db.ManagedPooledDataSource.otherdb.size":       {Value: 10.0},
+			"io.dropwizard.db.ManagedPooledDataSource.otherdb.active":     {Value: 8.5},
+			"io.dropwizard.db.ManagedPooledDataSource.lonelydb.size":      {Value: 10.0},
+		},
+		Timers: map[string]MetricTimer{
+			"storage.fs.stat": {MetricSnapshot: vidispine.MetricSnapshot{Count: 5, P95: 1200}, DurationUnits: "milliseconds"},
+		},
+	}
+
+	poolRule := Rule{
+		Name: "database-pool",
+		Metrics: map[string]string{
+			"active": "gauge:io.dropwizard.db.ManagedPooledDataSource.*.active",
+			"size":   "gauge:io.dropwizard.db.ManagedPooledDataSource.*.size",
+		},
+		Value:    "active / size",
+		Warning:  "> 0.8",
+		Critical: ">= 0.9",
+		DedupKey: "vidispine-pool-{{.Match}}",
+		Message:  "Active connections to {{.Match}} are at {{percent .Value}} of {{.Metrics.size}}",
+	}
+	if problems := poolRule.Validate(); len(problems) != 0 {
+		t.Errorf("expected the rule to be valid, got %v", problems)
+	}
+	alerts, err := poolRule.Evaluate(Samples{Current: Sample{Metrics: fakeMetrics}}, "someservice", false)
+	if err != nil {
+		t.Error("Evaluate returned an unexpected error: ", err)
+		t.FailNow()
+	}
+	if len(alerts) != 2 {
+		t.Errorf("expected an alert for each database, got %v", alerts)
+		t.FailNow()
+	}
+	if alerts[0].DeDupKey != "vidispine-pool-otherdb" || alerts[0].Payload.Severity != pagerduty.SeverityWarning ||
+		alerts[0].Payload.Summary != "Active connections to otherdb are at 85% of 10" {
+		t.Errorf("got unexpected alert %s %s '%s'", alerts[0].DeDupKey, alerts[0].Payload.Severity, alerts[0].Payload.Summary)
+	}
+	if alerts[1].DeDupKey != "vidispine-pool-vidispinedb" || alerts[1].Payload.Severity != pagerduty.SeverityCritical {
+		t.Errorf("got unexpected alert %s %s", alerts[1].DeDupKey, alerts[1].Payload.Severity)
+	}
+
+	statRule := Rule{Name: "slow-stat", Metrics: map[string]string{"stat": "timer:storage.fs.stat:p95"}, Warning: "> 1"}
+	statAlerts, _ := statRule.Evaluate(Samples{Current: Sample{Metrics: fakeMetrics}}, "someservice", false)
+	if len(statAlerts) != 1 || statAlerts[0].DeDupKey != "vidispine-rule-slow-stat" ||
+		statAlerts[0].Payload.Summary != "slow-stat is 1.2, which is > 1" || statAlerts[0].Payload.Component != "vidispine-metrics" {
+		t.Errorf("got unexpected alerts %v", statAlerts)
+	}
+
+	missingRule := Rule{Name: "missing", Metrics: map[string]string{"heap": "gauge:jvm.memory.heap.usage"}, Warning: "> 0.8"}
+	if _, missingErr := missingRule.Evaluate(Samples{Current: Sample{Metrics: fakeMetrics}}, "someservice", false); missingErr == nil {
+		t.Error("expected a missing metric to be an error")
+	}
+}
+
+func TestRule_Validate(t *testing.T) {
+	rule := Rule{
+		Name:     "Bad Name",
+		Metrics:  map[string]string{"heap": "gauges:jvm.memory.heap.usage", "rate": "meter:requests:p99"},
+		Value:    "heap / size",
+		Critical: "over 0.9",
+		Message:  "{{.Value",
+	}
+	expected := []string{
+		"name 'Bad Name' must be lower case letters, numbers, '.', '-' and '_'",
+		"metrics.heap: 'gauges' is not one of gauge, counter, meter, histogram or timer",
+		"metrics.rate: meter has no field p99",
+		"value uses 'size', which is not one of the metrics",
+		"critical: 'over 0.9' must start with one of >= <= == != > <",
+	}
+	problems := rule.Validate()
+	if len(problems) != len(expected)+1 {
+		t.Errorf("got unexpected problems %v", problems)
+		t.FailNow()
+	}
+	for i, problem := range expected {
+		if problems[i] != problem {
+			t.Errorf("expected '%s', got '%s'", problem, problems[i])
+		}
+	}
+	if !strings.HasPrefix(problems[len(expected)], "message is not a valid template") {
+		t.Errorf("expected the message template to be reported, got '%s'", problems[len(expected)])
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected metricSelector
+	}{
+		{"gauge:jvm.memory.heap.usage", metricSelector{"gauge", "jvm.memory.heap.usage", "value"}},
+		{"timer:elasticsearch.query.time:P95", metricSelector{"timer", "elasticsearch.query.time", "p95"}},
+		{"gauge:com.example:type=Cache,name=items", metricSelector{"gauge", "com.example:type=Cache,name=items", "value"}},
+		{"counter:cache:hits:count", metricSelector{"counter", "cache:hits", "count"}},
+		{"meter:cache:requests:*:delta", metricSelector{"meter", "cache:requests:*", "delta"}},
+	}
+	for _, test := range tests {
+		selector, err := parseSelector(test.text)
+		if err != nil || selector != test.expected {
+			t.Errorf("%s: expected %v, got %v %v", test.text, test.expected, selector, err)
+		}
+	}
+
+	for _, text := range []string{"gauge", "gauge:", "gauge::value", "gauge:cache:hits", "gauge:a*:b*"} {
+		if _, err := parseSelector(text); err == nil {
+			t.Errorf("%s: expected an error", text)
+		}
+	}
+
+	selector, _ := parseSelector("gauge:com.example:type=Cache,name=*")
+	metrics := &MetricsResponse{Gauges: map[string]MetricGauge{"com.example:type=Cache,name=items": {Value: 12.0}}}
+	if match, matched := selector.match("com.example:type=Cache,name=items"); !matched || match != "items" {
+		t.Errorf("expected the name with colons to match, got %s %v", match, matched)
+	}
+	if value, err := selector.read(metrics, "com.example:type=Cache,name=items"); err != nil || value != 12 {
+		t.Errorf("expected to read 12, got %g %v", value, err)
+	}
+}
+
+func TestSampler(t *testing.T) {
+	sampler := NewSampler()
+	start := time.Date(2021, 3, 11, 12, 0, 0, 0, time.UTC)
+	poll := func(failed int64, gcCount float64, at time.Time) Samples {
+		return sampler.Record("vs1", &MetricsResponse{
+			Counters: map[string]MetricCounter{"job.total.failed_total": {Count: failed}},
+			Gauges:   map[string]MetricGauge{"jvm.gc.G1-Old-Generation.count": {Value: gcCount}},
+		}, at)
+	}
+
+	first := poll(100, 4, start)
+	if _, err := first.CounterDelta("job.total.failed_total"); err != ErrNoPreviousSample {
+		t.Errorf("expected no delta from the first poll, got %v", err)
+	}
+
+	second := poll(115, 6, start.Add(5*time.Minute))
+	failed, err := second.CounterDelta("job.total.failed_total")
+	if err != nil || failed.Change != 15 || failed.Interval != 5*time.Minute || failed.Reset || failed.Rate() != 0.05 {
+		t.Errorf("got unexpected delta %v (%v)", failed, err)
+	}
+	if gc, _ := second.GaugeDelta("jvm.gc.G1-Old-Generation.count"); gc.Change != 2 {
+		t.Errorf("got unexpected gauge delta %v", gc)
+	}
+	if _, missingErr := second.MeterDelta("not.a.meter"); missingErr == nil || missingErr == ErrNoPreviousSample {
+		t.Errorf("expected a missing meter to be an error, got %v", missingErr)
+	}
+
+	//vidispine restarted, so the counter started again from zero
+	third := poll(3, 1, start.Add(10*time.Minute))
+	if reset, _ := third.CounterDelta("job.total.failed_total"); !reset.Reset || reset.Change != 3 {
+		t.Errorf("expected the reset to be counted from zero, got %v", reset)
+	}
+
+	if other := sampler.Record("vs2", &MetricsResponse{}, start.Add(10*time.Minute)); other.HavePrevious() {
+		t.Error("samples from another host should not be compared")
+	}
+
+	//vs1 stops being polled, e.g. it left the cluster
+	sampler.Record("vs2", &MetricsResponse{}, start.Add(10*time.Minute+DefaultForgetAfter+time.Second))
+	if hosts := sampler.Hosts(); len(hosts) != 1 || hosts[0] != "vs2" {
+		t.Errorf("expected the samples from vs1 to be dropped, still have %v", hosts)
+	}
+}
+
+func TestVSMetricCheck_Run_deltaRule(t *testing.T) {
+	failed := 100
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"counters": {"job.total.failed_total": {"count": %d}}}`, failed)
+	}))
+	defer server.Close()
+
+	sampler := NewSampler()
+	newCheck := func() VSMetricCheck {
+		return VSMetricCheck{
+			Admin:          common.Endpoint{BaseUrl: server.URL},
+			IntegrationKey: "someservice",
+			Sampler:        sampler,
+			Rules: []Rule{{
+				Name:     "failed-jobs",
+				Metrics:  map[string]string{"failed": "counter:job.total.failed_total:delta"},
+				Warning:  ">= 10",
+				DedupKey: "vidispine-failed-jobs",
+				Message:  "{{.Value}} jobs failed since the last poll",
+			}},
+		}
+	}
+	findAlert := func(alerts []*pagerduty.TriggerEvent) *pagerduty.TriggerEvent {
+		for _, alert := range alerts {
+			if alert.DeDupKey == "vidispine-failed-jobs" {
+				return alert
+			}
+		}
+		return nil
+	}
+
+	first, _ := newCheck().Run(false)
+	if findAlert(first) != nil {
+		t.Error("a delta rule should not alert on the first poll")
+	}
+
+	//a check rebuilt on reload carries on from the previous poll
+	failed = 115
+	second, _ := newCheck().Run(false)
+	if alert := findAlert(second); alert == nil || alert.Payload.Summary != "15 jobs failed since the last poll" {
+		t.Errorf("expected an alert for the failed jobs, got %v", second)
+	}
+}
+
+/**
+returns a metrics response with just the given gauges
+*/
+func gaugeMetrics(values map[string]interface{}) *MetricsResponse {
+	gauges := make(map[string]MetricGauge, len(values))
+	for name, value := range values {
+		gauges[name] = MetricGauge{Value: value}
+	}
+	return &MetricsResponse{Gauges: gauges}
+}
+
+func threadMetrics(total float64, blocked float64, deadlocks ...interface{}) *MetricsResponse {
+	return gaugeMetrics(map[string]interface{}{
+		"jvm.threads.count":          total,
+		"jvm.threads.blocked.count":  blocked,
+		"jvm.threads.deadlock.count": float64(len(deadlocks)),
+		"jvm.threads.deadlocks":      append([]interface{}{}, deadlocks...),
+	})
+}
+
+func alertKeys(alerts []*pagerduty.TriggerEvent) []string {
+	keys := make([]string, 0, len(alerts))
+	for _, alert := range alerts {
+		keys = append(keys, alert.DeDupKey)
+	}
+	return keys
+}
+
+func TestVSMetricCheck_CheckThreads(t *testing.T) {
+	c := VSMetricCheck{ThreadThresholds: ThreadThresholds{BlockedWarning: 5, TotalWarning: 200}}
+
+	normal := c.CheckThreads(Samples{Current: Sample{Metrics: threadMetrics(150, 2)}}, false)
+	if len(normal) != 0 {
+		t.Errorf("CheckThreads returned %v when everything is in order", alertKeys(normal))
+	}
+
+	busy := c.CheckThreads(Samples{Current: Sample{Metrics: threadMetrics(250, 8)}}, false)
+	if keys := strings.Join(alertKeys(busy), ","); keys != "vidispine-threads-blocked,vidispine-threads-total" {
+		t.Errorf("expected blocked and total warnings, got %s", keys)
+	}
+	for _, alert := range busy {
+		if alert.Payload.Severity != pagerduty.SeverityWarning {
+			t.Errorf("%s should be a warning, got %s", alert.DeDupKey, alert.Payload.Severity)
+		}
+	}
+
+	deadlocked := c.CheckThreads(Samples{Current: Sample{Metrics: threadMetrics(150, 2,
+		"dw-42 - POST /API/item locked on java.lang.Object@1b6d3586 (owned by dw-43):\n\tat com.vidispine.Foo.bar(Foo.java:10)\n",
+		"dw-43 - PUT /API/item locked on java.lang.Object@4554617c (owned by dw-42):\n\tat com.vidispine.Foo.baz(Foo.java:20)\n",
+	)}}, false)
+	if len(deadlocked) != 1 || deadlocked[0].DeDupKey != "vidispine-threads-deadlock" || deadlocked[0].Payload.Severity != pagerduty.SeverityCritical {
+		t.Fatalf("expected a critical deadlock alert, got %v", alertKeys(deadlocked))
+	}
+	if summary := deadlocked[0].Payload.Summary; !strings.HasSuffix(summary, ": dw-42 - POST /API/item, dw-43 - PUT /API/item") {
+		t.Errorf("deadlock alert did not name the threads: %s", summary)
+	}
+
+	missing := c.CheckThreads(Samples{Current: Sample{Metrics: &MetricsResponse{}}}, false)
+	if len(missing) != 0 {
+		t.Errorf("CheckThreads returned %v without any thread metrics", alertKeys(missing))
+	}
+}
+
+func TestVSMetricCheck_CheckThreads_growth(t *testing.T) {
+	sampler := NewSampler()
+	c := VSMetricCheck{ThreadThresholds: ThreadThresholds{GrowthPolls: 3}}
+	start := time.Date(2021, 3, 11, 12, 0, 0, 0, time.UTC)
+
+	var alerts []*pagerduty.TriggerEvent
+	for i, total := range []float64{150, 140, 145, 150, 151} {
+		samples := sampler.Record("vs1", threadMetrics(total, 0), start.Add(time.Duration(i)*time.Minute))
+		alerts = c.CheckThreads(samples, false)
+		if i < 4 && len(alerts) != 0 {
+			t.Errorf("poll %d should not have alerted yet, got %v", i, alertKeys(alerts))
+		}
+	}
+	if len(alerts) != 1 || alerts[0].DeDupKey != "vidispine-threads-growth" {
+		t.Fatalf("expected a growth warning after 3 rises in a row, got %v", alertKeys(alerts))
+	}
+	if !strings.Contains(alerts[0].Payload.Summary, "from 140 to 151") {
+		t.Errorf("growth alert did not say how far the count went up: %s", alerts[0].Payload.Summary)
+	}
+	if off := (VSMetricCheck{}).CheckThreads(sampler.Record("vs1", threadMetrics(152, 0), start.Add(5*time.Minute)), false); len(off) != 0 {
+		t.Errorf("growth_polls of zero should turn the growth alert off, got %v", alertKeys(off))
+	}
+
+	//a drop ends the run
+	if dropped := c.CheckThreads(sampler.Record("vs1", threadMetrics(149, 0), start.Add(6*time.Minute)), false); len(dropped) != 0 {
+		t.Errorf("expected no growth warning once the count dropped, got %v", alertKeys(dropped))
+	}
+}
+
+func memoryMetrics(gcMillis float64, oldGenAfterGC float64, metaspace float64) *MetricsResponse {
+	return gaugeMetrics(map[string]interface{}{
+		"jvm.gc.Copy.time":                           600.0,
+		"jvm.gc.MarkSweepCompact.time":               gcMillis,
+		"jvm.memory.pools.Eden-Space.used-after-gc":  0.0,
+		"jvm.memory.pools.Tenured-Gen.used-after-gc": oldGenAfterGC,
+		"jvm.memory.pools.Tenured-Gen.max":           1000.0,
+		"jvm.memory.pools.Metaspace.used":            metaspace,
+		"jvm.memory.pools.Metaspace.max":             -1.0,
+	})
+}
+
+func TestVSMetricCheck_CheckGCTime(t *testing.T) {
+	c := VSMetricCheck{}
+	start := time.Date(2021, 3, 11, 12, 0, 0, 0, time.UTC)
+	previous := Sample{Metrics: memoryMetrics(1000, 100, 100), At: start}
+	gcAfter := func(gcMillis float64) *pagerduty.TriggerEvent {
+		return c.CheckGCTime(Samples{Previous: previous, Current: Sample{Metrics: memoryMetrics(gcMillis, 100, 100), At: start.Add(5 * time.Minute)}}, false)
+	}
+
+	if alert := c.CheckGCTime(Samples{Current: previous}, false); alert != nil {
+		t.Error("CheckGCTime should not alert without a previous poll")
+	}
+	if alert := gcAfter(1000 + 10000); alert != nil {
+		t.Errorf("10s of GC in 5 minutes should not alert, got %s", alert.Payload.Summary)
+	}
+	warning := gcAfter(1000 + 40000)
+	if warning == nil || warning.Payload.Severity != pagerduty.SeverityWarning || warning.DeDupKey != "vidispine-gc-time" {
+		t.Fatalf("expected a warning for 40s of GC in 5 minutes, got %v", warning)
+	}
+	if !strings.Contains(warning.Payload.Summary, "spent 13% of the last 5m0s in garbage collection (MarkSweepCompact 40s)") {
+		t.Errorf("unexpected summary %s", warning.Payload.Summary)
+	}
+	if critical := gcAfter(1000 + 120000); critical == nil || critical.Payload.Severity != pagerduty.SeverityCritical {
+		t.Errorf("expected a critical alert for 2 minutes of GC in 5 minutes, got %v", critical)
+	}
+}
+
+func TestVSMetricCheck_CheckOldGen(t *testing.T) {
+	c := VSMetricCheck{MemoryThresholds: MemoryThresholds{LeakWindow: 4}}
+	sampler := NewSampler()
+	start := time.Date(2021, 3, 11, 12, 0, 0, 0, time.UTC)
+
+	full := c.CheckOldGen(sampler.Record("vs1", memoryMetrics(0, 850, 100), start), false)
+	if len(full) != 1 || full[0].DeDupKey != "vidispine-old-gen" || full[0].Payload.Severity != pagerduty.SeverityWarning {
+		t.Errorf("expected an old gen warning at 85%% after GC, got %v", alertKeys(full))
+	}
+
+	sampler = NewSampler()
+	var alerts []*pagerduty.TriggerEvent
+	//a dip part way through doesn't hide the trend
+	for i, afterGC := range []float64{300, 320, 310, 380, 420} {
+		alerts = c.CheckOldGen(sampler.Record("vs1", memoryMetrics(0, afterGC, 100), start.Add(time.Duration(i)*5*time.Minute)), false)
+		if i < 4 && len(alerts) != 0 {
+			t.Errorf("poll %d should not have alerted yet, got %v", i, alertKeys(alerts))
+		}
+	}
+	if len(alerts) != 1 || alerts[0].DeDupKey != "vidispine-memory-leak" {
+		t.Fatalf("expected a leak warning, got %v", alertKeys(alerts))
+	}
+
+	steady := NewSampler()
+	for i, afterGC := range []float64{300, 450, 280, 310, 300} {
+		alerts = c.CheckOldGen(steady.Record("vs1", memoryMetrics(0, afterGC, 100), start.Add(time.Duration(i)*5*time.Minute)), false)
+	}
+	if len(alerts) != 0 {
+		t.Errorf("a level series with a spike should not look like a leak, got %v", alertKeys(alerts))
+	}
+}
+
+func TestVSMetricCheck_CheckMetaspace(t *testing.T) {
+	c := VSMetricCheck{MemoryThresholds: MemoryThresholds{LeakWindow: 3}}
+	sampler := NewSampler()
+	start := time.Date(2021, 3, 11, 12, 0, 0, 0, time.UTC)
+
+	var alerts []*pagerduty.TriggerEvent
+	for i, used := range []float64{100, 110, 120, 130} {
+		alerts = c.CheckMetaspace(sampler.Record("vs1", memoryMetrics(0, 300, used), start.Add(time.Duration(i)*5*time.Minute)), false)
+	}
+	if len(alerts) != 1 || alerts[0].DeDupKey != "vidispine-metaspace-growth" {
+		t.Errorf("expected a Metaspace growth warning, got %v", alertKeys(alerts))
+	}
+
+	bounded := memoryMetrics(0, 300, 95)
+	bounded.Gauges["jvm.memory.pools.Metaspace.max"] = MetricGauge{Value: 100.0}
+	if alerts := c.CheckMetaspace(Samples{Current: Sample{Metrics: bounded}}, false); len(alerts) != 1 || alerts[0].DeDupKey != "vidispine-metaspace" {
+		t.Errorf("expected a Metaspace warning at 95%% of its max, got %v", alertKeys(alerts))
+	}
+}
+
+func jettyMetrics(dwUtilization float64, dwQueue float64, adminUtilization float64, activeRequests int64) *MetricsResponse {
+	metrics := gaugeMetrics(map[string]interface{}{
+		"org.eclipse.jetty.util.thread.QueuedThreadPool.dw.utilization":                  0.78,
+		"org.eclipse.jetty.util.thread.QueuedThreadPool.dw.utilization-max":              dwUtilization,
+		"org.eclipse.jetty.util.thread.QueuedThreadPool.dw.jobs":                         dwQueue * 1024,
+		"org.eclipse.jetty.util.thread.QueuedThreadPool.dw.jobs-queue-utilization":       dwQueue,
+		"org.eclipse.jetty.util.thread.QueuedThreadPool.dw-admin.utilization-max":        adminUtilization,
+		"org.eclipse.jetty.util.thread.QueuedThreadPool.dw-admin.jobs":                   0.0,
+		"org.eclipse.jetty.util.thread.QueuedThreadPool.dw-admin.jobs-queue-utilization": 0.0,
+	})
+	metrics.Counters = map[string]MetricCounter{
+		"io.dropwizard.jetty.MutableServletContextHandler.active-requests":  {Count: activeRequests},
+		"io.dropwizard.jetty.MutableServletContextHandler.active-suspended": {Count: 0},
+	}
+	return metrics
+}
+
+func TestVSMetricCheck_CheckJetty(t *testing.T) {
+	c := VSMetricCheck{}
+	if alerts := c.CheckJetty(jettyMetrics(0.01, 0, 0.06, 3), false); len(alerts) != 0 {
+		t.Errorf("CheckJetty returned %v when everything is in order", alertKeys(alerts))
+	}
+
+	saturated := c.CheckJetty(jettyMetrics(0.97, 0.2, 0.85, 700), false)
+	expected := map[string]pagerduty.Severity{
+		"vidispine-jetty-dw":       pagerduty.SeverityCritical,
+		"vidispine-jetty-dw-queue": pagerduty.SeverityWarning,
+		"vidispine-jetty-dw-admin": pagerduty.SeverityWarning,
+		"vidispine-jetty-requests": pagerduty.SeverityWarning,
+	}
+	if len(saturated) != len(expected) {
+		t.Errorf("expected %d alerts, got %v", len(expected), alertKeys(saturated))
+	}
+	for _, alert := range saturated {
+		if severity, ok := expected[alert.DeDupKey]; !ok || alert.Payload.Severity != severity {
+			t.Errorf("unexpected %s alert %s: %s", alert.Payload.Severity, alert.DeDupKey, alert.Payload.Summary)
+		}
+	}
+
+	onlyAdmin := VSMetricCheck{JettyThresholds: JettyThresholds{Pools: []string{"dw-admin"}, UtilizationWarning: 0.9}}
+	if alerts := onlyAdmin.CheckJetty(jettyMetrics(0.97, 0, 0.85, 0), false); len(alerts) != 0 {
+		t.Errorf("only the configured pools should be checked, against their thresholds, got %v", alertKeys(alerts))
+	}
+}
+
+func TestVSMetricCheck_CheckDatabasePools(t *testing.T) {
+	fakeMetrics := &MetricsResponse{
+		Gauges: map[string]MetricGauge{
+			"io.dropwizard.db.ManagedPooledDataSource.vsdb.size":        {Value: 100.0},
+			"io.dropwizard.db.ManagedPooledDataSource.vsdb.idle":        {Value: 20.0},
+			"io.dropwizard.db.ManagedPooledDataSource.vsdb.active":      {Value: 8.0},
+			"io.dropwizard.db.ManagedPooledDataSource.audit-log.size":   {Value: 10.0},
+			"io.dropwizard.db.ManagedPooledDataSource.audit-log.idle":   {Value: 0.0},
+			"io.dropwizard.db.ManagedPooledDataSource.audit-log.active": {Value: 10.0},
+		},
+	}
+	if pools := strings.Join(DatabasePools(fakeMetrics), ","); pools != "audit-log,vsdb" {
+		t.Errorf("expected both pools to be found, got %s", pools)
+	}
+
+	alerts := VSMetricCheck{VidispineDbName: "vsdb"}.CheckDatabasePools(fakeMetrics, false)
+	if len(alerts) != 1 || alerts[0].DeDupKey != "vidispine-database-pool-audit-log" || alerts[0].Payload.Severity != pagerduty.SeverityCritical {
+		t.Errorf("expected a critical alert for the extra pool, got %v", alertKeys(alerts))
+	}
+
+	misnamed := VSMetricCheck{VidispineDbName: "vidispinedb"}.CheckDatabasePools(fakeMetrics, false)
+	if keys := strings.Join(alertKeys(misnamed), ","); keys != "vidispine-database-pool-audit-log,vidispine-database-pool-missing" {
+		t.Fatalf("expected the pools to be checked and the missing one reported, got %s", keys)
+	}
+	if summary := misnamed[1].Payload.Summary; !strings.Contains(summary, "vidispinedb") || !strings.Contains(summary, "found audit-log, vsdb") {
+		t.Errorf("missing pool alert should say which pools there are: %s", summary)
+	}
+}
+
+func indexerMetrics(itemRequests int64, itemsIndexed int64, failed int64, itemP99 float64) *MetricsResponse {
+	return &MetricsResponse{
+		Meters: map[string]MetricMeter{
+			"indexer.item.requests":                {Count: itemRequests},
+			"indexer.acl.requests":                 {Count: 100},
+			"indexer.elasticsearch.request.failed": {Count: failed},
+		},
+		Timers: map[string]MetricTimer{
+			"indexer.item.index.time":  {MetricSnapshot: MetricSnapshot{Count: itemsIndexed, P99: itemP99}, DurationUnits: "seconds"},
+			"indexer.acl.index.time":   {MetricSnapshot: MetricSnapshot{Count: 100, P99: 0.00005}, DurationUnits: "seconds"},
+			"elasticsearch.query.time": {MetricSnapshot: MetricSnapshot{Count: 2, P99: 0.37}, DurationUnits: "seconds"},
+		},
+	}
+}
+
+func TestVSMetricCheck_CheckIndexer(t *testing.T) {
+	c := VSMetricCheck{IndexerThresholds: DefaultIndexerThresholds}
+	start := time.Date(2021, 3, 11, 12, 0, 0, 0, time.UTC)
+	sampler := NewSampler()
+	poll := func(i int, metrics *MetricsResponse) []*pagerduty.TriggerEvent {
+		return c.CheckIndexer(sampler.Record("vs1", metrics, start.Add(time.Duration(i)*5*time.Minute)), false)
+	}
+
+	if alerts := poll(0, indexerMetrics(2500, 2500, 0, 0.00005)); len(alerts) != 0 {
+		t.Errorf("CheckIndexer returned %v when everything is in order", alertKeys(alerts))
+	}
+
+	//item requests keep coming in but nothing is indexed, and some requests fail
+	if alerts := poll(1, indexerMetrics(2600, 2500, 2, 0.00005)); len(alerts) != 0 {
+		t.Errorf("2 failures in 100 requests, after one poll without indexing, should not alert yet, got %v", alertKeys(alerts))
+	}
+	stalled := poll(2, indexerMetrics(2700, 2500, 40, 0.00005))
+	if keys := strings.Join(alertKeys(stalled), ","); keys != "vidispine-indexer-failed,vidispine-indexer-stalled-item" {
+		t.Fatalf("expected failed and stalled alerts, got %s", keys)
+	}
+	if stalled[0].Payload.Severity != pagerduty.SeverityCritical {
+		t.Errorf("38 failures in 100 requests should be critical, got %s", stalled[0].Payload.Severity)
+	}
+	if summary := stalled[1].Payload.Summary; !strings.Contains(summary, "received 200 requests to index item over the last 2 polls") {
+		t.Errorf("unexpected stalled summary %s", summary)
+	}
+
+	slow := poll(3, indexerMetrics(2800, 2800, 40, 3))
+	if len(slow) != 1 || slow[0].DeDupKey != "vidispine-indexer-slow-item" || slow[0].Payload.Severity != pagerduty.SeverityWarning {
+		t.Errorf("expected a warning for slow item indexing, got %v", alertKeys(slow))
+	}
+
+	//nothing to index, so any failures are too few to judge a rate by
+	if quiet := poll(4, indexerMetrics(2800, 2800, 46, 0.00005)); len(quiet) != 0 {
+		t.Errorf("failures with no indexer requests should not alert, got %v", alertKeys(quiet))
+	}
+	if few := poll(5, indexerMetrics(2810, 2810, 49, 0.00005)); len(few) != 0 {
+		t.Errorf("3 failures in 10 requests are under failed_minimum and should not alert, got %v", alertKeys(few))
+	}
+}
+
+func TestThresholds_Validate(t *testing.T) {
+	tests := []struct {
+		name       string
+		thresholds interface{ Validate() []string }
+		problems   int
+	}{
+		{"default", DefaultThresholds, 0},
+		{"default threads", DefaultThreadThresholds, 0},
+		{"default memory", DefaultMemoryThresholds, 0},
+		{"default jetty", DefaultJettyThresholds, 0},
+		{"default indexer", DefaultIndexerThresholds, 0},
+		{"unset", Thresholds{}, 0},
+		{"heap above default critical", Thresholds{HeapWarning: 0.95, PoolActiveCritical: 1.5}, 2},
+		{"threads", ThreadThresholds{BlockedWarning: -1, GrowthPolls: DefaultHistory + 1}, 2},
+		{"thread growth off", ThreadThresholds{GrowthPolls: 0}, 0},
+		{"memory", MemoryThresholds{GCTimeWarning: 0.5, GCTimeCritical: 0.4, LeakWindow: 1, OldGenCritical: 2}, 3},
+		{"memory trends off", MemoryThresholds{LeakWindow: 0}, 0},
+		{"jetty", JettyThresholds{Pools: []string{""}, QueueWarning: 0.6, ActiveRequests: -1}, 3},
+		{"indexer", IndexerThresholds{Percentile: "p90", IndexWarning: common.Duration{Duration: time.Minute}, FailedCritical: 2}, 3},
+		{"indexer stalls off", IndexerThresholds{StalledPolls: 0}, 0},
+		{"indexer stalls negative", IndexerThresholds{StalledPolls: -1}, 1},
+		{"indexer failed minimum negative", IndexerThresholds{FailedMinimum: -1}, 1},
+	}
+	for _, test := range tests {
+		if problems := test.thresholds.Validate(); len(problems) != test.problems {
+			t.Errorf("%s: expected %d problems, got %v", test.name, test.problems, problems)
+		}
+	}
+}
@@ -1,51 +1,29 @@
 package vsstoragecheck
 
-type VSStorageMethod struct {
-	Id          string `json:"id"`
-	Uri         string `json:"uri"`
-	Read        bool   `json:"read"`
-	Write       bool   `json:"write"`
-	Browse      bool   `json:"browse"`
-	LastSuccess string `json:"lastSuccess"`
-	Type        string `json:"type"`
-}
+import "gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vidispine"
 
-// see https://apidoc.vidispine.com/latest/storage/storage.html#id1
-type VSStorageType string
+// the responses are read by the vidispine client, these names are kept for the existing code
+type VSStorageMethod = vidispine.StorageMethod
+type VSStorageType = vidispine.StorageType
+type VSStorageState = vidispine.StorageState
+type VSStorage = vidispine.Storage
+type VSStoragesResponse = vidispine.StoragesResponse
 
 const (
-	LocalStorage    VSStorageType = "LOCAL"
-	SharedStorage   VSStorageType = "SHARED"
-	RemoteStorage   VSStorageType = "REMOTE"
-	ExternalStorage VSStorageType = "EXTERNAL"
-	ArchiveStorage  VSStorageType = "ARCHIVE"
-	ExportStorage   VSStorageType = "EXPORT"
+	LocalStorage    = vidispine.LocalStorage
+	SharedStorage   = vidispine.SharedStorage
+	RemoteStorage   = vidispine.RemoteStorage
+	ExternalStorage = vidispine.ExternalStorage
+	ArchiveStorage  = vidispine.ArchiveStorage
+	ExportStorage   = vidispine.ExportStorage
 )
 
-type VSStorageState string
-
 const (
-	StorageStateNone       VSStorageState = "NONE"
-	StorageStateReady      VSStorageState = "READY"
-	StorageStateOffline    VSStorageState = "OFFLINE"
-	StorageStateFailed     VSStorageState = "FAILED"
-	StorageStateDisabled   VSStorageState = "DISABLED"
-	StorageStateEvacuating VSStorageState = "EVACUATING"
-	StorageStateEvacuated  VSStorageState = "EVACUATED"
+	StorageStateNone       = vidispine.StorageStateNone
+	StorageStateReady      = vidispine.StorageStateReady
+	StorageStateOffline    = vidispine.StorageStateOffline
+	StorageStateFailed     = vidispine.StorageStateFailed
+	StorageStateDisabled   = vidispine.StorageStateDisabled
+	StorageStateEvacuating = vidispine.StorageStateEvacuating
+	StorageStateEvacuated  = vidispine.StorageStateEvacuated
 )
-
-type VSStorage struct {
-	Id            string            `json:"id"`
-	State         VSStorageState    `json:"state"`
-	Type          VSStorageType     `json:"type"`
-	Capacity      int64             `json:"capacity"`
-	FreeCapacity  int64             `json:"freeCapacity"`
-	Timestamp     string            `json:"timestamp"`
-	Methods       []VSStorageMethod `json:"method"`
-	LowWatermark  int64             `json:"lowWatermark"`
-	HighWatermark int64             `json:"highWatermark"`
-}
-
-type VSStoragesResponse struct {
-	Storage []VSStorage `json:"storage"`
-}
@@ -2,14 +2,11 @@ package vsstoragecheck
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
 	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/pagerduty"
-	"io/ioutil"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vidispine"
 	"log"
-	"net/http"
 	"time"
 )
 
@@ -19,48 +16,31 @@ type VSStorageCheck struct {
 	VidispinePasswd  string
 	PDIntegrationKey string
 	VidispineHttps   bool
+	Api              common.Endpoint //the API, if not set then port 8080 of VidispineHost is used with VidispineUser
+	FullThreshold    float64         //fraction of capacity in use before a storage counts as full, defaults to DefaultFullThreshold
 }
 
+const DefaultFullThreshold = 0.95
+
 func (c VSStorageCheck) Name() string {
 	return "Vidispine storages check"
 }
 
-func (c VSStorageCheck) loadStorageData() (*VSStoragesResponse, error) {
-	httpClient := http.Client{}
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancelFunc()
-
-	proto := "https"
-	if !c.VidispineHttps {
-		proto = "http"
-	}
-	storageUrl := fmt.Sprintf("%s://%s:8080/API/storage", proto, c.VidispineHost)
-	httpReq, reqErr := http.NewRequestWithContext(ctx, "GET", storageUrl, nil)
-	if reqErr != nil {
-		return nil, reqErr
-	}
-
-	authStr := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", c.VidispineUser, c.VidispinePasswd)))
-	httpReq.Header.Add("Authorization", fmt.Sprintf("Basic %s", authStr))
-	httpReq.Header.Add("Accept", "application/json")
-	response, httpErr := httpClient.Do(httpReq)
-	if httpErr != nil {
-		return nil, httpErr
-	}
-
-	defer response.Body.Close()
-
-	contentBytes, readErr := ioutil.ReadAll(response.Body)
-	if readErr != nil {
-		return nil, readErr
+/**
+returns where the API is, and how to log in to it
+*/
+func (c VSStorageCheck) apiEndpoint() common.Endpoint {
+	if c.Api.BaseUrl != "" {
+		return c.Api
 	}
+	api := common.HostEndpoint(c.VidispineHost, 8080, c.VidispineHttps)
+	api.User = c.VidispineUser
+	api.Password = c.VidispinePasswd
+	return api
+}
 
-	var storageResponse VSStoragesResponse
-	unmarshalErr := json.Unmarshal(contentBytes, &storageResponse)
-	if unmarshalErr != nil {
-		return nil, unmarshalErr
-	}
-	return &storageResponse, nil
+func (c VSStorageCheck) loadStorageData() (*VSStoragesResponse, error) {
+	return vidispine.Client{Api: c.apiEndpoint()}.Storages(context.Background())
 }
 
 func (c VSStorageCheck) CheckStorage(s *VSStorage, verboseMode bool) []*pagerduty.TriggerEvent {
@@ -103,11 +83,15 @@ func (c VSStorageCheck) CheckStorage(s *VSStorage, verboseMode bool) []*pagerdut
 		}
 	}
 
-	dangerlevel := float64(s.Capacity) * 0.05
+	fullThreshold := c.FullThreshold
+	if fullThreshold == 0 {
+		fullThreshold = DefaultFullThreshold
+	}
+	dangerlevel := float64(s.Capacity) * (1 - fullThreshold)
 
 	if float64(s.FreeCapacity) < dangerlevel {
 		nowTime := time.Now()
-		bodyText := fmt.Sprintf("%s storage %s is over 95%% full, at %s", s.Type, s.Id,
+		bodyText := fmt.Sprintf("%s storage %s is over %.0f%% full, at %s", s.Type, s.Id, fullThreshold*100,
 			common.FormatBytes(usedCap))
 
 		watermarkErr := pagerduty.NewTriggerEvent(fmt.Sprintf("Storage %s", s.Id),
@@ -128,7 +112,10 @@ func (c VSStorageCheck) Run(verboseMode bool) ([]*pagerduty.TriggerEvent, error)
 
 	storageInfo, err := c.loadStorageData()
 	if err != nil {
-		return nil, err
+		log.Print("ERROR could not load storages from Vidispine API: ", err)
+		return []*pagerduty.TriggerEvent{
+			vidispine.ErrorAlert(err, "vidispine storage check", c.PDIntegrationKey, "vsstoragecheck"),
+		}, err
 	}
 
 	problems := make([]*pagerduty.TriggerEvent, 0)
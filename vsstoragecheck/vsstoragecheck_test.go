@@ -1,6 +1,13 @@
 package vsstoragecheck
 
-import "testing"
+import (
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/common"
+	"gitlab.com/codmill/customer-projects/guardian/vidispine-monitor/vidispine"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
 
 func TestVSStorageCheck_CheckStorage_Capacity(t *testing.T) {
 	fakeStorage := VSStorage{
@@ -33,3 +40,41 @@ func TestVSStorageCheck_CheckStorage_Capacity(t *testing.T) {
 		t.Errorf("got unexpected alert count on over-capacity test, expected 2 got %d", len(overCapResults))
 	}
 }
+
+func TestVSStorageCheck_Run_errorClasses(t *testing.T) {
+	tests := []struct {
+		status      int
+		contentType string
+		body        string
+		class       string
+	}{
+		{http.StatusUnauthorized, "text/plain", "Authentication required", vidispine.ClassCredentialsRejected},
+		{http.StatusServiceUnavailable, "text/html", "<html><h1>503 Service Temporarily Unavailable</h1></html>", vidispine.ClassUnavailable},
+		{http.StatusOK, "text/html", "<html>please log in</html>", vidispine.ClassUnexpectedFormat},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+
+		c := VSStorageCheck{Api: common.Endpoint{BaseUrl: server.URL, User: "storagecheck", Password: "old"}, PDIntegrationKey: "key"}
+		alerts, err := c.Run(false)
+		server.Close()
+
+		if err == nil {
+			t.Errorf("expected an error for a %d response", test.status)
+		}
+		if len(alerts) != 1 {
+			t.Errorf("expected a single alert for a %d response, got %d", test.status, len(alerts))
+			continue
+		}
+		if alerts[0].Payload.Class != test.class || alerts[0].DeDupKey != "vsstoragecheck-"+test.class {
+			t.Errorf("expected class %s for a %d response, got class %s and dedup key %s", test.class, test.status, alerts[0].Payload.Class, alerts[0].DeDupKey)
+		}
+		if !strings.Contains(alerts[0].Payload.Summary, test.body) {
+			t.Errorf("expected the summary to include the response body, got '%s'", alerts[0].Payload.Summary)
+		}
+	}
+}